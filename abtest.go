@@ -0,0 +1,482 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	sendpost "github.com/sendpost/sendpost-go-sdk"
+)
+
+// A/B test settings
+const (
+	campaignVariantHeader = "X-Campaign-Variant"
+	defaultTestPercent    = 20
+	defaultTestWindow     = 4 * time.Hour
+	metricOpens           = "opens"
+	metricClicks          = "clicks"
+	winnerGroupSuffix     = "winner"
+)
+
+// variantNamePattern keeps variant names usable in group names
+var variantNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,15}$`)
+
+// CampaignVariant is one version of a campaign's content
+// Template and TemplateVersion default to the campaign's; Subject replaces the rendered
+// subject line when set.
+type CampaignVariant struct {
+	Name            string `json:"name"`
+	Template        string `json:"template,omitempty"`
+	TemplateVersion string `json:"templateVersion,omitempty"`
+	Subject         string `json:"subject,omitempty"`
+}
+
+// ABTest configures and records the test phase of a campaign
+//
+// TestPercent of the audience is split evenly across the variants and sent first. Once
+// Window has passed, the variant with the best Metric rate is sent to the rest.
+type ABTest struct {
+	TestPercent int       `json:"testPercent"`
+	Window      string    `json:"window"`
+	Metric      string    `json:"metric"`
+	TestSendAt  time.Time `json:"testSendAt,omitempty"`
+	Winner      string    `json:"winner,omitempty"`
+	WinnerAt    time.Time `json:"winnerAt,omitempty"`
+}
+
+// window returns the test window duration
+func (t *ABTest) window() time.Duration {
+	window, err := time.ParseDuration(t.Window)
+	if err != nil {
+		return defaultTestWindow
+	}
+	return window
+}
+
+// validateABTest returns the problems with the campaign's variants and test settings
+func (c *Campaign) validateABTest() []string {
+	if len(c.Variants) == 0 {
+		return nil
+	}
+
+	var problems []string
+	if len(c.Variants) < 2 {
+		problems = append(problems, "an A/B test needs at least two variants")
+	}
+	seen := map[string]bool{}
+	for _, variant := range c.Variants {
+		if !variantNamePattern.MatchString(variant.Name) {
+			problems = append(problems, fmt.Sprintf("variant name %q must be up to 16 lowercase letters, digits, '-' or '_'", variant.Name))
+		}
+		if variant.Name == winnerGroupSuffix {
+			problems = append(problems, fmt.Sprintf("variant name %q is reserved for the winner's send", variant.Name))
+		}
+		if seen[variant.Name] {
+			problems = append(problems, fmt.Sprintf("variant %q is defined twice", variant.Name))
+		}
+		seen[variant.Name] = true
+		if variant.Template == "" && c.Template == "" {
+			problems = append(problems, fmt.Sprintf("variant %q needs a template", variant.Name))
+		}
+	}
+
+	if c.ABTest == nil {
+		return append(problems, "A/B test settings are missing")
+	}
+	if c.ABTest.TestPercent < 1 || c.ABTest.TestPercent > 99 {
+		problems = append(problems, "test percent must be between 1 and 99")
+	}
+	if window, err := time.ParseDuration(c.ABTest.Window); err != nil || window <= 0 {
+		problems = append(problems, fmt.Sprintf("test window %q must be a positive duration such as 4h", c.ABTest.Window))
+	}
+	if c.ABTest.Metric != metricOpens && c.ABTest.Metric != metricClicks {
+		problems = append(problems, fmt.Sprintf("metric %q must be %s or %s", c.ABTest.Metric, metricOpens, metricClicks))
+	}
+	return problems
+}
+
+// variant returns a variant by name
+func (c *Campaign) variant(name string) (CampaignVariant, bool) {
+	for _, variant := range c.Variants {
+		if variant.Name == name {
+			return variant, true
+		}
+	}
+	return CampaignVariant{}, false
+}
+
+// variantGroup is the group that tags the messages of one variant
+func (c *Campaign) variantGroup(name string) string {
+	return c.ID + "-" + name
+}
+
+// winnerGroup is the group that tags the winner's send to the holdout, kept apart from the
+// variant groups so the test results are not mixed with the winner's traffic
+func (c *Campaign) winnerGroup() string {
+	return c.ID + "-" + winnerGroupSuffix
+}
+
+// variantOptions returns the bulk options for sending one variant tagged with group
+func (c *Campaign) variantOptions(variant CampaignVariant, group string) BulkOptions {
+	opts := c.bulkOptions()
+	if variant.Template != "" {
+		opts.Template = variant.Template
+		opts.TemplateVersion = variant.TemplateVersion
+	}
+	opts.Subject = variant.Subject
+	opts.Groups = append(opts.Groups, group)
+	opts.Headers[campaignVariantHeader] = variant.Name
+	return opts
+}
+
+// splitAudience shuffles recipients with a seed derived from the campaign ID, so the split
+// is random but the same on every launch, and divides them into one test slice per
+// variant and the holdout that later receives the winner
+func splitAudience(c *Campaign, recipients []sendpost.Recipient) ([][]sendpost.Recipient, []sendpost.Recipient) {
+	shuffled := append([]sendpost.Recipient{}, recipients...)
+	seed := fnv.New64a()
+	seed.Write([]byte(c.ID))
+	rand.New(rand.NewSource(int64(seed.Sum64()))).Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+
+	testSize := (len(shuffled)*c.ABTest.TestPercent + 99) / 100
+	if testSize < len(c.Variants) {
+		testSize = len(c.Variants)
+	}
+	if testSize > len(shuffled) {
+		testSize = len(shuffled)
+	}
+
+	slices := make([][]sendpost.Recipient, len(c.Variants))
+	for i, recipient := range shuffled[:testSize] {
+		slices[i%len(c.Variants)] = append(slices[i%len(c.Variants)], recipient)
+	}
+	return slices, shuffled[testSize:]
+}
+
+// holdoutPath is the file that keeps the recipients waiting for the winner
+func (s *CampaignStore) holdoutPath(id string) string {
+	return filepath.Join(s.dir, id+".holdout.jsonl")
+}
+
+// writeHoldout stores recipients as JSONL so the winner can be sent to them later
+func (s *CampaignStore) writeHoldout(id string, recipients []sendpost.Recipient) error {
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return fmt.Errorf("could not create campaigns directory: %w", err)
+	}
+	file, err := os.OpenFile(s.holdoutPath(id), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("could not save holdout audience: %w", err)
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	for _, recipient := range recipients {
+		if err := encoder.Encode(recipient); err != nil {
+			return err
+		}
+	}
+	return file.Sync()
+}
+
+// parseVariantFlags builds variants from --variant NAME[=TEMPLATE[@VERSION]] and
+// --variant-subject NAME=SUBJECT values
+func parseVariantFlags(variantFlags, subjectFlags []string) ([]CampaignVariant, error) {
+	var variants []CampaignVariant
+	for _, value := range variantFlags {
+		name, template, _ := strings.Cut(value, "=")
+		template, version, _ := strings.Cut(template, "@")
+		variants = append(variants, CampaignVariant{Name: name, Template: template, TemplateVersion: version})
+	}
+	for _, value := range subjectFlags {
+		name, subject, ok := strings.Cut(value, "=")
+		if !ok {
+			return nil, fmt.Errorf("variant subject %q must be in name=subject form", value)
+		}
+		found := false
+		for i := range variants {
+			if variants[i].Name == name {
+				variants[i].Subject = subject
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("variant subject %q refers to an undefined variant", value)
+		}
+	}
+	return variants, nil
+}
+
+// launchABTest queues one test slice per variant and stores the holdout audience
+func (e *ESPExample) launchABTest(c *Campaign, recipients []sendpost.Recipient, queue *SendQueue) error {
+	slices, holdout := splitAudience(c, recipients)
+	fmt.Printf("  A/B test: %d%% of the audience across %d variant(s), %d recipient(s) wait for the winner\n",
+		c.ABTest.TestPercent, len(c.Variants), len(holdout))
+
+	for i, variant := range c.Variants {
+		requests, queued, err := e.enqueueCampaign(queue, c.variantOptions(variant, c.variantGroup(variant.Name)), slices[i])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("  Variant %s: %d request(s) for %d recipient(s)\n", variant.Name, requests, queued)
+	}
+
+	if err := e.campaignStore().writeHoldout(c.ID, holdout); err != nil {
+		return err
+	}
+
+	c.ABTest.TestSendAt = time.Now().UTC()
+	if c.SendAt != "" {
+		if at, err := resolveSendAt(c.SendAt, time.Local, time.Now()); err == nil && at.After(c.ABTest.TestSendAt) {
+			c.ABTest.TestSendAt = at.UTC()
+		}
+	}
+	fmt.Printf("  Winner by %s can be picked after %s\n", c.ABTest.Metric, formatTime(c.ABTest.TestSendAt.Add(c.ABTest.window())))
+	return nil
+}
+
+// VariantMetrics are the engagement figures of one variant
+type VariantMetrics struct {
+	Variant string
+	Sent    int
+	Opened  int
+	Clicked int
+}
+
+// rate returns the share of sent messages that reached the metric
+func (m VariantMetrics) rate(metric string) float64 {
+	if m.Sent == 0 {
+		return 0
+	}
+	if metric == metricClicks {
+		return float64(m.Clicked) / float64(m.Sent)
+	}
+	return float64(m.Opened) / float64(m.Sent)
+}
+
+// variantMetrics counts sent messages per variant from the queue and opens/clicks from
+// either a webhook events file (when eventsFile is set) or the group stats API
+func (e *ESPExample) variantMetrics(c *Campaign, queue *SendQueue, eventsFile string) ([]VariantMetrics, error) {
+	metrics := make([]VariantMetrics, len(c.Variants))
+	index := map[string]int{}
+	for i, variant := range c.Variants {
+		metrics[i].Variant = variant.Name
+		index[variant.Name] = i
+	}
+
+	// Only the test slices count; the winner's send to the holdout carries the same header,
+	// but its own group, so the group stats below only see test traffic
+	for _, entry := range campaignEntries(queue, c.ID, statusSent, statusFailed) {
+		i, ok := index[entry.Message.GetHeaders()[campaignVariantHeader]]
+		if !ok || (!c.ABTest.WinnerAt.IsZero() && !entry.EnqueuedAt.Before(c.ABTest.WinnerAt)) {
+			continue
+		}
+		for _, result := range entry.Results {
+			if result.Error == "" && result.Skipped == "" {
+				metrics[i].Sent++
+			}
+		}
+	}
+
+	if eventsFile != "" {
+		return metrics, countVariantEvents(c, eventsFile, metrics, index)
+	}
+
+	// Tests read long after their send are summed over several stats windows
+	since := c.ABTest.TestSendAt.Local()
+	r := lastDays(1, time.Now())
+	r.From = time.Date(since.Year(), since.Month(), since.Day(), 0, 0, 0, 0, time.UTC)
+	for i, variant := range c.Variants {
+		group := c.variantGroup(variant.Name)
+		stat, err := fetchAggregate(r, func(from, to string) (*sendpost.AggregateStat, error) {
+			ctx, cancel := context.WithTimeout(e.createAccountAuthContext(), statsRequestTimeout)
+			defer cancel()
+			stat, resp, err := e.client.StatsAAPI.GetAccountAggregateStatsByGroup(ctx).
+				Group(group).
+				From(from).
+				To(to).
+				Execute()
+			if err != nil {
+				return nil, apiError("could not get stats for variant "+variant.Name, resp, err)
+			}
+			return stat, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		metrics[i].Opened = int(stat.GetOpened())
+		metrics[i].Clicked = int(stat.GetClicked())
+	}
+	return metrics, nil
+}
+
+// countVariantEvents counts unique opens and clicks per variant in a JSONL file of webhook
+// payloads, as stored by a webhook endpoint; each line is a webhook object or a bare event
+func countVariantEvents(c *Campaign, path string, metrics []VariantMetrics, index map[string]int) error {
+	groups := map[string]int{}
+	for name, i := range index {
+		groups[c.variantGroup(name)] = i
+	}
+
+	seen := map[string]bool{}
+	return readEvents(path, func(event *sendpost.Event) {
+		if event.GetType() != eventTypeOpened && event.GetType() != eventTypeClicked {
+			return
+		}
+
+		for _, group := range event.Groups {
+			i, ok := groups[group]
+			if !ok {
+				continue
+			}
+			// Count each message once per event type, like unique opens and clicks
+			key := fmt.Sprintf("%d/%s", event.GetType(), event.GetMessageID())
+			if seen[key] {
+				break
+			}
+			seen[key] = true
+			if event.GetType() == eventTypeOpened {
+				metrics[i].Opened++
+			} else {
+				metrics[i].Clicked++
+			}
+			break
+		}
+	})
+}
+
+// pickWinner returns the variant with the highest metric rate; ties go to the earlier variant
+func pickWinner(metrics []VariantMetrics, metric string) VariantMetrics {
+	best := metrics[0]
+	for _, m := range metrics[1:] {
+		if m.rate(metric) > best.rate(metric) {
+			best = m
+		}
+	}
+	return best
+}
+
+// printVariantMetrics prints a table of variant results
+func printVariantMetrics(metrics []VariantMetrics, metric string) {
+	fmt.Printf("  %-16s %8s %8s %8s %10s\n", "Variant", "Sent", "Opened", "Clicked", metric+" %")
+	for _, m := range metrics {
+		fmt.Printf("  %-16s %8d %8d %8d %9.1f%%\n", m.Variant, m.Sent, m.Opened, m.Clicked, 100*m.rate(metric))
+	}
+}
+
+// PrintABTestResults prints the current results of a campaign's A/B test
+func (e *ESPExample) PrintABTestResults(id, eventsFile string) error {
+	c, queue, err := e.openABTest(id)
+	if err != nil {
+		return err
+	}
+	defer queue.Close()
+
+	metrics, err := e.variantMetrics(c, queue, eventsFile)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("A/B test results for %s (%s), metric %s:\n", c.Name, c.ID, c.ABTest.Metric)
+	printVariantMetrics(metrics, c.ABTest.Metric)
+	if c.ABTest.Winner != "" {
+		fmt.Printf("  Winner: %s (sent to the rest at %s)\n", c.ABTest.Winner, formatTime(c.ABTest.WinnerAt))
+	} else if closes := c.ABTest.TestSendAt.Add(c.ABTest.window()); time.Now().Before(closes) {
+		fmt.Printf("  Test window closes at %s\n", formatTime(closes))
+	}
+	return nil
+}
+
+// openABTest loads a campaign that has an A/B test and opens its queue
+func (e *ESPExample) openABTest(id string) (*Campaign, *SendQueue, error) {
+	c, err := e.campaignStore().Get(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(c.Variants) == 0 || c.ABTest == nil {
+		return nil, nil, fmt.Errorf("campaign %s has no A/B test", c.ID)
+	}
+	if c.Status == campaignDraft {
+		return nil, nil, fmt.Errorf("campaign %s has not been launched", c.ID)
+	}
+
+	queue, err := e.openQueue(c.QueueDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, queue, nil
+}
+
+// SendABTestWinner picks the variant with the best metric once the test window has passed
+// (or earlier with force) and queues it for the holdout audience
+// A variant name in winner overrides the automatic pick.
+func (e *ESPExample) SendABTestWinner(id, eventsFile, winner string, force bool) error {
+	fmt.Println("\n=== Send A/B Test Winner ===")
+
+	c, queue, err := e.openABTest(id)
+	if err != nil {
+		return err
+	}
+	defer queue.Close()
+
+	switch {
+	case c.ABTest.Winner != "":
+		return fmt.Errorf("campaign %s already sent winner %s", c.ID, c.ABTest.Winner)
+	case c.Status != campaignTesting:
+		return fmt.Errorf("campaign %s is %s; resume it before picking a winner", c.ID, c.Status)
+	}
+	if closes := c.ABTest.TestSendAt.Add(c.ABTest.window()); time.Now().Before(closes) && !force {
+		return fmt.Errorf("the test window closes at %s; wait or use --force", formatTime(closes))
+	}
+	if pending := campaignEntries(queue, c.ID, statusPending, statusInFlight); len(pending) > 0 && !force {
+		return fmt.Errorf("%d test request(s) have not been sent yet; run the scheduler or use --force", len(pending))
+	}
+
+	metrics, err := e.variantMetrics(c, queue, eventsFile)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Campaign: %s (%s), metric %s\n", c.Name, c.ID, c.ABTest.Metric)
+	printVariantMetrics(metrics, c.ABTest.Metric)
+
+	if winner == "" {
+		winner = pickWinner(metrics, c.ABTest.Metric).Variant
+	}
+	variant, ok := c.variant(winner)
+	if !ok {
+		return fmt.Errorf("campaign %s has no variant %q", c.ID, winner)
+	}
+
+	holdout, err := LoadRecipients(e.campaignStore().holdoutPath(c.ID))
+	if err != nil {
+		return fmt.Errorf("could not read holdout audience: %w", err)
+	}
+
+	// Record the pick time first so the winner's requests are told apart from the test
+	winnerAt := time.Now().UTC()
+	opts := c.variantOptions(variant, c.winnerGroup())
+	opts.SendAt = ""
+	requests, queued, err := e.enqueueCampaign(queue, opts, holdout)
+	if err != nil {
+		return err
+	}
+
+	c.ABTest.Winner = variant.Name
+	c.ABTest.WinnerAt = winnerAt
+	c.Status = campaignLaunched
+	if err := e.campaignStore().Save(c); err != nil {
+		return err
+	}
+
+	fmt.Printf("✓ Variant %s won; queued %d request(s) for the remaining %d recipient(s)\n", variant.Name, requests, queued)
+	fmt.Println("  The scheduler sends it when due: go run . schedule run")
+	return nil
+}
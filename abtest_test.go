@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func testABCampaign() *Campaign {
+	return &Campaign{
+		ID:       "spring-sale",
+		Name:     "Spring Sale",
+		Template: "special-offer",
+		Audience: "customers.csv",
+		Variants: []CampaignVariant{{Name: "a"}, {Name: "b", Subject: "Last chance"}},
+		ABTest:   &ABTest{TestPercent: 20, Window: "4h", Metric: metricClicks},
+	}
+}
+
+func TestValidateABTest(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *Campaign)
+		want   string
+	}{
+		{"valid", func(c *Campaign) {}, ""},
+		{"one variant", func(c *Campaign) { c.Variants = c.Variants[:1] }, "at least two variants"},
+		{"reserved name", func(c *Campaign) { c.Variants[1].Name = "winner" }, "reserved for the winner"},
+		{"bad name", func(c *Campaign) { c.Variants[1].Name = "B" }, "lowercase"},
+		{"duplicate", func(c *Campaign) { c.Variants[1].Name = "a" }, "defined twice"},
+		{"bad percent", func(c *Campaign) { c.ABTest.TestPercent = 100 }, "between 1 and 99"},
+		{"bad window", func(c *Campaign) { c.ABTest.Window = "-1h" }, "positive duration"},
+		{"bad metric", func(c *Campaign) { c.ABTest.Metric = "bounces" }, "must be opens or clicks"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testABCampaign()
+			tt.change(c)
+			problems := strings.Join(c.validateABTest(), "; ")
+			if tt.want == "" && problems != "" || !strings.Contains(problems, tt.want) {
+				t.Errorf("validateABTest() = %q, want %q", problems, tt.want)
+			}
+		})
+	}
+}
+
+func TestWinnerOptionsUseOwnGroup(t *testing.T) {
+	c := testABCampaign()
+	variant := c.Variants[1]
+
+	test := c.variantOptions(variant, c.variantGroup(variant.Name))
+	winner := c.variantOptions(variant, c.winnerGroup())
+	if !containsString(test.Groups, "spring-sale-b") {
+		t.Errorf("test groups = %v, want the variant group", test.Groups)
+	}
+	if containsString(winner.Groups, "spring-sale-b") || !containsString(winner.Groups, "spring-sale-winner") {
+		t.Errorf("winner groups = %v, want the winner group only", winner.Groups)
+	}
+	if winner.Subject != "Last chance" || winner.Headers[campaignVariantHeader] != "b" {
+		t.Errorf("winner options = %+v, want the variant's content", winner)
+	}
+}
+
+func TestPickWinner(t *testing.T) {
+	metrics := []VariantMetrics{
+		{Variant: "a", Sent: 100, Opened: 40, Clicked: 5},
+		{Variant: "b", Sent: 50, Opened: 10, Clicked: 5},
+		{Variant: "c", Sent: 0},
+	}
+	tests := map[string]string{metricOpens: "a", metricClicks: "b"}
+	for metric, want := range tests {
+		if got := pickWinner(metrics, metric).Variant; got != want {
+			t.Errorf("pickWinner(%s) = %s, want %s", metric, got, want)
+		}
+	}
+	tie := []VariantMetrics{{Variant: "a", Sent: 10, Opened: 1}, {Variant: "b", Sent: 10, Opened: 1}}
+	if got := pickWinner(tie, metricOpens).Variant; got != "a" {
+		t.Errorf("tie went to %s, want the earlier variant", got)
+	}
+}
+
+func containsString(values []string, want string) bool {
+	for _, v := range values {
+		if v == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	sendpost "github.com/sendpost/sendpost-go-sdk"
+)
+
+// Attachment limits, checked against the raw file size before base64 encoding
+const (
+	maxAttachmentSize      = 10 * 1024 * 1024
+	maxTotalAttachmentSize = 20 * 1024 * 1024
+)
+
+// blockedAttachmentExtensions are rejected because major mailbox providers refuse them
+var blockedAttachmentExtensions = map[string]bool{
+	".exe": true, ".bat": true, ".cmd": true, ".com": true, ".js": true,
+	".jar": true, ".msi": true, ".scr": true, ".vbs": true, ".ps1": true,
+}
+
+// attachmentFile is one file to be attached to a message
+type attachmentFile struct {
+	filename    string
+	contentType string
+	content     []byte
+	inline      bool
+}
+
+// Attachments collects regular attachments and inline images for a message
+//
+// The SendPost attachment model only carries a filename and base64 content, so an inline
+// image is attached under its Content-ID as filename and referenced from the HTML body
+// as <img src="cid:CONTENT-ID">. The Content-ID therefore keeps the image's extension,
+// which SendPost uses to pick the content type.
+type Attachments struct {
+	files     []attachmentFile
+	totalSize int
+}
+
+// NewAttachments creates an empty attachment set
+func NewAttachments() *Attachments {
+	return &Attachments{}
+}
+
+// AddFile attaches a file from disk under its base name
+func (a *Attachments) AddFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read attachment: %w", err)
+	}
+	return a.AddBytes(filepath.Base(path), content)
+}
+
+// AddBytes attaches in-memory content under the given filename
+func (a *Attachments) AddBytes(filename string, content []byte) error {
+	file, err := a.newFile(filename, content)
+	if err != nil {
+		return err
+	}
+	a.files = append(a.files, file)
+	a.totalSize += len(content)
+	return nil
+}
+
+// AddInlineImage embeds an image file that the HTML body references as cid:<contentID>
+// An empty contentID uses the file's base name. The Content-ID is returned.
+func (a *Attachments) AddInlineImage(path, contentID string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("could not read inline image: %w", err)
+	}
+	if contentID == "" {
+		contentID = filepath.Base(path)
+	}
+	return a.AddInlineBytes(contentID, content)
+}
+
+// AddInlineBytes embeds in-memory image content under the given Content-ID
+// An extension is appended to a Content-ID without one, so the returned Content-ID is
+// the one the HTML body must reference.
+func (a *Attachments) AddInlineBytes(contentID string, content []byte) (string, error) {
+	file, err := a.newFile(contentID, content)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(file.contentType, "image/") {
+		return "", fmt.Errorf("inline image %s has content type %s, expected an image", contentID, file.contentType)
+	}
+	for _, existing := range a.files {
+		if existing.inline && existing.filename == file.filename {
+			return "", fmt.Errorf("inline image Content-ID %q is already in use", file.filename)
+		}
+	}
+
+	file.inline = true
+	a.files = append(a.files, file)
+	a.totalSize += len(content)
+	return file.filename, nil
+}
+
+// Merge adds the files of another attachment set, checking the combined size limit
+func (a *Attachments) Merge(other *Attachments) error {
+	if other.Len() == 0 {
+		return nil
+	}
+	for _, file := range other.files {
+		if a.totalSize+len(file.content) > maxTotalAttachmentSize {
+			return fmt.Errorf("attachment %s would bring the total to %d KB; the limit per message is %d KB", file.filename, (a.totalSize+len(file.content))/1024, maxTotalAttachmentSize/1024)
+		}
+		a.files = append(a.files, file)
+		a.totalSize += len(file.content)
+	}
+	return nil
+}
+
+// newFile validates an attachment and detects its content type
+func (a *Attachments) newFile(filename string, content []byte) (attachmentFile, error) {
+	if filename == "" {
+		return attachmentFile{}, fmt.Errorf("attachment filename is required")
+	}
+	if len(content) == 0 {
+		return attachmentFile{}, fmt.Errorf("attachment %s is empty", filename)
+	}
+	if len(content) > maxAttachmentSize {
+		return attachmentFile{}, fmt.Errorf("attachment %s is %d KB; the limit per file is %d KB", filename, len(content)/1024, maxAttachmentSize/1024)
+	}
+	if a.totalSize+len(content) > maxTotalAttachmentSize {
+		return attachmentFile{}, fmt.Errorf("attachment %s would bring the total to %d KB; the limit per message is %d KB", filename, (a.totalSize+len(content))/1024, maxTotalAttachmentSize/1024)
+	}
+
+	ext := strings.ToLower(filepath.Ext(filename))
+	if blockedAttachmentExtensions[ext] {
+		return attachmentFile{}, fmt.Errorf("attachment %s has a blocked file type (%s)", filename, ext)
+	}
+
+	contentType := detectContentType(filename, content)
+	if ext == "" {
+		// Give extension-less files one matching their content so the recipient can open them
+		if exts, err := mime.ExtensionsByType(contentType); err == nil && len(exts) > 0 {
+			filename += exts[0]
+		}
+	}
+
+	return attachmentFile{filename: filename, contentType: contentType, content: content}, nil
+}
+
+// detectContentType determines the MIME type from the file extension, falling back to
+// sniffing the content
+func detectContentType(filename string, content []byte) string {
+	if contentType := mime.TypeByExtension(strings.ToLower(filepath.Ext(filename))); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err == nil {
+			return mediaType
+		}
+	}
+	mediaType, _, _ := mime.ParseMediaType(http.DetectContentType(content))
+	return mediaType
+}
+
+// Len returns the number of attached files, including inline images
+func (a *Attachments) Len() int {
+	if a == nil {
+		return 0
+	}
+	return len(a.files)
+}
+
+// Apply adds the base64-encoded files to the attachments of an email message
+func (a *Attachments) Apply(emailMessage *sendpost.EmailMessageObject) {
+	if a.Len() == 0 {
+		return
+	}
+
+	attachments := emailMessage.GetAttachments()
+	for _, file := range a.files {
+		attachment := sendpost.NewAttachment()
+		attachment.SetFilename(file.filename)
+		attachment.SetContent(base64.StdEncoding.EncodeToString(file.content))
+		attachments = append(attachments, *attachment)
+	}
+	emailMessage.SetAttachments(attachments)
+}
+
+// Print lists the attached files
+func (a *Attachments) Print() {
+	if a == nil {
+		return
+	}
+	for _, file := range a.files {
+		kind := "Attachment"
+		if file.inline {
+			kind = "Inline image"
+		}
+		fmt.Printf("  %s: %s (%s, %d KB)\n", kind, file.filename, file.contentType, (len(file.content)+1023)/1024)
+	}
+}
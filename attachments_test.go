@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	sendpost "github.com/sendpost/sendpost-go-sdk"
+)
+
+func TestAttachmentsAddBytes(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		content  []byte
+		wantName string
+		wantType string
+		wantErr  string
+	}{
+		{"type from extension", "invoice.pdf", []byte("not really a pdf"), "invoice.pdf", "application/pdf", ""},
+		{"extension wins over content", "report.csv", testPNG, "report.csv", "text/csv", ""},
+		{"sniffed image gets an extension", "logo", testPNG, "logo.png", "image/png", ""},
+		{"sniffed pdf gets an extension", "scan", []byte("%PDF-1.4 scan"), "scan.pdf", "application/pdf", ""},
+		{"blocked extension", "setup.exe", []byte("MZ"), "", "", "blocked file type (.exe)"},
+		{"blocked extension in capitals", "RUN.BAT", []byte("echo"), "", "", "blocked file type (.bat)"},
+		{"blocked script", "macro.ps1", []byte("Write-Host"), "", "", "blocked file type (.ps1)"},
+		{"empty content", "empty.txt", nil, "", "", "is empty"},
+		{"no filename", "", []byte("x"), "", "", "filename is required"},
+		{"file over the limit", "big.bin", make([]byte, maxAttachmentSize+1), "", "", "the limit per file is"},
+		{"file at the limit", "big.bin", make([]byte, maxAttachmentSize), "big.bin", "application/octet-stream", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAttachments()
+			err := a.AddBytes(tt.filename, tt.content)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("AddBytes() error = %v, want %q", err, tt.wantErr)
+				}
+				if a.Len() != 0 {
+					t.Errorf("rejected file was attached")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if file := a.files[0]; file.filename != tt.wantName || file.contentType != tt.wantType || file.inline {
+				t.Errorf("attached %s (%s, inline %v), want %s (%s)", file.filename, file.contentType, file.inline, tt.wantName, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestAttachmentsTotalSize(t *testing.T) {
+	half := make([]byte, maxTotalAttachmentSize/2)
+	a := NewAttachments()
+	if err := a.AddBytes("one.bin", half); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.AddBytes("two.bin", half); err != nil {
+		t.Fatalf("total exactly at the limit: %v", err)
+	}
+	if err := a.AddBytes("three.bin", []byte("x")); err == nil || !strings.Contains(err.Error(), "the limit per message is") {
+		t.Errorf("AddBytes() over the total error = %v", err)
+	}
+	if _, err := a.AddInlineBytes("logo.png", testPNG); err == nil {
+		t.Error("AddInlineBytes() over the total succeeded")
+	}
+
+	other := NewAttachments()
+	if err := other.AddBytes("extra.bin", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Merge(other); err == nil || !strings.Contains(err.Error(), "extra.bin would bring the total") {
+		t.Errorf("Merge() over the total error = %v", err)
+	}
+	if a.Len() != 2 {
+		t.Errorf("%d files attached, want 2", a.Len())
+	}
+}
+
+func TestAttachmentsInlineImages(t *testing.T) {
+	tests := []struct {
+		name      string
+		contentID string
+		content   []byte
+		want      string
+		wantErr   string
+	}{
+		{"named by content id", "logo.png", testPNG, "logo.png", ""},
+		{"extension appended to content id", "banner", testPNG, "banner.png", ""},
+		{"not an image", "terms.pdf", []byte("%PDF-1.4"), "", "expected an image"},
+		{"sniffed non-image", "terms", []byte("%PDF-1.4"), "", "expected an image"},
+		{"content id already used", "header.png", testPNG, "", "already in use"},
+		{"renamed content id already used", "header", testPNG, "", "already in use"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAttachments()
+			if _, err := a.AddInlineBytes("header.png", testPNG); err != nil {
+				t.Fatal(err)
+			}
+			got, err := a.AddInlineBytes(tt.contentID, tt.content)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("AddInlineBytes() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want || !a.files[1].inline {
+				t.Errorf("AddInlineBytes() = %q (inline %v), want %q", got, a.files[1].inline, tt.want)
+			}
+		})
+	}
+
+	// A regular attachment may share the name of an inline image
+	a := NewAttachments()
+	if _, err := a.AddInlineBytes("logo.png", testPNG); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.AddBytes("logo.png", testPNG); err != nil {
+		t.Errorf("AddBytes() with an inline image's name: %v", err)
+	}
+}
+
+func TestAttachmentsApply(t *testing.T) {
+	a := NewAttachments()
+	if err := a.AddBytes("invoice.pdf", []byte("%PDF-1.4 invoice")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.AddInlineBytes("logo", testPNG); err != nil {
+		t.Fatal(err)
+	}
+
+	message := sendpost.NewEmailMessageObject()
+	existing := sendpost.NewAttachment()
+	existing.SetFilename("existing.txt")
+	existing.SetContent(base64.StdEncoding.EncodeToString([]byte("kept")))
+	message.SetAttachments([]sendpost.Attachment{*existing})
+	a.Apply(message)
+
+	attachments := message.GetAttachments()
+	want := []struct {
+		filename string
+		content  []byte
+	}{
+		{"existing.txt", []byte("kept")},
+		{"invoice.pdf", []byte("%PDF-1.4 invoice")},
+		{"logo.png", testPNG},
+	}
+	if len(attachments) != len(want) {
+		t.Fatalf("%d attachments, want %d", len(attachments), len(want))
+	}
+	for i, w := range want {
+		content, err := base64.StdEncoding.DecodeString(attachments[i].GetContent())
+		if err != nil || attachments[i].GetFilename() != w.filename || !bytes.Equal(content, w.content) {
+			t.Errorf("attachment %d = %s (%v), want %s", i, attachments[i].GetFilename(), err, w.filename)
+		}
+	}
+
+	// An empty set leaves the message alone
+	empty := sendpost.NewEmailMessageObject()
+	NewAttachments().Apply(empty)
+	if empty.Attachments != nil {
+		t.Errorf("empty set added attachments: %v", empty.GetAttachments())
+	}
+}
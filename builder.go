@@ -0,0 +1,352 @@
+package main
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+
+	sendpost "github.com/sendpost/sendpost-go-sdk"
+)
+
+// reservedHeaders are set by SendPost from the message fields and cannot be passed as custom headers
+var reservedHeaders = map[string]string{
+	"from":         "From",
+	"to":           "To",
+	"cc":           "Cc",
+	"bcc":          "Bcc",
+	"reply-to":     "ReplyTo",
+	"subject":      "Subject",
+	"content-type": "HTML/Text",
+	"mime-version": "",
+	"date":         "",
+	"message-id":   "",
+}
+
+// ValidationError lists every problem found while building an email message
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid email message: " + strings.Join(e.Problems, "; ")
+}
+
+// EmailBuilder assembles a SendPost EmailMessageObject with a fluent API
+// Problems are collected while building and reported together by Build, so a chain of
+// calls never needs intermediate error checks. The builder never touches the network.
+type EmailBuilder struct {
+	message     *sendpost.EmailMessageObject
+	recipients  []sendpost.Recipient
+	headers     map[string]string
+	attachments *Attachments
+	problems    []string
+	warnings    []string
+}
+
+// NewEmailBuilder creates an empty builder
+func NewEmailBuilder() *EmailBuilder {
+	return &EmailBuilder{
+		message: sendpost.NewEmailMessageObject(),
+		headers: map[string]string{},
+	}
+}
+
+// parseAddress validates an RFC 5322 address and returns its address part
+func (b *EmailBuilder) parseAddress(field, email string) (string, bool) {
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Name != "" {
+		b.problems = append(b.problems, fmt.Sprintf("%s address %q is not a valid RFC 5322 address", field, email))
+		return "", false
+	}
+	return address.Address, true
+}
+
+// From sets the sender address and display name
+func (b *EmailBuilder) From(email, name string) *EmailBuilder {
+	if address, ok := b.parseAddress("from", email); ok {
+		from := sendpost.NewEmailAddress()
+		from.SetEmail(address)
+		if name != "" {
+			from.SetName(name)
+		}
+		b.message.SetFrom(*from)
+	}
+	return b
+}
+
+// ReplyTo sets the address replies go to
+func (b *EmailBuilder) ReplyTo(email, name string) *EmailBuilder {
+	if address, ok := b.parseAddress("reply-to", email); ok {
+		replyTo := sendpost.NewEmailAddress()
+		replyTo.SetEmail(address)
+		if name != "" {
+			replyTo.SetName(name)
+		}
+		b.message.SetReplyTo(*replyTo)
+	}
+	return b
+}
+
+// To adds a recipient; SendPost delivers a separate copy of the message to each one
+func (b *EmailBuilder) To(email, name string) *EmailBuilder {
+	if address, ok := b.parseAddress("to", email); ok {
+		to := sendpost.NewRecipient()
+		to.SetEmail(address)
+		if name != "" {
+			to.SetName(name)
+		}
+		b.recipients = append(b.recipients, *to)
+	}
+	return b
+}
+
+// Recipient adds a prepared recipient, including its cc, bcc and custom fields
+// The recipient is only added when its own and all its copy addresses are valid.
+func (b *EmailBuilder) Recipient(recipient sendpost.Recipient) *EmailBuilder {
+	_, valid := b.parseAddress("to", recipient.GetEmail())
+	for _, cc := range recipient.Cc {
+		if _, ok := b.parseAddress("cc", cc.GetEmail()); !ok {
+			valid = false
+		}
+	}
+	for _, bcc := range recipient.Bcc {
+		if _, ok := b.parseAddress("bcc", bcc.GetEmail()); !ok {
+			valid = false
+		}
+	}
+	if valid {
+		b.recipients = append(b.recipients, recipient)
+	}
+	return b
+}
+
+// lastRecipient returns the most recently added To recipient
+func (b *EmailBuilder) lastRecipient(method string) *sendpost.Recipient {
+	if len(b.recipients) == 0 {
+		b.problems = append(b.problems, method+" must follow a To recipient")
+		return nil
+	}
+	return &b.recipients[len(b.recipients)-1]
+}
+
+// copyTo builds a cc/bcc entry
+func (b *EmailBuilder) copyTo(field, email, name string) (sendpost.CopyTo, bool) {
+	address, ok := b.parseAddress(field, email)
+	if !ok {
+		return sendpost.CopyTo{}, false
+	}
+	copyTo := sendpost.NewCopyTo()
+	copyTo.SetEmail(address)
+	if name != "" {
+		copyTo.SetName(name)
+	}
+	return *copyTo, true
+}
+
+// Cc adds a carbon-copy address to the most recently added To recipient
+func (b *EmailBuilder) Cc(email, name string) *EmailBuilder {
+	if recipient := b.lastRecipient("Cc"); recipient != nil {
+		if cc, ok := b.copyTo("cc", email, name); ok {
+			recipient.Cc = append(recipient.Cc, cc)
+		}
+	}
+	return b
+}
+
+// Bcc adds a blind carbon-copy address to the most recently added To recipient
+func (b *EmailBuilder) Bcc(email, name string) *EmailBuilder {
+	if recipient := b.lastRecipient("Bcc"); recipient != nil {
+		if bcc, ok := b.copyTo("bcc", email, name); ok {
+			recipient.Bcc = append(recipient.Bcc, bcc)
+		}
+	}
+	return b
+}
+
+// Field sets a custom field on the most recently added To recipient
+func (b *EmailBuilder) Field(key string, value interface{}) *EmailBuilder {
+	if recipient := b.lastRecipient("Field"); recipient != nil {
+		if recipient.CustomFields == nil {
+			recipient.CustomFields = map[string]interface{}{}
+		}
+		recipient.CustomFields[key] = value
+	}
+	return b
+}
+
+// Subject sets the subject line
+func (b *EmailBuilder) Subject(subject string) *EmailBuilder {
+	if strings.ContainsAny(subject, "\r\n") {
+		b.problems = append(b.problems, "subject must not contain line breaks")
+		return b
+	}
+	b.message.SetSubject(subject)
+	return b
+}
+
+// HTML sets the HTML body
+func (b *EmailBuilder) HTML(html string) *EmailBuilder {
+	b.message.SetHtmlBody(html)
+	return b
+}
+
+// Text sets the plain-text body; when omitted it is derived from the HTML body
+func (b *EmailBuilder) Text(text string) *EmailBuilder {
+	b.message.SetTextBody(text)
+	return b
+}
+
+// AMP sets the AMP for Email body, shown by mail clients that support it
+func (b *EmailBuilder) AMP(amp string) *EmailBuilder {
+	b.message.SetAmpBody(amp)
+	return b
+}
+
+// Content sets the subject and bodies from a rendered template
+func (b *EmailBuilder) Content(rendered *RenderedEmail) *EmailBuilder {
+	b.Subject(rendered.Subject)
+	b.HTML(rendered.HtmlBody)
+	if rendered.TextBody != "" {
+		b.Text(rendered.TextBody)
+	}
+	return b
+}
+
+// Header adds a custom header
+// Names must be printable ASCII without colons, values must not contain line breaks,
+// and headers SendPost derives from message fields are rejected.
+func (b *EmailBuilder) Header(name, value string) *EmailBuilder {
+	if !validHeaderName(name) {
+		b.problems = append(b.problems, fmt.Sprintf("header name %q must be non-empty printable ASCII without spaces or colons", name))
+		return b
+	}
+	if method, reserved := reservedHeaders[strings.ToLower(name)]; reserved {
+		if method != "" {
+			b.problems = append(b.problems, fmt.Sprintf("header %q is set by SendPost; use %s instead", name, method))
+		} else {
+			b.problems = append(b.problems, fmt.Sprintf("header %q is set by SendPost and cannot be overridden", name))
+		}
+		return b
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		b.problems = append(b.problems, fmt.Sprintf("header %q value must not contain line breaks", name))
+		return b
+	}
+	b.headers[name] = value
+	return b
+}
+
+// IdempotencyKey sets the X-Idempotency-Key header; sends with a key that was already
+// used return the recorded result instead of sending again
+func (b *EmailBuilder) IdempotencyKey(key string) *EmailBuilder {
+	if key == "" || len(key) > maxIdempotencyKeyLength {
+		b.problems = append(b.problems, fmt.Sprintf("idempotency key must be 1 to %d characters", maxIdempotencyKeyLength))
+		return b
+	}
+	return b.Header(idempotencyHeader, key)
+}
+
+// validHeaderName reports whether name is a valid RFC 5322 field name
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if name[i] < 33 || name[i] > 126 || name[i] == ':' {
+			return false
+		}
+	}
+	return true
+}
+
+// Group tags the message with a group used for analytics
+func (b *EmailBuilder) Group(groups ...string) *EmailBuilder {
+	for _, group := range groups {
+		if strings.TrimSpace(group) == "" {
+			b.problems = append(b.problems, "group names must not be empty")
+			continue
+		}
+		b.message.Groups = append(b.message.Groups, group)
+	}
+	return b
+}
+
+// IPPool sends the message through a named IP pool; an empty name keeps the default
+func (b *EmailBuilder) IPPool(name string) *EmailBuilder {
+	if name != "" {
+		b.message.SetIppool(name)
+	}
+	return b
+}
+
+// Track enables or disables open and click tracking
+func (b *EmailBuilder) Track(opens, clicks bool) *EmailBuilder {
+	b.message.SetTrackOpens(opens)
+	b.message.SetTrackClicks(clicks)
+	return b
+}
+
+// Attach adds the files of an attachment set to the message
+func (b *EmailBuilder) Attach(attachments *Attachments) *EmailBuilder {
+	b.attachments = attachments
+	return b
+}
+
+// Warnings returns non-fatal findings from the last Build, such as an oversized HTML body
+func (b *EmailBuilder) Warnings() []string {
+	return b.warnings
+}
+
+// Build validates the message and returns the SDK object ready for EmailAPI.SendEmail
+func (b *EmailBuilder) Build() (*sendpost.EmailMessageObject, error) {
+	problems := append([]string{}, b.problems...)
+	if !b.message.HasFrom() {
+		problems = append(problems, "from address is required")
+	}
+	if len(b.recipients) == 0 {
+		problems = append(problems, "at least one To recipient is required")
+	}
+	if strings.TrimSpace(b.message.GetSubject()) == "" {
+		problems = append(problems, "subject is required")
+	}
+	if strings.TrimSpace(b.message.GetHtmlBody()) == "" && strings.TrimSpace(b.message.GetTextBody()) == "" {
+		problems = append(problems, "an HTML or text body is required")
+	}
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+
+	message := *b.message
+	message.SetTo(append([]sendpost.Recipient{}, b.recipients...))
+	if len(b.headers) > 0 {
+		headers := make(map[string]string, len(b.headers))
+		for name, value := range b.headers {
+			headers[name] = value
+		}
+		message.SetHeaders(headers)
+	}
+	message.Groups = append([]string{}, b.message.Groups...)
+
+	b.warnings = finalizeContent(&message)
+	b.attachments.Apply(&message)
+	return &message, nil
+}
+
+// buildEmail builds a message and prints validation problems, warnings, the IP pool and attachments
+func (e *ESPExample) buildEmail(builder *EmailBuilder) (*sendpost.EmailMessageObject, bool) {
+	emailMessage, err := builder.Build()
+	if err != nil {
+		fmt.Printf("✗ Failed to build email:\n")
+		fmt.Printf("  Error: %v\n", err)
+		return nil, false
+	}
+
+	for _, warning := range builder.Warnings() {
+		fmt.Printf("⚠️  %s\n", warning)
+	}
+	if emailMessage.HasIppool() {
+		fmt.Printf("  Using IP Pool: %s\n", emailMessage.GetIppool())
+	}
+	e.attachments.Print()
+	return emailMessage, true
+}
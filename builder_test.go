@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	sendpost "github.com/sendpost/sendpost-go-sdk"
+)
+
+// validBuilder returns a builder with every required field set
+func validBuilder() *EmailBuilder {
+	return NewEmailBuilder().
+		From("sender@example.com", "Sender").
+		To("recipient@example.com", "Recipient").
+		Subject("Hello").
+		HTML("<p>Hello</p>")
+}
+
+// recipientWith builds a prepared recipient with copy addresses
+func recipientWith(email string, cc, bcc []string) sendpost.Recipient {
+	recipient := sendpost.NewRecipient()
+	recipient.SetEmail(email)
+	for _, address := range cc {
+		copyTo := sendpost.NewCopyTo()
+		copyTo.SetEmail(address)
+		recipient.Cc = append(recipient.Cc, *copyTo)
+	}
+	for _, address := range bcc {
+		copyTo := sendpost.NewCopyTo()
+		copyTo.SetEmail(address)
+		recipient.Bcc = append(recipient.Bcc, *copyTo)
+	}
+	return *recipient
+}
+
+func TestBuildValidationErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		builder *EmailBuilder
+		want    []string
+	}{
+		{"empty", NewEmailBuilder(), []string{"from address is required", "at least one To recipient is required", "subject is required", "an HTML or text body is required"}},
+		{"invalid from", validBuilder().From("not an address", ""), []string{`from address "not an address"`}},
+		{"display name in address", validBuilder().To("Bob <bob@example.com>", ""), []string{`to address "Bob <bob@example.com>"`}},
+		{"invalid reply-to", validBuilder().ReplyTo("nobody", ""), []string{`reply-to address "nobody"`}},
+		{"subject line break", validBuilder().Subject("Hello\r\nBcc: x@example.com"), []string{"subject must not contain line breaks"}},
+		{"blank body", NewEmailBuilder().From("a@example.com", "").To("b@example.com", "").Subject("Hi").HTML("  "), []string{"an HTML or text body is required"}},
+		{"cc before to", NewEmailBuilder().Cc("c@example.com", ""), []string{"Cc must follow a To recipient"}},
+		{"bcc before to", NewEmailBuilder().Bcc("c@example.com", ""), []string{"Bcc must follow a To recipient"}},
+		{"field before to", NewEmailBuilder().Field("name", "x"), []string{"Field must follow a To recipient"}},
+		{"invalid cc", validBuilder().Cc("bad", ""), []string{`cc address "bad"`}},
+		{"empty group", validBuilder().Group(" "), []string{"group names must not be empty"}},
+		{"empty idempotency key", validBuilder().IdempotencyKey(""), []string{"idempotency key must be"}},
+		{"long idempotency key", validBuilder().IdempotencyKey(strings.Repeat("k", maxIdempotencyKeyLength+1)), []string{"idempotency key must be"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message, err := tt.builder.Build()
+			var validation *ValidationError
+			if !errors.As(err, &validation) {
+				t.Fatalf("Build() = %v, %v; want a ValidationError", message, err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not mention %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHeaderRules(t *testing.T) {
+	tests := []struct {
+		name, header, value string
+		want                string
+	}{
+		{"custom header", "X-Order-ID", "12345", ""},
+		{"reserved with method", "Subject", "x", "use Subject instead"},
+		{"reserved case-insensitive", "reply-TO", "x", "use ReplyTo instead"},
+		{"reserved without method", "Message-ID", "<x@example.com>", "cannot be overridden"},
+		{"content type", "Content-Type", "text/plain", "use HTML/Text instead"},
+		{"colon in name", "X-Bad:Name", "x", "must be non-empty printable ASCII"},
+		{"space in name", "X Bad", "x", "must be non-empty printable ASCII"},
+		{"empty name", "", "x", "must be non-empty printable ASCII"},
+		{"non-ASCII name", "X-Größe", "x", "must be non-empty printable ASCII"},
+		{"line break in value", "X-Note", "a\nb", "must not contain line breaks"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message, err := validBuilder().Header(tt.header, tt.value).Build()
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("Build() error = %v", err)
+				}
+				if got := message.GetHeaders()[tt.header]; got != tt.value {
+					t.Errorf("header %s = %q, want %q", tt.header, got, tt.value)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Build() error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecipientCopyAddresses(t *testing.T) {
+	tests := []struct {
+		name      string
+		recipient sendpost.Recipient
+		want      string
+	}{
+		{"valid copies", recipientWith("to@example.com", []string{"cc@example.com"}, []string{"bcc@example.com"}), ""},
+		{"invalid to", recipientWith("to", nil, nil), `to address "to"`},
+		{"invalid cc", recipientWith("to@example.com", []string{"cc@example.com", "cc"}, nil), `cc address "cc"`},
+		{"invalid bcc", recipientWith("to@example.com", nil, []string{"Boss <boss@example.com>"}), `bcc address "Boss <boss@example.com>"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message, err := NewEmailBuilder().
+				From("sender@example.com", "").
+				Recipient(tt.recipient).
+				Subject("Hello").
+				Text("Hello").
+				Build()
+			if tt.want != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.want) {
+					t.Fatalf("Build() error = %v, want it to contain %q", err, tt.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Build() error = %v", err)
+			}
+			to := message.GetTo()
+			if len(to) != 1 || len(to[0].Cc) != 1 || len(to[0].Bcc) != 1 {
+				t.Errorf("recipients = %+v, want one with one cc and one bcc", to)
+			}
+		})
+	}
+}
+
+func TestCcAndBccFollowLastRecipient(t *testing.T) {
+	message, err := validBuilder().
+		Cc("cc1@example.com", "").
+		To("second@example.com", "").
+		Bcc("bcc2@example.com", "Auditor").
+		Field("plan", "pro").
+		Build()
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	to := message.GetTo()
+	if len(to) != 2 {
+		t.Fatalf("got %d recipients, want 2", len(to))
+	}
+	if len(to[0].Cc) != 1 || to[0].Cc[0].GetEmail() != "cc1@example.com" || len(to[0].Bcc) != 0 {
+		t.Errorf("first recipient copies = cc %+v bcc %+v", to[0].Cc, to[0].Bcc)
+	}
+	if len(to[1].Bcc) != 1 || to[1].Bcc[0].GetName() != "Auditor" || len(to[1].Cc) != 0 {
+		t.Errorf("second recipient copies = cc %+v bcc %+v", to[1].Cc, to[1].Bcc)
+	}
+	if to[1].CustomFields["plan"] != "pro" || to[0].CustomFields != nil {
+		t.Errorf("custom fields = %v, %v", to[0].CustomFields, to[1].CustomFields)
+	}
+}
+
+func TestBuildFinalizesContent(t *testing.T) {
+	builder := NewEmailBuilder().
+		From("sender@example.com", "").
+		To("recipient@example.com", "").
+		Subject("Hello").
+		HTML(`<style>p { color: red; }</style><p>Hi <a href="https://example.com">there</a></p>`).
+		Group("marketing", "promotional").
+		IPPool("").
+		Track(true, false)
+	message, err := builder.Build()
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if !strings.Contains(message.GetHtmlBody(), `<p style="color: red">`) {
+		t.Errorf("CSS not inlined: %s", message.GetHtmlBody())
+	}
+	if got := message.GetTextBody(); got != "Hi there (https://example.com)" {
+		t.Errorf("text body = %q", got)
+	}
+	if message.HasIppool() {
+		t.Errorf("empty IP pool name set the pool to %q", message.GetIppool())
+	}
+	if !message.GetTrackOpens() || message.GetTrackClicks() {
+		t.Errorf("tracking = opens %v clicks %v", message.GetTrackOpens(), message.GetTrackClicks())
+	}
+	if len(message.Groups) != 2 {
+		t.Errorf("groups = %v", message.Groups)
+	}
+
+	// Building again must not share recipients or headers with the first message
+	builder.To("other@example.com", "").Header("X-Late", "1")
+	if len(message.GetTo()) != 1 || message.GetHeaders()["X-Late"] != "" {
+		t.Errorf("first message changed after building again: %+v", message)
+	}
+}
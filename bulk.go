@@ -0,0 +1,490 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	sendpost "github.com/sendpost/sendpost-go-sdk"
+)
+
+// BulkOptions configures a bulk send
+type BulkOptions struct {
+	RecipientsFile  string
+	ResultsFile     string
+	Template        string
+	TemplateVersion string
+	// Subject replaces the template's subject line when set
+	Subject   string
+	FromEmail string
+	FromName  string
+	IPPool    string
+	Groups    []string
+	Headers   map[string]string
+	Pool      PoolOptions
+	QueueDir  string
+	// SendAt schedules the send (see resolveSendAt); a wall-clock time is interpreted in
+	// the time zone named by the recipient's TimezoneField custom field when set
+	SendAt        string
+	TimezoneField string
+	// DryRun plans the send and prints its pacing schedule without queueing or sending
+	DryRun bool
+	// EMLDir receives an .eml preview per recipient on a dry run when set
+	EMLDir string
+}
+
+// SendResult maps one recipient to the message ID SendPost returned for it, or to an error
+type SendResult struct {
+	Email       string `json:"email"`
+	MessageID   string `json:"messageId,omitempty"`
+	Error       string `json:"error,omitempty"`
+	ScheduledAt string `json:"scheduledAt,omitempty"`
+	// Skipped is why the recipient was deliberately not sent to, such as an opt-out
+	Skipped string `json:"skipped,omitempty"`
+	// Unknown is set when the request got no HTTP response, such as after a timeout or a
+	// reset connection; SendPost may still have accepted it
+	Unknown bool `json:"unknown,omitempty"`
+}
+
+// bulkBatch is one SendEmail request
+// Bulk sends use one request per recipient, because the shared footer makes every
+// rendered message personal.
+type bulkBatch struct {
+	id         string
+	recipients []sendpost.Recipient
+	message    *sendpost.EmailMessageObject
+	sendAt     time.Time
+}
+
+// LoadRecipients reads recipients from a CSV or JSONL file, chosen by file extension
+//
+// CSV files need a header row with an "email" column; an optional "name" column sets the
+// recipient name and every other column becomes a custom field. JSONL files hold one SDK
+// recipient object per line: {"email": "...", "name": "...", "customFields": {...}}.
+func LoadRecipients(path string) ([]sendpost.Recipient, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return readCSVRecipients(file)
+	case ".jsonl", ".ndjson":
+		return readJSONLRecipients(file)
+	}
+	return nil, fmt.Errorf("unsupported recipients file %s: use .csv or .jsonl", path)
+}
+
+// readCSVRecipients parses recipients from CSV with a header row
+func readCSVRecipients(r io.Reader) ([]sendpost.Recipient, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("could not read CSV header: %w", err)
+	}
+	emailColumn := -1
+	for i, column := range header {
+		header[i] = strings.TrimSpace(column)
+		if strings.EqualFold(header[i], "email") {
+			emailColumn = i
+		}
+	}
+	if emailColumn < 0 {
+		return nil, fmt.Errorf("CSV header has no email column")
+	}
+
+	var recipients []sendpost.Recipient
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("CSV line %d: %w", line, err)
+		}
+
+		recipient := sendpost.NewRecipient()
+		fields := map[string]interface{}{}
+		for i, value := range row {
+			switch {
+			case i == emailColumn:
+				recipient.SetEmail(strings.TrimSpace(value))
+			case strings.EqualFold(header[i], "name"):
+				recipient.SetName(value)
+			default:
+				fields[header[i]] = value
+			}
+		}
+		if len(fields) > 0 {
+			recipient.SetCustomFields(fields)
+		}
+		recipients = append(recipients, *recipient)
+	}
+	return recipients, nil
+}
+
+// readJSONLRecipients parses one recipient object per line, skipping blank lines
+func readJSONLRecipients(r io.Reader) ([]sendpost.Recipient, error) {
+	var recipients []sendpost.Recipient
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var recipient sendpost.Recipient
+		if err := json.Unmarshal([]byte(text), &recipient); err != nil {
+			return nil, fmt.Errorf("JSONL line %d: %w", line, err)
+		}
+		recipients = append(recipients, recipient)
+	}
+	return recipients, scanner.Err()
+}
+
+// WriteResults writes send results as CSV or JSONL, chosen by file extension
+func WriteResults(path string, results []SendResult) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".jsonl" || ext == ".ndjson" {
+		encoder := json.NewEncoder(file)
+		for _, result := range results {
+			if err := encoder.Encode(result); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	writer := csv.NewWriter(file)
+	if err := writer.Write([]string{"email", "message_id", "error", "scheduled_at", "skipped"}); err != nil {
+		return err
+	}
+	for _, result := range results {
+		if err := writer.Write([]string{result.Email, result.MessageID, result.Error, result.ScheduledAt, result.Skipped}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// planBulk renders the template for every recipient and builds one batch per recipient;
+// recipients that cannot be rendered or built are returned as failed results
+func (e *ESPExample) planBulk(opts BulkOptions, recipients []sendpost.Recipient) ([]bulkBatch, []SendResult, error) {
+	registry, err := e.templateRegistry()
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl, err := registry.Get(opts.Template, opts.TemplateVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Recipients who opted out of one of the send's categories are left out
+	recipients, failed, err := e.preferences.Filter(recipients, opts.Groups)
+	if err != nil {
+		return nil, nil, err
+	}
+	categories, err := e.preferences.CategoriesFor(opts.Groups)
+	if err != nil {
+		return nil, nil, err
+	}
+	list := strings.Join(categories, ",")
+	if e.unsubscribe == nil {
+		fmt.Println("⚠️  SENDPOST_UNSUBSCRIBE_URL is not set: sending without List-Unsubscribe headers")
+	}
+
+	var batches []bulkBatch
+	now := time.Now()
+
+	// Pacing classifies every recipient's mailbox provider up front
+	pacer := opts.Pool.Pacer
+	if pacer != nil {
+		emails := make([]string, 0, len(recipients))
+		for _, recipient := range recipients {
+			emails = append(emails, recipient.GetEmail())
+		}
+		pacer.classifier.Prepare(emails)
+	}
+
+	for _, recipient := range recipients {
+		var sendAt time.Time
+		if opts.SendAt != "" {
+			loc, err := recipientLocation(recipient, opts.TimezoneField)
+			if err == nil {
+				sendAt, err = resolveSendAt(opts.SendAt, loc, now)
+			}
+			if err != nil {
+				failed = append(failed, SendResult{Email: recipient.GetEmail(), Error: err.Error()})
+				continue
+			}
+		}
+
+		// Each recipient gets their own signed preference link, without touching the list
+		personal := recipient
+		if e.unsubscribe != nil {
+			fields := map[string]interface{}{}
+			for key, value := range recipient.GetCustomFields() {
+				fields[key] = value
+			}
+			fields["preferences_url"] = e.unsubscribe.URL(recipient.GetEmail(), list)
+			personal.SetCustomFields(fields)
+		}
+
+		rendered, err := tmpl.Render(personal)
+		if err != nil {
+			failed = append(failed, SendResult{Email: recipient.GetEmail(), Error: err.Error()})
+			continue
+		}
+		if opts.Subject != "" {
+			rendered.Subject = opts.Subject
+		}
+
+		builder := NewEmailBuilder().
+			From(opts.FromEmail, opts.FromName).
+			Recipient(personal).
+			Content(rendered).
+			Track(true, true).
+			Group(opts.Groups...).
+			IPPool(opts.IPPool)
+		for name, value := range opts.Headers {
+			builder.Header(name, value)
+		}
+		// Every bulk request has a single recipient, so it can carry their unsubscribe headers
+		if e.unsubscribe != nil {
+			e.unsubscribe.Tag(builder, recipient.GetEmail(), list)
+		}
+		message, err := builder.Build()
+		if err != nil {
+			failed = append(failed, SendResult{Email: recipient.GetEmail(), Error: err.Error()})
+			continue
+		}
+
+		batches = append(batches, bulkBatch{recipients: []sendpost.Recipient{recipient}, message: message, sendAt: sendAt})
+	}
+	return batches, failed, nil
+}
+
+// sendBatch sends one batch and maps every recipient to its message ID or error
+func (e *ESPExample) sendBatch(ctx context.Context, batch bulkBatch) []SendResult {
+	// Opt-outs recorded after the request was planned are honoured at send time
+	recipients, skipped, err := e.preferences.Filter(batch.recipients, batch.message.GetGroups())
+	if err != nil {
+		results := make([]SendResult, 0, len(batch.recipients))
+		for _, recipient := range batch.recipients {
+			results = append(results, SendResult{Email: recipient.GetEmail(), Error: "not sent: " + err.Error()})
+		}
+		return results
+	}
+	if len(recipients) == 0 {
+		return skipped
+	}
+	if len(skipped) > 0 {
+		message := *batch.message
+		message.SetTo(recipients)
+		batch.message, batch.recipients = &message, recipients
+	}
+
+	responses, resp, err := e.deliver(ctx, batch.message)
+
+	results := append(make([]SendResult, 0, len(batch.recipients)+len(skipped)), skipped...)
+	if err != nil {
+		// Only a response from SendPost makes the rejection definite
+		result := SendResult{Error: fmt.Sprintf("unknown: no response from SendPost (%v); verify delivery before requeueing", err), Unknown: true}
+		if resp != nil {
+			result = SendResult{Error: fmt.Sprintf("status %d: %v", resp.StatusCode, err)}
+		}
+		for _, recipient := range batch.recipients {
+			result.Email = recipient.GetEmail()
+			results = append(results, result)
+		}
+		return results
+	}
+
+	byEmail := make(map[string]sendpost.EmailResponse, len(responses))
+	for _, response := range responses {
+		byEmail[strings.ToLower(response.GetTo())] = response
+	}
+	for _, recipient := range batch.recipients {
+		result := SendResult{Email: recipient.GetEmail()}
+		response, ok := byEmail[strings.ToLower(recipient.GetEmail())]
+		switch {
+		case !ok:
+			result.Error = "no response returned for recipient"
+		case response.GetErrorCode() != 0 || response.GetMessageId() == "":
+			result.Error = fmt.Sprintf("error %d: %s", response.GetErrorCode(), response.GetMessage())
+		default:
+			result.MessageID = response.GetMessageId()
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// SendBulk sends a template to every recipient of a CSV or JSONL file and writes a results file
+func (e *ESPExample) SendBulk(opts BulkOptions) ([]SendResult, error) {
+	fmt.Println("\n=== Bulk Send ===")
+
+	recipients, err := LoadRecipients(opts.RecipientsFile)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Loaded %d recipient(s) from %s\n", len(recipients), opts.RecipientsFile)
+
+	if !e.validateSender(opts.FromEmail, "--from") {
+		return nil, fmt.Errorf("sender %s cannot be used", opts.FromEmail)
+	}
+
+	batches, results, err := e.planBulk(opts, recipients)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Prepared %d request(s), one per recipient\n", len(batches))
+
+	if opts.DryRun {
+		if opts.Pool.Pacer != nil {
+			opts.Pool.Pacer.PrintSchedule(batches, opts.Pool.Pacer.Schedule(batches))
+		} else {
+			fmt.Println("  Pacing is off: every request is sent as soon as a worker is free")
+		}
+		if opts.EMLDir != "" {
+			written := 0
+			for _, batch := range batches {
+				paths, err := WriteEMLPreviews(opts.EMLDir, batch.message)
+				written += len(paths)
+				if err != nil {
+					return results, err
+				}
+			}
+			fmt.Printf("  %d .eml preview(s) written to %s\n", written, opts.EMLDir)
+		}
+		var unprepared []SendResult
+		for _, result := range results {
+			if result.Skipped != "" {
+				fmt.Printf("  Skipping %s: %s\n", result.Email, result.Skipped)
+			} else {
+				unprepared = append(unprepared, result)
+			}
+		}
+		if len(unprepared) > 0 {
+			fmt.Printf("  %d recipient(s) could not be prepared:\n", len(unprepared))
+			for _, result := range unprepared {
+				fmt.Printf("    %s: %s\n", result.Email, result.Error)
+			}
+		}
+		fmt.Println("✓ Dry run finished: nothing was queued or sent")
+		return results, nil
+	}
+
+	// Every request goes through the durable queue, so re-running the same bulk send after
+	// a crash resumes it without sending committed requests again
+	queue, err := e.openQueue(opts.QueueDir)
+	if err != nil {
+		return nil, err
+	}
+	defer queue.Close()
+
+	optedOut := 0
+	for _, result := range results {
+		if result.Skipped != "" {
+			optedOut++
+		}
+	}
+
+	run := map[string]bool{}
+	previouslySent, scheduled := 0, 0
+	for _, batch := range batches {
+		id, added, err := queue.EnqueueAt(batch.message, batch.sendAt)
+		if err != nil {
+			return nil, err
+		}
+		run[id] = true
+
+		entry, _ := queue.Entry(id)
+		if !entry.SendAt.IsZero() && (entry.Status == statusPending || entry.Status == statusCancelled) {
+			// Scheduled requests are left to the scheduler
+			for _, recipient := range batch.recipients {
+				result := SendResult{Email: recipient.GetEmail(), ScheduledAt: formatRFC3339(entry.SendAt)}
+				if entry.Status == statusCancelled {
+					result.Error = "cancelled"
+				}
+				results = append(results, result)
+			}
+			if entry.Status == statusPending {
+				scheduled += len(batch.recipients)
+			}
+			continue
+		}
+		if added {
+			continue
+		}
+
+		switch entry.Status {
+		case statusSent, statusFailed:
+			fmt.Printf("  Request %s was already sent in an earlier run; using its recorded results\n", id)
+			results = append(results, entry.Results...)
+			for _, result := range entry.Results {
+				switch {
+				case result.Skipped != "":
+					optedOut++
+				case result.Error == "":
+					previouslySent++
+				}
+			}
+		case statusUnknown:
+			if len(entry.Results) > 0 {
+				fmt.Printf("  ⚠️  Request %s got no response from SendPost in an earlier run; not resending it\n", id)
+				results = append(results, entry.Results...)
+				continue
+			}
+			fmt.Printf("  ⚠️  Request %s was in flight when an earlier run stopped; not resending it\n", id)
+			for _, recipient := range batch.recipients {
+				results = append(results, SendResult{Email: recipient.GetEmail(), Error: "unknown: in flight when an earlier run stopped; verify delivery, then use \"queue requeue\" to resend", Unknown: true})
+			}
+		}
+	}
+
+	runResults, stats := e.RunQueue(queue, opts.Pool, func(entry QueueEntry) bool {
+		return run[entry.ID] && immediate(entry)
+	})
+	results = append(results, runResults...)
+
+	if opts.ResultsFile != "" {
+		if err := WriteResults(opts.ResultsFile, results); err != nil {
+			return results, fmt.Errorf("could not write results: %w", err)
+		}
+		fmt.Printf("  Results written to %s\n", opts.ResultsFile)
+	}
+
+	// Recipients that failed or opted out before sending, or were settled by an earlier
+	// run, are not counted by the pool
+	stats.Sent += previouslySent
+	stats.Failed += len(results) - stats.Recipients - previouslySent - scheduled - optedOut
+	stats.OptedOut += optedOut
+	stats.Print()
+	if scheduled > 0 {
+		fmt.Printf("  %d recipient(s) scheduled; the scheduler sends them when due: go run . schedule run\n", scheduled)
+	}
+	if stats.Interrupted {
+		fmt.Printf("⚠️  Bulk send interrupted: %d recipient(s) were not sent\n", stats.Skipped)
+	} else {
+		fmt.Printf("✓ Bulk send finished: %d sent, %d failed\n", stats.Sent, stats.Failed)
+	}
+	return results, nil
+}
@@ -0,0 +1,546 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	sendpost "github.com/sendpost/sendpost-go-sdk"
+)
+
+// Campaign settings
+const (
+	defaultCampaignsDir = ".sendpost-campaigns"
+	campaignIDHeader    = "X-Campaign-ID"
+	campaignNameHeader  = "X-Campaign-Name"
+)
+
+// Campaign lifecycle states stored with the campaign; a launched campaign is further
+// described by the state of its queued requests (see CampaignProgress)
+const (
+	campaignDraft    = "draft"
+	campaignTesting  = "testing"
+	campaignLaunched = "launched"
+	campaignPaused   = "paused"
+)
+
+// campaignIDPattern restricts campaign IDs to values that are safe as file names, header
+// values and group names
+var campaignIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// Campaign is a marketing send: a template sent to an audience file at a planned time
+//
+// Every message of a campaign carries the X-Campaign-ID and X-Campaign-Name headers and
+// is tagged with the campaign ID as a group, so stats and webhook events can be rolled up
+// per campaign.
+type Campaign struct {
+	ID              string    `json:"id"`
+	Name            string    `json:"name"`
+	Template        string    `json:"template"`
+	TemplateVersion string    `json:"templateVersion,omitempty"`
+	Audience        string    `json:"audience"`
+	FromEmail       string    `json:"fromEmail"`
+	FromName        string    `json:"fromName"`
+	Groups          []string  `json:"groups,omitempty"`
+	IPPool          string    `json:"ipPool,omitempty"`
+	SendAt          string    `json:"sendAt,omitempty"`
+	TimezoneField   string    `json:"timezoneField,omitempty"`
+	QueueDir        string    `json:"queueDir,omitempty"`
+	Status          string    `json:"status"`
+	CreatedAt       time.Time `json:"createdAt"`
+	LaunchedAt      time.Time `json:"launchedAt,omitempty"`
+	// Variants and ABTest turn the campaign into an A/B test (see abtest.go)
+	Variants []CampaignVariant `json:"variants,omitempty"`
+	ABTest   *ABTest           `json:"abTest,omitempty"`
+}
+
+// defaultCampaign is used by SendMarketingEmail when no stored campaign is selected
+func defaultCampaign() *Campaign {
+	return &Campaign{
+		ID:        "campaign-001",
+		Name:      "Special Offer",
+		Template:  "special-offer",
+		FromEmail: testFromEmail,
+		FromName:  "Marketing Team",
+		Groups:    []string{"marketing", "promotional"},
+		Status:    campaignDraft,
+	}
+}
+
+// campaignID derives an ID from a campaign name
+func campaignID(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z' || r >= '0' && r <= '9':
+			b.WriteRune(r)
+			dash = false
+		case b.Len() > 0 && !dash:
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	id := strings.TrimSuffix(b.String(), "-")
+	if len(id) > 64 {
+		id = strings.TrimSuffix(id[:64], "-")
+	}
+	return id
+}
+
+// Validate checks the campaign before it is saved
+func (c *Campaign) Validate() error {
+	var problems []string
+	if !campaignIDPattern.MatchString(c.ID) {
+		problems = append(problems, fmt.Sprintf("campaign ID %q must be lowercase letters, digits, '-' or '_'", c.ID))
+	}
+	if strings.TrimSpace(c.Name) == "" {
+		problems = append(problems, "campaign name is required")
+	}
+	if c.Template == "" && len(c.Variants) == 0 {
+		problems = append(problems, "template is required")
+	}
+	problems = append(problems, c.validateABTest()...)
+	if c.Audience == "" {
+		problems = append(problems, "audience file is required")
+	}
+	if c.SendAt != "" {
+		if _, err := resolveSendAt(c.SendAt, time.Local, time.Now()); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// Tag adds the campaign headers and groups to a message, and its IP pool when set
+func (c *Campaign) Tag(builder *EmailBuilder) *EmailBuilder {
+	return builder.
+		Header(campaignIDHeader, c.ID).
+		Header(campaignNameHeader, c.Name).
+		Group(c.groups()...).
+		IPPool(c.IPPool)
+}
+
+// groups returns the campaign's groups followed by its ID
+func (c *Campaign) groups() []string {
+	groups := append([]string{}, c.Groups...)
+	for _, group := range groups {
+		if group == c.ID {
+			return groups
+		}
+	}
+	return append(groups, c.ID)
+}
+
+// bulkOptions turns the campaign into options for planning its requests
+func (c *Campaign) bulkOptions() BulkOptions {
+	return BulkOptions{
+		RecipientsFile:  c.Audience,
+		Template:        c.Template,
+		TemplateVersion: c.TemplateVersion,
+		FromEmail:       c.FromEmail,
+		FromName:        c.FromName,
+		IPPool:          c.IPPool,
+		Groups:          c.groups(),
+		Headers:         map[string]string{campaignIDHeader: c.ID, campaignNameHeader: c.Name},
+		QueueDir:        c.QueueDir,
+		SendAt:          c.SendAt,
+		TimezoneField:   c.TimezoneField,
+	}
+}
+
+// CampaignStore keeps campaigns as one JSON file each
+type CampaignStore struct {
+	dir string
+}
+
+// NewCampaignStore creates a store in dir
+func NewCampaignStore(dir string) *CampaignStore {
+	return &CampaignStore{dir: dir}
+}
+
+// path returns the file of a campaign
+func (s *CampaignStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// Create saves a new campaign, refusing to overwrite an existing one
+func (s *CampaignStore) Create(c *Campaign) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	if _, err := os.Stat(s.path(c.ID)); err == nil {
+		return fmt.Errorf("campaign %s already exists", c.ID)
+	}
+	return s.Save(c)
+}
+
+// Save writes a campaign, replacing the file atomically
+func (s *CampaignStore) Save(c *Campaign) error {
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return fmt.Errorf("could not create campaigns directory: %w", err)
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path(c.ID) + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("could not save campaign: %w", err)
+	}
+	if err := os.Rename(tmp, s.path(c.ID)); err != nil {
+		return fmt.Errorf("could not save campaign: %w", err)
+	}
+	return nil
+}
+
+// Get loads a campaign by ID
+func (s *CampaignStore) Get(id string) (*Campaign, error) {
+	if !campaignIDPattern.MatchString(id) {
+		return nil, fmt.Errorf("campaign %s not found", id)
+	}
+	data, err := os.ReadFile(s.path(id))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("campaign %s not found", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c Campaign
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("campaign %s is unreadable: %w", id, err)
+	}
+	return &c, nil
+}
+
+// List returns all campaigns sorted by creation time
+func (s *CampaignStore) List() ([]*Campaign, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	campaigns := make([]*Campaign, 0, len(paths))
+	for _, path := range paths {
+		c, err := s.Get(strings.TrimSuffix(filepath.Base(path), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		campaigns = append(campaigns, c)
+	}
+	sort.Slice(campaigns, func(i, j int) bool { return campaigns[i].CreatedAt.Before(campaigns[j].CreatedAt) })
+	return campaigns, nil
+}
+
+// CampaignProgress counts a campaign's queued requests and recipients per queue status
+type CampaignProgress struct {
+	Requests   map[queueStatus]int
+	Recipients map[queueStatus]int
+	Sent       int
+	Failed     int
+	OptedOut   int
+	NextSendAt time.Time
+}
+
+// campaignEntries returns the queue entries tagged with a campaign ID
+func campaignEntries(queue *SendQueue, id string, statuses ...queueStatus) []QueueEntry {
+	var entries []QueueEntry
+	for _, entry := range queue.Entries(statuses...) {
+		if entry.Message.GetHeaders()[campaignIDHeader] == id {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Progress summarizes the campaign's requests in the queue
+func (c *Campaign) Progress(queue *SendQueue) CampaignProgress {
+	progress := CampaignProgress{Requests: map[queueStatus]int{}, Recipients: map[queueStatus]int{}}
+	for _, entry := range campaignEntries(queue, c.ID) {
+		progress.Requests[entry.Status]++
+		progress.Recipients[entry.Status] += len(entry.Message.To)
+		for _, result := range entry.Results {
+			switch {
+			case result.Skipped != "":
+				progress.OptedOut++
+			case result.Error == "":
+				progress.Sent++
+			default:
+				progress.Failed++
+			}
+		}
+		if entry.Status == statusPending && (progress.NextSendAt.IsZero() || entry.SendAt.Before(progress.NextSendAt)) {
+			progress.NextSendAt = entry.SendAt
+		}
+	}
+	return progress
+}
+
+// State describes where a campaign is in its lifecycle
+func (c *Campaign) State(progress CampaignProgress) string {
+	if c.Status == campaignTesting && progress.Requests[statusPending] == 0 && progress.Requests[statusInFlight] == 0 {
+		return "testing, waiting for a winner"
+	}
+	if c.Status != campaignLaunched {
+		return c.Status
+	}
+	switch {
+	case progress.Requests[statusPending] > 0 && progress.NextSendAt.After(time.Now()) && progress.Requests[statusSent]+progress.Requests[statusFailed] == 0:
+		return "scheduled"
+	case progress.Requests[statusPending] > 0 || progress.Requests[statusInFlight] > 0:
+		return "sending"
+	case progress.Requests[statusUnknown] > 0:
+		return "needs attention"
+	}
+	return "completed"
+}
+
+// campaignStore returns the store for the configured campaigns directory
+func (e *ESPExample) campaignStore() *CampaignStore {
+	return NewCampaignStore(e.campaignsDir)
+}
+
+// LaunchCampaign renders the campaign for its audience and queues every request at the
+// campaign's send time (now when unscheduled); the scheduler sends them
+// Campaigns with variants start an A/B test instead (see launchABTest).
+func (e *ESPExample) LaunchCampaign(id string) error {
+	fmt.Println("\n=== Launch Campaign ===")
+
+	store := e.campaignStore()
+	c, err := store.Get(id)
+	if err != nil {
+		return err
+	}
+	if c.Status != campaignDraft {
+		return fmt.Errorf("campaign %s is %s; only draft campaigns can be launched", c.ID, c.Status)
+	}
+	if c.QueueDir == "" {
+		c.QueueDir = e.queueDir
+	}
+
+	recipients, err := LoadRecipients(c.Audience)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Campaign: %s (%s)\n", c.Name, c.ID)
+	fmt.Printf("  Audience: %d recipient(s) from %s\n", len(recipients), c.Audience)
+
+	if !e.validateSender(c.FromEmail, fmt.Sprintf("the sender of campaign %s (--from)", c.ID)) {
+		return fmt.Errorf("sender %s cannot be used", c.FromEmail)
+	}
+
+	queue, err := e.openQueue(c.QueueDir)
+	if err != nil {
+		return err
+	}
+	defer queue.Close()
+
+	if len(c.Variants) > 0 {
+		if err := e.launchABTest(c, recipients, queue); err != nil {
+			return err
+		}
+		c.Status = campaignTesting
+	} else {
+		requests, queued, err := e.enqueueCampaign(queue, c.bulkOptions(), recipients)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("  Requests: %d for %d recipient(s)\n", requests, queued)
+		c.Status = campaignLaunched
+	}
+
+	c.LaunchedAt = time.Now().UTC()
+	if err := store.Save(c); err != nil {
+		return err
+	}
+
+	progress := c.Progress(queue)
+	fmt.Println("✓ Campaign launched successfully!")
+	if !progress.NextSendAt.IsZero() {
+		fmt.Printf("  First Send At: %s\n", formatTime(progress.NextSendAt))
+	}
+	fmt.Println("  The scheduler sends it when due: go run . schedule run")
+	return nil
+}
+
+// enqueueCampaign plans requests for recipients and queues them at the options' send time
+// (now when unscheduled); it returns the number of requests and recipients queued
+func (e *ESPExample) enqueueCampaign(queue *SendQueue, opts BulkOptions, recipients []sendpost.Recipient) (int, int, error) {
+	if opts.SendAt == "" {
+		opts.SendAt = time.Now().Format(time.RFC3339)
+	}
+
+	batches, failed, err := e.planBulk(opts, recipients)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, result := range failed {
+		if result.Skipped != "" {
+			fmt.Printf("  Skipping %s: %s\n", result.Email, result.Skipped)
+			continue
+		}
+		fmt.Printf("  ⚠️  Skipping %s: %s\n", result.Email, result.Error)
+	}
+
+	queued := 0
+	for _, batch := range batches {
+		if _, _, err := queue.EnqueueAt(batch.message, batch.sendAt); err != nil {
+			return 0, 0, err
+		}
+		queued += len(batch.recipients)
+	}
+	return len(batches), queued, nil
+}
+
+// PauseCampaign cancels the campaign's requests that have not been sent yet
+// Requests already handed to a running scheduler still go out.
+func (e *ESPExample) PauseCampaign(id string) error {
+	store := e.campaignStore()
+	c, err := store.Get(id)
+	if err != nil {
+		return err
+	}
+	if c.Status != campaignLaunched && c.Status != campaignTesting {
+		return fmt.Errorf("campaign %s is %s; only launched or testing campaigns can be paused", c.ID, c.Status)
+	}
+
+	queue, err := e.openQueue(c.QueueDir)
+	if err != nil {
+		return err
+	}
+	defer queue.Close()
+
+	paused := 0
+	for _, entry := range campaignEntries(queue, c.ID, statusPending) {
+		if err := queue.Cancel(entry.ID); err != nil {
+			return err
+		}
+		paused += len(entry.Message.To)
+	}
+
+	c.Status = campaignPaused
+	if err := store.Save(c); err != nil {
+		return err
+	}
+	fmt.Printf("✓ Paused campaign %s: %d recipient(s) held back\n", c.ID, paused)
+	return nil
+}
+
+// ResumeCampaign reschedules paused requests at their original send time, or now when
+// that time has passed
+func (e *ESPExample) ResumeCampaign(id string) error {
+	store := e.campaignStore()
+	c, err := store.Get(id)
+	if err != nil {
+		return err
+	}
+	if c.Status != campaignPaused {
+		return fmt.Errorf("campaign %s is %s; only paused campaigns can be resumed", c.ID, c.Status)
+	}
+
+	queue, err := e.openQueue(c.QueueDir)
+	if err != nil {
+		return err
+	}
+	defer queue.Close()
+
+	now := time.Now()
+	resumed := 0
+	for _, entry := range campaignEntries(queue, c.ID, statusCancelled) {
+		sendAt := entry.SendAt
+		if sendAt.Before(now) {
+			sendAt = now
+		}
+		if err := queue.Reschedule(entry.ID, sendAt); err != nil {
+			return err
+		}
+		resumed += len(entry.Message.To)
+	}
+
+	c.Status = campaignLaunched
+	if c.ABTest != nil && c.ABTest.Winner == "" {
+		c.Status = campaignTesting
+	}
+	if err := store.Save(c); err != nil {
+		return err
+	}
+	fmt.Printf("✓ Resumed campaign %s: %d recipient(s) queued again\n", c.ID, resumed)
+	return nil
+}
+
+// PrintCampaignStatus prints a campaign's settings and delivery progress
+func (e *ESPExample) PrintCampaignStatus(id string) error {
+	c, err := e.campaignStore().Get(id)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Campaign: %s (%s)\n", c.Name, c.ID)
+	if c.TemplateVersion != "" {
+		fmt.Printf("  Template: %s (%s)\n", c.Template, c.TemplateVersion)
+	} else if c.Template != "" {
+		fmt.Printf("  Template: %s (latest)\n", c.Template)
+	}
+	fmt.Printf("  Audience: %s\n", c.Audience)
+	fmt.Printf("  From: %s <%s>\n", c.FromName, c.FromEmail)
+	fmt.Printf("  Groups: %s\n", strings.Join(c.groups(), ", "))
+	if c.IPPool != "" {
+		fmt.Printf("  IP Pool: %s\n", c.IPPool)
+	}
+	for _, variant := range c.Variants {
+		template := variant.Template
+		if template == "" {
+			template = c.Template
+		}
+		fmt.Printf("  Variant %s: %s", variant.Name, template)
+		if variant.TemplateVersion != "" {
+			fmt.Printf(" (%s)", variant.TemplateVersion)
+		}
+		if variant.Subject != "" {
+			fmt.Printf(", subject %q", variant.Subject)
+		}
+		fmt.Println()
+	}
+	if c.ABTest != nil {
+		fmt.Printf("  A/B Test: %d%% of the audience, winner by %s after %s\n", c.ABTest.TestPercent, c.ABTest.Metric, c.ABTest.Window)
+		if c.ABTest.Winner != "" {
+			fmt.Printf("  Winner: %s\n", c.ABTest.Winner)
+		}
+	}
+	if c.SendAt != "" {
+		fmt.Printf("  Send At: %s", c.SendAt)
+		if c.TimezoneField != "" {
+			fmt.Printf(" (recipient time zone from %s)", c.TimezoneField)
+		}
+		fmt.Println()
+	}
+
+	if c.Status == campaignDraft {
+		fmt.Println("  Status: draft")
+		return nil
+	}
+
+	queue, err := e.openQueue(c.QueueDir)
+	if err != nil {
+		return err
+	}
+	defer queue.Close()
+
+	progress := c.Progress(queue)
+	fmt.Printf("  Status: %s\n", c.State(progress))
+	fmt.Printf("  Launched At: %s\n", formatTime(c.LaunchedAt))
+	fmt.Printf("  Recipients: %d sent, %d failed, %d opted out, %d waiting, %d paused, %d unknown\n",
+		progress.Sent, progress.Failed, progress.OptedOut, progress.Recipients[statusPending]+progress.Recipients[statusInFlight],
+		progress.Recipients[statusCancelled], progress.Recipients[statusUnknown])
+	if c.Status == campaignLaunched && !progress.NextSendAt.IsZero() {
+		fmt.Printf("  Next Send At: %s\n", formatTime(progress.NextSendAt))
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTemplateFiles creates template files below dir from a map of relative paths
+func writeTemplateFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCampaignID(t *testing.T) {
+	tests := map[string]string{
+		"Spring Sale 2026":      "spring-sale-2026",
+		"  Black Friday!!  ":    "black-friday",
+		"Ünïcode & symbols --":  "n-code-symbols",
+		strings.Repeat("a", 70): strings.Repeat("a", 64),
+	}
+	for name, want := range tests {
+		if got := campaignID(name); got != want {
+			t.Errorf("campaignID(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestCampaignValidate(t *testing.T) {
+	valid := func() *Campaign {
+		return &Campaign{ID: "spring-sale", Name: "Spring Sale", Template: "special-offer", Audience: "customers.csv"}
+	}
+	tests := []struct {
+		name   string
+		change func(c *Campaign)
+		want   string
+	}{
+		{"valid", func(c *Campaign) {}, ""},
+		{"bad ID", func(c *Campaign) { c.ID = "Spring Sale" }, "campaign ID"},
+		{"no name", func(c *Campaign) { c.Name = " " }, "campaign name is required"},
+		{"no template", func(c *Campaign) { c.Template = "" }, "template is required"},
+		{"no audience", func(c *Campaign) { c.Audience = "" }, "audience file is required"},
+		{"bad send time", func(c *Campaign) { c.SendAt = "tomorrow" }, "invalid send time"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid()
+			tt.change(c)
+			err := c.Validate()
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendMarketingEmailUsesCampaign(t *testing.T) {
+	e := newTestExample(t, jsonHandler(200, `[{"id":1,"name":"example.com","verified":true}]`))
+	e.templatesDir = t.TempDir()
+	writeTemplateFiles(t, e.templatesDir, map[string]string{
+		"promo/v1/subject.txt": "Old offer for {{.Name}}",
+		"promo/v1/body.html":   "<p>Old</p>",
+		"promo/v2/subject.txt": "New offer",
+		"promo/v2/body.html":   "<p>New</p>",
+	})
+	e.campaign = &Campaign{
+		ID:              "spring-sale",
+		Name:            "Spring Sale",
+		Template:        "promo",
+		TemplateVersion: "v1",
+		FromEmail:       "news@example.com",
+		FromName:        "Newsroom",
+		SendAt:          "+2h",
+	}
+
+	start := time.Now()
+	if err := e.SendMarketingEmail(); err != nil {
+		t.Fatalf("SendMarketingEmail() error = %v", err)
+	}
+
+	queue := openTestQueue(t, e.queueDir)
+	entries := queue.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("got %d queued requests, want the campaign's scheduled send", len(entries))
+	}
+	entry := entries[0]
+	message := entry.Message
+	if got := message.GetSubject(); got != "Old offer for Customer 1" {
+		t.Errorf("subject = %q, want the campaign's template version", got)
+	}
+	if from := message.GetFrom(); from.GetEmail() != "news@example.com" || from.GetName() != "Newsroom" {
+		t.Errorf("from = %s <%s>, want the campaign's sender", from.GetName(), from.GetEmail())
+	}
+	if wantAt := start.Add(2 * time.Hour); entry.SendAt.Before(wantAt.Add(-time.Minute)) || entry.SendAt.After(wantAt.Add(time.Minute)) {
+		t.Errorf("send at = %s, want about %s", entry.SendAt, wantAt)
+	}
+	if got := message.GetHeaders()[campaignIDHeader]; got != "spring-sale" {
+		t.Errorf("%s = %q", campaignIDHeader, got)
+	}
+}
@@ -0,0 +1,1036 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	sendpost "github.com/sendpost/sendpost-go-sdk"
+)
+
+// command is a top-level subcommand of the example binary
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(args []string) error
+}
+
+// commands lists the subcommands available next to the default complete workflow
+var commands = []command{
+	{
+		name:    "send",
+		usage:   "send transactional|marketing [--attach file ...] [--inline-image [cid=]image ...] [--idempotency-key K] [--send-at T] [--queue-dir DIR] [--campaign ID] [--dry-run] [--render-eml DIR] | send eml file.eml [--to E ...] [--attach file ...] [--idempotency-key K] [--send-at T] [--dry-run] [--render-eml DIR] | send bulk --recipients file.csv|.jsonl --template NAME [--version V] [--results file] [--from E] [--from-name N] [--ip-pool P] [--group G ...] [--header k=v ...] [--concurrency N] [--queue-size N] [--queue-dir DIR] [--send-at T [--tz-field F]] [--pacing] [--pace provider=N[:B] ...] [--no-mx] [--dry-run] [--render-eml DIR]",
+		summary: "Send a single transactional or marketing email, a prebuilt .eml file, or a template to a recipient file",
+		run:     runSendCommand,
+	},
+	{
+		name:    "campaign",
+		usage:   "campaign create --name N --template T --audience file.csv|.jsonl [--id ID] [--version V] [--from E] [--from-name N] [--group G ...] [--ip-pool P] [--send-at T [--tz-field F]] [--variant NAME[=TEMPLATE[@V]] ... [--variant-subject NAME=S ...] [--test-percent P] [--test-window D] [--metric opens|clicks]] | campaign list | campaign launch|pause|resume|status --id ID | campaign results --id ID [--events file.jsonl] | campaign pick-winner --id ID [--events file.jsonl] [--winner NAME] [--force] [--queue-dir DIR]",
+		summary: "Create marketing campaigns and A/B tests, launch them through the scheduler, pause, resume and track them",
+		run:     runCampaignCommand,
+	},
+	{
+		name:    "messages",
+		usage:   "messages show --id ID [--events file.jsonl] [--raw] | messages search [--to E] [--from E] [--subject S] [--since T] [--until T] [--sub-account ID] [--ip-pool P] [--status S] [--events file.jsonl] [--fetch] [--limit N] [--page N] [--csv file]",
+		summary: "Show a message with its delivery timeline, or search sent messages, page through them and export them to CSV",
+		run:     runMessagesCommand,
+	},
+	{
+		name:    "stats",
+		usage:   "stats subaccount|aggregate --sub-account ID | stats account | stats export [--sub-account ID ...] [--csv file] [--parquet file] | stats report [--sub-account ID ...] [--from YYYY-MM-DD [--to YYYY-MM-DD] | --last 30d | --month YYYY-MM] [--thresholds bounce=W:C,complaint=W:C,delivery=W:C,volume=N]",
+		summary: "Show daily, aggregate or account stats, export daily stats per sub-account to CSV and Parquet, or report rates with a health grade",
+		run:     runStatsCommand,
+	},
+	{
+		name:    "idempotency",
+		usage:   "idempotency release --key K",
+		summary: "Release an idempotency key so the next send with it goes out again",
+		run:     runIdempotencyCommand,
+	},
+	{
+		name:    "queue",
+		usage:   "queue status | queue run [--concurrency N] [--queue-size N] [--results file] [--pacing] [--pace provider=N[:B] ...] [--no-mx] | queue requeue --id ID|--unknown|--failed [--queue-dir DIR]",
+		summary: "Inspect the durable send queue, send pending requests or requeue unknown/failed ones",
+		run:     runQueueCommand,
+	},
+	{
+		name:    "schedule",
+		usage:   "schedule list [--all] | schedule cancel --id ID | schedule reschedule --id ID --send-at T | schedule run [--interval D] [--once] [--concurrency N] [--queue-size N] [--pacing] [--pace provider=N[:B] ...] [--no-mx] [--queue-dir DIR]",
+		summary: "List, cancel or reschedule scheduled sends, or run the scheduler that sends them when due",
+		run:     runScheduleCommand,
+	},
+	{
+		name:    "sandbox",
+		usage:   "sandbox list | sandbox clear | sandbox serve [--addr HOST:PORT] [--dir Maildir|file.mbox]",
+		summary: "List, clear or browse the messages captured with SENDPOST_SANDBOX=capture",
+		run:     runSandboxCommand,
+	},
+	{
+		name:    "smtp",
+		usage:   "smtp serve [--addr HOST:PORT] [--hostname H] [--tls-cert file --tls-key file | --insecure] [--users file] [--max-size BYTES] [--max-recipients N]",
+		summary: "Run an SMTP relay that accepts mail from legacy apps and sends it through the SendPost API",
+		run:     runSMTPCommand,
+	},
+	{
+		name:    "preferences",
+		usage:   "preferences categories | preferences show --email E | preferences set --email E [--opt-out CATEGORY ...] [--opt-in CATEGORY ...]",
+		summary: "List subscription categories, or show and change the categories a recipient receives",
+		run:     runPreferencesCommand,
+	},
+	{
+		name:    "unsubscribe",
+		usage:   "unsubscribe serve [--addr HOST:PORT] | unsubscribe link --email E [--list L]",
+		summary: "Serve one-click unsubscribe links and the preference page, or print the link and headers of a recipient",
+		run:     runUnsubscribeCommand,
+	},
+	{
+		name:    "templates",
+		usage:   "templates list | templates render --name NAME [--version V] [--email E] [--to-name N] [--field k=v ...] [--data recipient.json] [--html-out file.html]",
+		summary: "List email templates or preview a rendered template locally",
+		run:     runTemplatesCommand,
+	},
+}
+
+// errUsage is returned by commands when their arguments are invalid
+var errUsage = errors.New("invalid usage")
+
+// errNotSent and errNoStats are returned by the send and stats steps after they print why
+// they failed, so the command exits non-zero
+var (
+	errNotSent = errors.New("the email was not sent")
+	errNoStats = errors.New("the stats could not be retrieved")
+)
+
+// runCommand dispatches a subcommand and returns the process exit code
+func runCommand(args []string) int {
+	if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		printUsage()
+		return 0
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != args[0] {
+			continue
+		}
+		if err := cmd.run(args[1:]); err != nil {
+			if errors.Is(err, errUsage) {
+				fmt.Printf("Usage: %s\n", cmd.usage)
+				return 2
+			}
+			fmt.Printf("✗ %s failed:\n", cmd.name)
+			fmt.Printf("  Error: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Printf("✗ Unknown command: %s\n\n", args[0])
+	printUsage()
+	return 2
+}
+
+// printUsage prints the list of subcommands
+func printUsage() {
+	fmt.Println("Usage:")
+	fmt.Println("  esp-example              Run the complete ESP workflow")
+	for _, cmd := range commands {
+		fmt.Printf("  esp-example %s\n", cmd.usage)
+		fmt.Printf("      %s\n", cmd.summary)
+	}
+	fmt.Println("\nGlobal flags:")
+	fmt.Println("  --tz ZONE                Print times in ZONE (IANA name, UTC or local) and read times without an offset in it; defaults to SENDPOST_TZ")
+}
+
+// stringListFlag collects the values of a repeatable string flag
+type stringListFlag []string
+
+func (f *stringListFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringListFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// parseFieldFlags turns key=value flag values into a custom fields map
+func parseFieldFlags(values []string) (map[string]interface{}, error) {
+	fields := make(map[string]interface{}, len(values))
+	for _, value := range values {
+		key, val, ok := strings.Cut(value, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("custom field %q must be in key=value form", value)
+		}
+		fields[key] = val
+	}
+	return fields, nil
+}
+
+// pacingFlags registers the send pacing flags shared by the commands that send batches
+type pacingFlags struct {
+	enabled bool
+	rules   stringListFlag
+	noMX    bool
+}
+
+func (p *pacingFlags) register(fs *flag.FlagSet) {
+	fs.BoolVar(&p.enabled, "pacing", false, "pace sends per mailbox provider with the default rules")
+	fs.Var(&p.rules, "pace", "pacing rule as provider=PER_MINUTE[:BURST], implies --pacing (repeatable)")
+	fs.BoolVar(&p.noMX, "no-mx", false, "classify providers by domain only, without MX lookups")
+}
+
+// pacer returns the configured pacer, or nil when pacing is off
+func (p *pacingFlags) pacer() (*Pacer, error) {
+	if !p.enabled && len(p.rules) == 0 {
+		return nil, nil
+	}
+	rules := map[string]PacingRule{}
+	for _, value := range p.rules {
+		provider, rule, err := parsePacingRule(value)
+		if err != nil {
+			return nil, err
+		}
+		rules[provider] = rule
+	}
+	return NewPacer(rules, !p.noMX), nil
+}
+
+// runSendBulkCommand implements "send bulk"
+func runSendBulkCommand(args []string) error {
+	fs := flag.NewFlagSet("send bulk", flag.ContinueOnError)
+	opts := BulkOptions{}
+	fs.StringVar(&opts.RecipientsFile, "recipients", "", "CSV or JSONL file with recipients")
+	fs.StringVar(&opts.Template, "template", "", "template name")
+	fs.StringVar(&opts.TemplateVersion, "version", "", "template version (default: latest)")
+	fs.StringVar(&opts.ResultsFile, "results", "results.csv", "CSV or JSONL file mapping recipients to message IDs or errors")
+	fs.StringVar(&opts.FromEmail, "from", testFromEmail, "sender email address")
+	fs.StringVar(&opts.FromName, "from-name", "Your Company", "sender name")
+	fs.StringVar(&opts.IPPool, "ip-pool", "", "IP pool to send through")
+	fs.IntVar(&opts.Pool.Concurrency, "concurrency", defaultConcurrency, "number of SendEmail requests in flight; each request sends to one recipient")
+	fs.IntVar(&opts.Pool.QueueSize, "queue-size", 0, "prepared requests waiting for a worker (default: 2x concurrency)")
+	fs.StringVar(&opts.QueueDir, "queue-dir", defaultQueueDir, "directory of the durable send queue")
+	fs.StringVar(&opts.SendAt, "send-at", "", "schedule the send: RFC 3339 time, \"YYYY-MM-DD HH:MM\" or +duration")
+	fs.StringVar(&opts.TimezoneField, "tz-field", "", "custom field holding each recipient's time zone for wall-clock --send-at times")
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "plan the send and print its schedule without sending")
+	fs.StringVar(&opts.EMLDir, "render-eml", "", "write an .eml preview per recipient into this directory (implies --dry-run)")
+	var pacing pacingFlags
+	pacing.register(fs)
+	var groupFlags, headerFlags stringListFlag
+	fs.Var(&groupFlags, "group", "group to tag messages with (repeatable)")
+	fs.Var(&headerFlags, "header", "custom header as name=value (repeatable)")
+	if err := fs.Parse(args); err != nil || opts.RecipientsFile == "" || opts.Template == "" {
+		return errUsage
+	}
+
+	var err error
+	if opts.Pool.Pacer, err = pacing.pacer(); err != nil {
+		return err
+	}
+
+	opts.Groups = groupFlags
+	opts.Headers = map[string]string{}
+	for _, value := range headerFlags {
+		name, headerValue, ok := strings.Cut(value, "=")
+		if !ok {
+			return fmt.Errorf("header %q must be in name=value form", value)
+		}
+		opts.Headers[name] = headerValue
+	}
+
+	// Per-recipient time zones may put some send times in the past; those are queued like
+	// the others and go out on the scheduler's next check
+	if opts.SendAt != "" {
+		if opts.TimezoneField == "" || sendAtHasZone(opts.SendAt) {
+			_, err = parseSendAtFlag(opts.SendAt)
+		} else {
+			_, err = resolveSendAt(opts.SendAt, time.Local, time.Now())
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	opts.DryRun = opts.DryRun || opts.EMLDir != ""
+	example := NewESPExample()
+	example.dryRun = opts.DryRun
+	_, err = example.SendBulk(opts)
+	return err
+}
+
+// runCampaignCommand implements the campaign subcommands
+func runCampaignCommand(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	fs := flag.NewFlagSet("campaign "+args[0], flag.ContinueOnError)
+	c := &Campaign{Status: campaignDraft}
+	fs.StringVar(&c.ID, "id", "", "campaign ID (default: derived from the name)")
+	fs.StringVar(&c.Name, "name", "", "campaign name")
+	fs.StringVar(&c.Template, "template", "", "template name")
+	fs.StringVar(&c.TemplateVersion, "version", "", "template version (default: latest at launch)")
+	fs.StringVar(&c.Audience, "audience", "", "CSV or JSONL recipients file")
+	fs.StringVar(&c.FromEmail, "from", testFromEmail, "sender email address")
+	fs.StringVar(&c.FromName, "from-name", "Marketing Team", "sender name")
+	fs.StringVar(&c.IPPool, "ip-pool", "", "IP pool to send through")
+	fs.StringVar(&c.SendAt, "send-at", "", "send time: RFC 3339 time, \"YYYY-MM-DD HH:MM\" or +duration (default: at launch)")
+	fs.StringVar(&c.TimezoneField, "tz-field", "", "custom field holding each recipient's time zone for wall-clock --send-at times")
+	queueDir := fs.String("queue-dir", defaultQueueDir, "directory of the durable send queue")
+	var groupFlags, variantFlags, variantSubjectFlags stringListFlag
+	fs.Var(&groupFlags, "group", "group to tag messages with (repeatable)")
+	fs.Var(&variantFlags, "variant", "A/B test variant as NAME, NAME=TEMPLATE or NAME=TEMPLATE@VERSION (repeatable)")
+	fs.Var(&variantSubjectFlags, "variant-subject", "subject line of a variant as NAME=SUBJECT (repeatable)")
+	abTest := &ABTest{}
+	fs.IntVar(&abTest.TestPercent, "test-percent", defaultTestPercent, "share of the audience that receives the test variants")
+	fs.StringVar(&abTest.Window, "test-window", defaultTestWindow.String(), "how long to collect results before picking a winner")
+	fs.StringVar(&abTest.Metric, "metric", metricOpens, "metric that picks the winner: opens or clicks")
+	eventsFile := fs.String("events", "", "JSONL file of webhook payloads to count opens and clicks from (default: group stats API)")
+	winner := fs.String("winner", "", "variant to send to the rest instead of the automatic pick")
+	force := fs.Bool("force", false, "pick the winner before the test window closes")
+	if err := fs.Parse(args[1:]); err != nil {
+		return errUsage
+	}
+
+	example := NewESPExample()
+	example.queueDir = *queueDir
+	store := example.campaignStore()
+
+	switch args[0] {
+	case "create":
+		if c.ID == "" {
+			c.ID = campaignID(c.Name)
+		}
+		c.Groups = groupFlags
+		c.CreatedAt = time.Now().UTC()
+		variants, err := parseVariantFlags(variantFlags, variantSubjectFlags)
+		if err != nil {
+			return err
+		}
+		if len(variants) > 0 {
+			c.Variants = variants
+			c.ABTest = abTest
+		}
+		// Launch may run from another directory, so keep the audience path absolute
+		if c.Audience != "" {
+			audience, err := filepath.Abs(c.Audience)
+			if err != nil {
+				return err
+			}
+			c.Audience = audience
+		}
+		if err := store.Create(c); err != nil {
+			return err
+		}
+		fmt.Printf("✓ Created campaign %s (%s)\n", c.Name, c.ID)
+		fmt.Printf("  Launch it with: go run . campaign launch --id %s\n", c.ID)
+		return nil
+
+	case "list":
+		campaigns, err := store.List()
+		if err != nil {
+			return err
+		}
+		if len(campaigns) == 0 {
+			fmt.Println("No campaigns")
+		}
+		for _, campaign := range campaigns {
+			fmt.Printf("  %-24s  %-9s  %s\n", campaign.ID, campaign.Status, campaign.Name)
+		}
+		return nil
+	}
+
+	if c.ID == "" {
+		return errUsage
+	}
+	switch args[0] {
+	case "launch":
+		return example.LaunchCampaign(c.ID)
+	case "pause":
+		return example.PauseCampaign(c.ID)
+	case "resume":
+		return example.ResumeCampaign(c.ID)
+	case "status":
+		return example.PrintCampaignStatus(c.ID)
+	case "results":
+		return example.PrintABTestResults(c.ID, *eventsFile)
+	case "pick-winner":
+		return example.SendABTestWinner(c.ID, *eventsFile, *winner, *force)
+	}
+	return errUsage
+}
+
+// runMessagesCommand implements "messages show" and "messages search"
+func runMessagesCommand(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	switch args[0] {
+	case "show":
+		fs := flag.NewFlagSet("messages show", flag.ContinueOnError)
+		id := fs.String("id", "", "message ID")
+		eventsFile := fs.String("events", "", "JSONL file of webhook payloads for the timeline")
+		raw := fs.Bool("raw", false, "print the message API response as JSON")
+		if err := fs.Parse(args[1:]); err != nil || *id == "" {
+			return errUsage
+		}
+		return NewESPExample().ShowMessage(*id, *eventsFile, *raw)
+	case "search":
+	default:
+		return errUsage
+	}
+
+	fs := flag.NewFlagSet("messages search", flag.ContinueOnError)
+	query := MessageQuery{}
+	fs.StringVar(&query.To, "to", "", "recipient address contains")
+	fs.StringVar(&query.From, "from", "", "sender address contains")
+	fs.StringVar(&query.Subject, "subject", "", "subject contains")
+	since := fs.String("since", "", "sent at or after (YYYY-MM-DD, \"YYYY-MM-DD HH:MM\" or RFC 3339)")
+	until := fs.String("until", "", "sent before; a date includes that whole day")
+	subAccount := fs.Int("sub-account", 0, "sub-account ID")
+	fs.StringVar(&query.IPPool, "ip-pool", "", "IP pool name")
+	fs.StringVar(&query.Status, "status", "", "status: sent, rejected or the last webhook event (delivered, opened, hard-bounced, ...)")
+	fs.StringVar(&query.EventsFile, "events", "", "JSONL file of webhook payloads to join by message ID")
+	fs.BoolVar(&query.Fetch, "fetch", false, "look messages up with GetMessageById to fill in sub-account and IP pool")
+	limit := fs.Int("limit", defaultSearchLimit, "messages per page")
+	page := fs.Int("page", 1, "page to show")
+	csvFile := fs.String("csv", "", "write every match to this CSV file")
+	if err := fs.Parse(args[1:]); err != nil || *limit <= 0 {
+		return errUsage
+	}
+	query.SubAccountID = int32(*subAccount)
+
+	var err error
+	if *since != "" {
+		if query.Since, err = parseSearchTime(*since, false); err != nil {
+			return err
+		}
+	}
+	if *until != "" {
+		if query.Until, err = parseSearchTime(*until, true); err != nil {
+			return err
+		}
+	}
+
+	example := NewESPExample()
+	fmt.Println("\n=== Message Search ===")
+	records, err := example.SearchMessages(query)
+	if err != nil {
+		return err
+	}
+	PrintMessages(records, *page, *limit)
+	if *csvFile != "" {
+		if err := WriteMessagesCSV(*csvFile, records); err != nil {
+			return fmt.Errorf("could not write CSV: %w", err)
+		}
+		fmt.Printf("✓ %d message(s) written to %s\n", len(records), *csvFile)
+	}
+	return nil
+}
+
+// runStatsCommand implements "stats subaccount", "stats aggregate", "stats account",
+// "stats export" and "stats report"
+func runStatsCommand(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	switch args[0] {
+	case "subaccount", "aggregate", "account", "export", "report":
+	default:
+		return errUsage
+	}
+
+	fs := flag.NewFlagSet("stats "+args[0], flag.ContinueOnError)
+	statsRange := statsRangeFlags(fs)
+	var subAccounts stringListFlag
+	fs.Var(&subAccounts, "sub-account", "sub-account ID (repeatable for export and report, default all)")
+	csvFile := fs.String("csv", "", "write the stats to this CSV file (export)")
+	parquetFile := fs.String("parquet", "", "write the stats to this Parquet file (export)")
+	thresholds := fs.String("thresholds", "", "health thresholds such as bounce=2:5,complaint=0.1:0.3,delivery=95:90,volume=100")
+	if err := fs.Parse(args[1:]); err != nil {
+		return errUsage
+	}
+	switch args[0] {
+	case "export":
+		if *csvFile == "" && *parquetFile == "" {
+			return errUsage
+		}
+	case "subaccount", "aggregate":
+		if len(subAccounts) != 1 {
+			return errUsage
+		}
+	}
+
+	r, err := statsRange()
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	for _, value := range subAccounts {
+		id, err := strconv.ParseInt(value, 10, 32)
+		if err != nil || id <= 0 {
+			return fmt.Errorf("invalid --sub-account %q", value)
+		}
+		ids = append(ids, id)
+	}
+
+	example := NewESPExample()
+	example.statsRange = r
+	if example.health, err = parseHealthThresholds(*thresholds, example.health); err != nil {
+		return err
+	}
+	switch args[0] {
+	case "subaccount":
+		id := int32(ids[0])
+		example.createdSubAccountID = &id
+		return example.GetSubAccountStats()
+	case "aggregate":
+		id := int32(ids[0])
+		example.createdSubAccountID = &id
+		return example.GetAggregateStats()
+	case "account":
+		return example.GetAccountStats()
+	case "export":
+		return example.ExportStats(r, ids, *csvFile, *parquetFile)
+	case "report":
+		return example.ReportStats(r, ids)
+	}
+	return nil
+}
+
+// runIdempotencyCommand implements "idempotency release"
+func runIdempotencyCommand(args []string) error {
+	if len(args) == 0 || args[0] != "release" {
+		return errUsage
+	}
+
+	fs := flag.NewFlagSet("idempotency release", flag.ContinueOnError)
+	key := fs.String("key", "", "idempotency key to release")
+	if err := fs.Parse(args[1:]); err != nil || *key == "" {
+		return errUsage
+	}
+
+	store, err := NewESPExample().idempotencyStore()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if err := store.Release(*key); err != nil {
+		return err
+	}
+	fmt.Printf("✓ Released idempotency key %s\n", *key)
+	return nil
+}
+
+// runQueueCommand implements "queue status", "queue run" and "queue requeue"
+func runQueueCommand(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	fs := flag.NewFlagSet("queue "+args[0], flag.ContinueOnError)
+	queueDir := fs.String("queue-dir", defaultQueueDir, "directory of the durable send queue")
+	pool := PoolOptions{}
+	fs.IntVar(&pool.Concurrency, "concurrency", defaultConcurrency, "number of SendEmail requests in flight")
+	fs.IntVar(&pool.QueueSize, "queue-size", 0, "prepared requests waiting for a worker (default: 2x concurrency)")
+	var pacing pacingFlags
+	pacing.register(fs)
+	resultsFile := fs.String("results", "", "CSV or JSONL file for the results of this run")
+	id := fs.String("id", "", "request ID to requeue")
+	unknown := fs.Bool("unknown", false, "requeue all requests in unknown state")
+	failed := fs.Bool("failed", false, "requeue all failed requests")
+	if err := fs.Parse(args[1:]); err != nil {
+		return errUsage
+	}
+	var err error
+	if pool.Pacer, err = pacing.pacer(); err != nil {
+		return err
+	}
+
+	example := NewESPExample()
+	queue, err := example.openQueue(*queueDir)
+	if err != nil {
+		return err
+	}
+	defer queue.Close()
+
+	switch args[0] {
+	case "status":
+		queue.PrintStatus()
+		return nil
+
+	case "run":
+		results, stats := example.RunQueue(queue, pool, immediate)
+		if *resultsFile != "" {
+			if err := WriteResults(*resultsFile, results); err != nil {
+				return err
+			}
+			fmt.Printf("  Results written to %s\n", *resultsFile)
+		}
+		stats.Print()
+		return nil
+
+	case "requeue":
+		var statuses []queueStatus
+		if *unknown {
+			statuses = append(statuses, statusUnknown)
+		}
+		if *failed {
+			statuses = append(statuses, statusFailed)
+		}
+		if *id == "" && len(statuses) == 0 {
+			return errUsage
+		}
+
+		var ids []string
+		if *id != "" {
+			entry, ok := queue.Entry(*id)
+			if !ok {
+				return fmt.Errorf("request %s is not in the queue", *id)
+			}
+			if entry.Status == statusPending || entry.Status == statusSent {
+				return fmt.Errorf("request %s is %s and cannot be requeued", *id, entry.Status)
+			}
+			ids = append(ids, *id)
+		}
+		if len(statuses) > 0 {
+			for _, entry := range queue.Entries(statuses...) {
+				ids = append(ids, entry.ID)
+			}
+		}
+
+		for _, requeueID := range ids {
+			if err := queue.Requeue(requeueID); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("✓ Requeued %d request(s); send them with \"queue run\"\n", len(ids))
+		return nil
+	}
+
+	return errUsage
+}
+
+// runScheduleCommand implements "schedule list", "schedule cancel", "schedule reschedule"
+// and "schedule run"
+func runScheduleCommand(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	fs := flag.NewFlagSet("schedule "+args[0], flag.ContinueOnError)
+	queueDir := fs.String("queue-dir", defaultQueueDir, "directory of the durable send queue")
+	id := fs.String("id", "", "scheduled request ID")
+	sendAt := fs.String("send-at", "", "new send time: RFC 3339 time, \"YYYY-MM-DD HH:MM\" or +duration")
+	all := fs.Bool("all", false, "include cancelled sends")
+	interval := fs.Duration("interval", defaultSchedulerInterval, "how often to check for due sends")
+	once := fs.Bool("once", false, "send what is due and exit (for cron)")
+	pool := PoolOptions{}
+	fs.IntVar(&pool.Concurrency, "concurrency", defaultConcurrency, "number of SendEmail requests in flight")
+	fs.IntVar(&pool.QueueSize, "queue-size", 0, "prepared requests waiting for a worker (default: 2x concurrency)")
+	var pacing pacingFlags
+	pacing.register(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		return errUsage
+	}
+
+	if args[0] == "run" {
+		var err error
+		if pool.Pacer, err = pacing.pacer(); err != nil {
+			return err
+		}
+		return NewESPExample().RunScheduler(*queueDir, pool, *interval, *once)
+	}
+
+	example := NewESPExample()
+	queue, err := example.openQueue(*queueDir)
+	if err != nil {
+		return err
+	}
+	defer queue.Close()
+
+	switch args[0] {
+	case "list":
+		fmt.Printf("Scheduled sends in %s:\n", example.sandbox.statePath(*queueDir))
+		queue.PrintSchedule(*all)
+		return nil
+
+	case "cancel":
+		if *id == "" {
+			return errUsage
+		}
+		if err := queue.Cancel(*id); err != nil {
+			return err
+		}
+		fmt.Printf("✓ Cancelled scheduled send %s\n", *id)
+		return nil
+
+	case "reschedule":
+		if *id == "" || *sendAt == "" {
+			return errUsage
+		}
+		at, err := parseSendAtFlag(*sendAt)
+		if err != nil {
+			return err
+		}
+		if err := queue.Reschedule(*id, at); err != nil {
+			return err
+		}
+		fmt.Printf("✓ Rescheduled %s for %s\n", *id, formatTime(at))
+		return nil
+	}
+
+	return errUsage
+}
+
+// runSandboxCommand implements "sandbox list", "sandbox clear" and "sandbox serve"
+func runSandboxCommand(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	fs := flag.NewFlagSet("sandbox "+args[0], flag.ContinueOnError)
+	dir := fs.String("dir", "", "capture Maildir or .mbox file (default: SENDPOST_SANDBOX_DIR or "+defaultSandboxDir+")")
+	addr := fs.String("addr", defaultSandboxAddr, "address the web UI listens on")
+	if err := fs.Parse(args[1:]); err != nil {
+		return errUsage
+	}
+
+	sandbox := &Sandbox{Mode: sandboxCapture, Path: *dir}
+	if sandbox.Path == "" {
+		sandbox.Path = os.Getenv("SENDPOST_SANDBOX_DIR")
+	}
+	if sandbox.Path == "" {
+		sandbox.Path = defaultSandboxDir
+	}
+
+	switch args[0] {
+	case "list":
+		fmt.Printf("Captured messages in %s:\n", sandbox.Path)
+		return sandbox.PrintMessages()
+	case "clear":
+		if err := sandbox.Clear(); err != nil {
+			return err
+		}
+		fmt.Printf("✓ Cleared captured messages in %s\n", sandbox.Path)
+		return nil
+	case "serve":
+		return sandbox.Serve(*addr)
+	default:
+		return errUsage
+	}
+}
+
+// runSMTPCommand implements "smtp serve"
+func runSMTPCommand(args []string) error {
+	if len(args) == 0 || args[0] != "serve" {
+		return errUsage
+	}
+
+	fs := flag.NewFlagSet("smtp serve", flag.ContinueOnError)
+	opts := SMTPOptions{}
+	fs.StringVar(&opts.Addr, "addr", defaultSMTPAddr, "address to listen on")
+	fs.StringVar(&opts.Hostname, "hostname", "", "host name announced to clients (default: system host name)")
+	fs.BoolVar(&opts.Insecure, "insecure", false, "accept AUTH without TLS (local testing only)")
+	fs.Int64Var(&opts.MaxSize, "max-size", defaultSMTPMaxSize, "largest accepted message in bytes")
+	fs.IntVar(&opts.MaxRecipients, "max-recipients", defaultSMTPMaxRecipients, "most recipients per message")
+	certFile := fs.String("tls-cert", "", "PEM certificate for STARTTLS")
+	keyFile := fs.String("tls-key", "", "PEM private key for STARTTLS")
+	usersFile := fs.String("users", "", "file of username:password:sub_account_api_key lines (default: the AUTH password is the API key)")
+	if err := fs.Parse(args[1:]); err != nil || (*certFile == "") != (*keyFile == "") {
+		return errUsage
+	}
+
+	if *certFile != "" {
+		certificate, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+		if err != nil {
+			return fmt.Errorf("could not load TLS certificate: %w", err)
+		}
+		opts.TLSConfig = &tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS12}
+	}
+	if *usersFile != "" {
+		users, err := LoadSMTPUsers(*usersFile)
+		if err != nil {
+			return err
+		}
+		opts.Users = users
+	}
+	return NewESPExample().RunSMTPRelay(opts)
+}
+
+// runPreferencesCommand implements "preferences categories", "preferences show" and "preferences set"
+func runPreferencesCommand(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	fs := flag.NewFlagSet("preferences "+args[0], flag.ContinueOnError)
+	email := fs.String("email", "", "recipient address")
+	var optOut, optIn stringListFlag
+	fs.Var(&optOut, "opt-out", "category the recipient no longer receives (repeatable)")
+	fs.Var(&optIn, "opt-in", "category the recipient receives again (repeatable)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return errUsage
+	}
+
+	example := NewESPExample()
+	switch args[0] {
+	case "categories":
+		categories, err := example.preferences.Categories()
+		if err != nil {
+			return err
+		}
+		fmt.Println("Subscription categories:")
+		for _, category := range categories {
+			fmt.Printf("  - %s: %s (groups: %s)\n", category.Name, category.Description, strings.Join(category.Groups, ", "))
+		}
+		return nil
+	case "show":
+		if *email == "" {
+			return errUsage
+		}
+		return example.PrintPreferences(*email)
+	case "set":
+		if *email == "" || len(optOut)+len(optIn) == 0 {
+			return errUsage
+		}
+		if _, err := example.preferences.Update(*email, optOut, optIn); err != nil {
+			return err
+		}
+		fmt.Printf("✓ Updated preferences of %s\n", *email)
+		return example.PrintPreferences(*email)
+	default:
+		return errUsage
+	}
+}
+
+// runUnsubscribeCommand implements "unsubscribe serve" and "unsubscribe link"
+func runUnsubscribeCommand(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	fs := flag.NewFlagSet("unsubscribe "+args[0], flag.ContinueOnError)
+	addr := fs.String("addr", defaultUnsubscribeAddr, "address the handler listens on")
+	email := fs.String("email", "", "recipient address")
+	list := fs.String("list", "", "comma-separated subscription categories the link unsubscribes from")
+	if err := fs.Parse(args[1:]); err != nil {
+		return errUsage
+	}
+
+	example := NewESPExample()
+	if example.unsubscribe == nil {
+		return fmt.Errorf("set SENDPOST_UNSUBSCRIBE_URL and SENDPOST_UNSUBSCRIBE_SECRET first")
+	}
+
+	switch args[0] {
+	case "serve":
+		return example.ServeUnsubscribe(example.unsubscribe, *addr)
+	case "link":
+		if *email == "" {
+			return errUsage
+		}
+		headers := example.unsubscribe.Headers(*email, *list)
+		fmt.Println(example.unsubscribe.URL(*email, *list))
+		fmt.Printf("  %s: %s\n", listUnsubscribeHeader, headers[listUnsubscribeHeader])
+		fmt.Printf("  %s: %s\n", listUnsubscribePostHeader, headers[listUnsubscribePostHeader])
+		return nil
+	default:
+		return errUsage
+	}
+}
+
+// runTemplatesCommand implements "templates list" and "templates render"
+func runTemplatesCommand(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	example := NewESPExample()
+	registry, err := example.templateRegistry()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		fmt.Printf("Templates in %s:\n", example.templatesDir)
+		for _, name := range registry.Names() {
+			fmt.Printf("  - %s (versions: %s)\n", name, strings.Join(registry.Versions(name), ", "))
+		}
+		return nil
+
+	case "render":
+		fs := flag.NewFlagSet("templates render", flag.ContinueOnError)
+		name := fs.String("name", "", "template name")
+		version := fs.String("version", "", "template version (default: latest)")
+		email := fs.String("email", testToEmail, "recipient email address")
+		toName := fs.String("to-name", "Customer", "recipient name")
+		dataFile := fs.String("data", "", "JSON file with a recipient object (email, name, customFields)")
+		htmlOut := fs.String("html-out", "", "also write the rendered HTML body to this file")
+		var fieldFlags stringListFlag
+		fs.Var(&fieldFlags, "field", "custom field as key=value (repeatable)")
+		if err := fs.Parse(args[1:]); err != nil || *name == "" {
+			return errUsage
+		}
+
+		recipient := sendpost.NewRecipient()
+		if *dataFile != "" {
+			data, err := os.ReadFile(*dataFile)
+			if err != nil {
+				return err
+			}
+			if err := json.Unmarshal(data, recipient); err != nil {
+				return fmt.Errorf("could not parse %s: %w", *dataFile, err)
+			}
+		}
+		if !recipient.HasEmail() {
+			recipient.SetEmail(*email)
+		}
+		if !recipient.HasName() {
+			recipient.SetName(*toName)
+		}
+
+		fields, err := parseFieldFlags(fieldFlags)
+		if err != nil {
+			return err
+		}
+		if len(fields) > 0 {
+			merged := recipient.GetCustomFields()
+			if merged == nil {
+				merged = map[string]interface{}{}
+			}
+			for key, value := range fields {
+				merged[key] = value
+			}
+			recipient.SetCustomFields(merged)
+		}
+
+		tmpl, err := registry.Get(*name, *version)
+		if err != nil {
+			return err
+		}
+		rendered, err := tmpl.Render(*recipient)
+		if err != nil {
+			return err
+		}
+
+		// Preview the content exactly as the send path would post it
+		preview := sendpost.NewEmailMessageObject()
+		preview.SetHtmlBody(rendered.HtmlBody)
+		preview.SetTextBody(rendered.TextBody)
+		warnings := finalizeContent(preview)
+		rendered.HtmlBody = preview.GetHtmlBody()
+		rendered.TextBody = preview.GetTextBody()
+
+		fmt.Printf("=== %s@%s for %s ===\n", tmpl.Name, tmpl.Version, recipient.GetEmail())
+		fmt.Printf("Subject: %s\n", rendered.Subject)
+		fmt.Println("\n--- Text ---")
+		fmt.Println(rendered.TextBody)
+		fmt.Println("\n--- HTML ---")
+		fmt.Println(rendered.HtmlBody)
+
+		if *htmlOut != "" {
+			if err := os.WriteFile(*htmlOut, []byte(rendered.HtmlBody), 0o644); err != nil {
+				return err
+			}
+			fmt.Printf("\n✓ HTML written to %s\n", *htmlOut)
+		}
+		for _, warning := range warnings {
+			fmt.Printf("⚠️  %s\n", warning)
+		}
+		return nil
+	}
+
+	return errUsage
+}
+
+// runSendCommand implements "send transactional" and "send marketing"
+func runSendCommand(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	if args[0] == "bulk" {
+		return runSendBulkCommand(args[1:])
+	}
+
+	// send eml takes the message file before its flags
+	var emlFile string
+	flagArgs := args[1:]
+	if args[0] == "eml" {
+		if len(flagArgs) == 0 || strings.HasPrefix(flagArgs[0], "-") {
+			return errUsage
+		}
+		emlFile, flagArgs = flagArgs[0], flagArgs[1:]
+	}
+
+	fs := flag.NewFlagSet("send "+args[0], flag.ContinueOnError)
+	var attachFlags, inlineFlags stringListFlag
+	fs.Var(&attachFlags, "attach", "file to attach (repeatable)")
+	fs.Var(&inlineFlags, "inline-image", "image to embed, referenced in HTML as cid:<file name> or cid:<cid> when given as cid=path (repeatable)")
+	idempotencyKey := fs.String("idempotency-key", "", "key identifying the logical message; repeats return the recorded result")
+	sendAt := fs.String("send-at", "", "schedule the send: RFC 3339 time, \"YYYY-MM-DD HH:MM\" or +duration")
+	queueDir := fs.String("queue-dir", defaultQueueDir, "directory of the durable send queue for scheduled sends")
+	campaignFlag := fs.String("campaign", "", "stored campaign whose template, sender, send time, headers and groups the marketing email uses")
+	dryRun := fs.Bool("dry-run", false, "validate and print the request payload without sending")
+	renderEML := fs.String("render-eml", "", "write an .eml preview per recipient into this directory (implies --dry-run)")
+	var toFlags stringListFlag
+	fs.Var(&toFlags, "to", "recipient replacing the To, Cc and Bcc headers of the .eml file (repeatable)")
+	if err := fs.Parse(flagArgs); err != nil {
+		return errUsage
+	}
+
+	example := NewESPExample()
+	example.idempotencyKey = *idempotencyKey
+	example.queueDir = *queueDir
+	example.dryRun = *dryRun || *renderEML != ""
+	example.emlDir = *renderEML
+	if *campaignFlag != "" {
+		campaign, err := example.campaignStore().Get(*campaignFlag)
+		if err != nil {
+			return err
+		}
+		example.campaign = campaign
+	}
+	if *sendAt != "" {
+		at, err := parseSendAtFlag(*sendAt)
+		if err != nil {
+			return err
+		}
+		example.sendAt = at
+	}
+	attachments := NewAttachments()
+	for _, path := range attachFlags {
+		if err := attachments.AddFile(path); err != nil {
+			return err
+		}
+	}
+	for _, value := range inlineFlags {
+		contentID, path, ok := strings.Cut(value, "=")
+		if !ok {
+			contentID, path = "", value
+		}
+		if _, err := attachments.AddInlineImage(path, contentID); err != nil {
+			return err
+		}
+	}
+	example.attachments = attachments
+
+	switch args[0] {
+	case "transactional":
+		return example.SendTransactionalEmail()
+	case "marketing":
+		return example.SendMarketingEmail()
+	case "eml":
+		return example.SendEML(emlFile, toFlags)
+	}
+	return errUsage
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+
+	sendpost "github.com/sendpost/sendpost-go-sdk"
+)
+
+// gmailClipThreshold is the HTML size above which Gmail clips a message behind "View entire message"
+const gmailClipThreshold = 102 * 1024
+
+var (
+	styleBlockPattern   = regexp.MustCompile(`(?is)<style[^>]*>(.*?)</style>`)
+	removedBlockPattern = regexp.MustCompile(`(?is)<(head|style|script|title)[^>]*>.*?</(head|style|script|title)>`)
+	commentPattern      = regexp.MustCompile(`(?s)<!--.*?-->`)
+	cssCommentPattern   = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	startTagPattern     = regexp.MustCompile(`<([a-zA-Z][a-zA-Z0-9]*)((?:\s+[^\s>/=]+(?:\s*=\s*(?:"[^"]*"|'[^']*'|[^\s>]+))?)*)\s*(/?)>`)
+	attributePattern    = regexp.MustCompile(`([^\s=]+)(?:\s*=\s*("[^"]*"|'[^']*'|[^\s>]+))?`)
+	linkPattern         = regexp.MustCompile(`(?is)<a\s[^>]*href\s*=\s*["']([^"']+)["'][^>]*>(.*?)</a>`)
+	lineBreakPattern    = regexp.MustCompile(`(?i)<br\s*/?>`)
+	listItemPattern     = regexp.MustCompile(`(?i)(?:</li>\s*)?<li(?:\s[^>]*)?>`)
+	listItemEndPattern  = regexp.MustCompile(`(?i)</li>`)
+	blockEndPattern     = regexp.MustCompile(`(?i)</(p|div|h[1-6]|tr|table|ul|ol|blockquote|section|header|footer)>|<hr[^>]*>`)
+	tagPattern          = regexp.MustCompile(`(?s)<[^>]+>`)
+	spacePattern        = regexp.MustCompile(`[ \t\r\f\v]+`)
+	blankLinesPattern   = regexp.MustCompile(`\n{3,}`)
+	simpleSelector      = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9]*)?((?:[.#][a-zA-Z_-][a-zA-Z0-9_-]*)*)$`)
+	selectorToken       = regexp.MustCompile(`[.#][^.#]+`)
+)
+
+// HTMLToText derives a readable plain-text part from an HTML body
+// Links keep their target as "text (url)", block elements become line breaks and
+// list items are prefixed with "- ", one per line
+func HTMLToText(htmlBody string) string {
+	text := commentPattern.ReplaceAllString(htmlBody, "")
+	text = removedBlockPattern.ReplaceAllString(text, "")
+	text = linkPattern.ReplaceAllStringFunc(text, func(link string) string {
+		parts := linkPattern.FindStringSubmatch(link)
+		label := strings.TrimSpace(tagPattern.ReplaceAllString(parts[2], ""))
+		href := parts[1]
+		if label == "" || label == href || strings.HasPrefix(href, "mailto:") {
+			if label == "" {
+				return href
+			}
+			return label
+		}
+		return label + " (" + href + ")"
+	})
+	text = strings.NewReplacer("\r\n", " ", "\n", " ").Replace(text)
+	text = lineBreakPattern.ReplaceAllString(text, "\n")
+	text = listItemPattern.ReplaceAllString(text, "\n- ")
+	text = listItemEndPattern.ReplaceAllString(text, "\n")
+	text = blockEndPattern.ReplaceAllString(text, "\n\n")
+	text = tagPattern.ReplaceAllString(text, "")
+	text = html.UnescapeString(text)
+	text = strings.ReplaceAll(text, "\u00a0", " ")
+	text = spacePattern.ReplaceAllString(text, " ")
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	text = strings.Join(lines, "\n")
+	text = blankLinesPattern.ReplaceAllString(text, "\n\n")
+	return strings.TrimSpace(text)
+}
+
+// cssRule is one selector with its declarations from a <style> block
+type cssRule struct {
+	tag          string
+	classes      []string
+	id           string
+	declarations string
+	specificity  int
+	order        int
+}
+
+// matches reports whether the rule applies to an element
+func (r cssRule) matches(tag string, classes map[string]bool, id string) bool {
+	if r.tag != "" && r.tag != tag {
+		return false
+	}
+	if r.id != "" && r.id != id {
+		return false
+	}
+	for _, class := range r.classes {
+		if !classes[class] {
+			return false
+		}
+	}
+	return true
+}
+
+// parseSelector parses tag, .class and #id selectors (and their combinations);
+// anything else, such as descendant or pseudo selectors, cannot be inlined
+func parseSelector(selector string) (cssRule, bool) {
+	parts := simpleSelector.FindStringSubmatch(selector)
+	if parts == nil || selector == "" {
+		return cssRule{}, false
+	}
+
+	rule := cssRule{tag: strings.ToLower(parts[1])}
+	if rule.tag != "" {
+		rule.specificity = 1
+	}
+	for _, token := range selectorToken.FindAllString(parts[2], -1) {
+		if token[0] == '#' {
+			rule.id = token[1:]
+			rule.specificity += 100
+		} else {
+			rule.classes = append(rule.classes, token[1:])
+			rule.specificity += 10
+		}
+	}
+	return rule, true
+}
+
+// parseStyleBlock splits a <style> block into rules that can be inlined and CSS that
+// has to stay in the block (at-rules such as @media and unsupported selectors)
+func parseStyleBlock(css string, order *int) ([]cssRule, string) {
+	css = cssCommentPattern.ReplaceAllString(css, "")
+
+	var rules []cssRule
+	var kept strings.Builder
+	for len(strings.TrimSpace(css)) > 0 {
+		css = strings.TrimSpace(css)
+
+		if strings.HasPrefix(css, "@") {
+			// Keep the whole at-rule, including a nested block
+			end := strings.IndexAny(css, ";{")
+			if end < 0 {
+				kept.WriteString(css)
+				break
+			}
+			if css[end] == ';' {
+				kept.WriteString(css[:end+1] + "\n")
+				css = css[end+1:]
+				continue
+			}
+			depth, i := 0, end
+			for ; i < len(css); i++ {
+				if css[i] == '{' {
+					depth++
+				} else if css[i] == '}' {
+					depth--
+					if depth == 0 {
+						break
+					}
+				}
+			}
+			if i >= len(css) {
+				i = len(css) - 1
+			}
+			kept.WriteString(css[:i+1] + "\n")
+			css = css[i+1:]
+			continue
+		}
+
+		open := strings.Index(css, "{")
+		closing := strings.Index(css, "}")
+		if open < 0 || closing < open {
+			break
+		}
+		selectors := css[:open]
+		declarations := strings.TrimSpace(css[open+1 : closing])
+		css = css[closing+1:]
+
+		var unsupported []string
+		for _, selector := range strings.Split(selectors, ",") {
+			selector = strings.TrimSpace(selector)
+			rule, ok := parseSelector(selector)
+			if !ok {
+				unsupported = append(unsupported, selector)
+				continue
+			}
+			rule.declarations = declarations
+			rule.order = *order
+			*order++
+			rules = append(rules, rule)
+		}
+		if len(unsupported) > 0 {
+			kept.WriteString(strings.Join(unsupported, ", ") + " { " + declarations + " }\n")
+		}
+	}
+	return rules, kept.String()
+}
+
+// mergeDeclarations joins CSS declaration lists so that each property appears once,
+// keeping the value of its last occurrence
+func mergeDeclarations(lists []string) string {
+	values := map[string]string{}
+	var properties []string
+	for _, list := range lists {
+		for _, declaration := range strings.Split(list, ";") {
+			property, value, ok := strings.Cut(declaration, ":")
+			if !ok {
+				continue
+			}
+			property = strings.ToLower(strings.TrimSpace(property))
+			if _, seen := values[property]; !seen {
+				properties = append(properties, property)
+			}
+			values[property] = strings.TrimSpace(value)
+		}
+	}
+
+	merged := make([]string, 0, len(properties))
+	for _, property := range properties {
+		merged = append(merged, property+": "+values[property])
+	}
+	return strings.Join(merged, "; ")
+}
+
+// InlineCSS copies the declarations of <style> rules into the style attribute of every
+// matching element, as many mail clients ignore <style> blocks
+// Declarations already present in a style attribute take precedence. Rules that cannot
+// be inlined stay in their <style> block; blocks that become empty are removed.
+func InlineCSS(htmlBody string) string {
+	var rules []cssRule
+	order := 0
+	htmlBody = styleBlockPattern.ReplaceAllStringFunc(htmlBody, func(block string) string {
+		parsed, kept := parseStyleBlock(styleBlockPattern.FindStringSubmatch(block)[1], &order)
+		rules = append(rules, parsed...)
+		if strings.TrimSpace(kept) == "" {
+			return ""
+		}
+		return "<style>\n" + kept + "</style>"
+	})
+	if len(rules) == 0 {
+		return htmlBody
+	}
+
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].specificity != rules[j].specificity {
+			return rules[i].specificity < rules[j].specificity
+		}
+		return rules[i].order < rules[j].order
+	})
+
+	return startTagPattern.ReplaceAllStringFunc(htmlBody, func(tag string) string {
+		parts := startTagPattern.FindStringSubmatch(tag)
+		name := strings.ToLower(parts[1])
+		if name == "style" || name == "head" || name == "html" || name == "meta" || name == "title" {
+			return tag
+		}
+
+		var id, existingStyle string
+		classes := map[string]bool{}
+		var attributes []string
+		for _, attr := range attributePattern.FindAllStringSubmatch(parts[2], -1) {
+			key := strings.ToLower(attr[1])
+			value := strings.Trim(attr[2], `"'`)
+			switch key {
+			case "class":
+				for _, class := range strings.Fields(value) {
+					classes[class] = true
+				}
+			case "id":
+				id = value
+			case "style":
+				existingStyle = value
+				continue
+			}
+			attributes = append(attributes, attr[0])
+		}
+
+		var declarations []string
+		for _, rule := range rules {
+			if rule.matches(name, classes, id) {
+				declarations = append(declarations, strings.TrimSuffix(rule.declarations, ";"))
+			}
+		}
+		if len(declarations) == 0 {
+			return tag
+		}
+		if existingStyle != "" {
+			declarations = append(declarations, strings.TrimSuffix(strings.TrimSpace(existingStyle), ";"))
+		}
+
+		style := strings.ReplaceAll(mergeDeclarations(declarations), `"`, "'")
+		attributes = append(attributes, `style="`+style+`"`)
+		return "<" + parts[1] + " " + strings.Join(attributes, " ") + parts[3] + ">"
+	})
+}
+
+// finalizeContent inlines CSS into the HTML body, derives the text body when none was
+// given and returns warnings about the content
+func finalizeContent(emailMessage *sendpost.EmailMessageObject) []string {
+	var warnings []string
+	if !emailMessage.HasHtmlBody() {
+		return warnings
+	}
+
+	htmlBody := InlineCSS(emailMessage.GetHtmlBody())
+	emailMessage.SetHtmlBody(htmlBody)
+
+	if strings.TrimSpace(emailMessage.GetTextBody()) == "" {
+		emailMessage.SetTextBody(HTMLToText(htmlBody))
+	}
+
+	if len(htmlBody) > gmailClipThreshold {
+		warnings = append(warnings, fmt.Sprintf("HTML body is %d KB; Gmail clips messages above %d KB", len(htmlBody)/1024, gmailClipThreshold/1024))
+	}
+	return warnings
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	sendpost "github.com/sendpost/sendpost-go-sdk"
+)
+
+func TestHTMLToText(t *testing.T) {
+	tests := []struct {
+		name, html, want string
+	}{
+		{"plain paragraph", "<p>Hello world</p>", "Hello world"},
+		{"paragraphs", "<p>One</p><p>Two</p>", "One\n\nTwo"},
+		{"line break", "Line one<br>Line two<br/>Line three", "Line one\nLine two\nLine three"},
+		{"link", `<a href="https://example.com/order">View order</a>`, "View order (https://example.com/order)"},
+		{"link text is url", `<a href="https://example.com">https://example.com</a>`, "https://example.com"},
+		{"empty link", `<a href="https://example.com"><img src="x.png"></a>`, "https://example.com"},
+		{"mailto", `<a href="mailto:help@example.com">Contact us</a>`, "Contact us"},
+		{"list", "<ul><li>First</li><li>Second</li></ul>", "- First\n- Second"},
+		{"list between paragraphs", "<p>Your order:</p>\n<ul>\n  <li>Shirt</li>\n  <li class=\"item\">Shoes</li>\n  <li>Socks</li>\n</ul>\n<p>Thanks</p>", "Your order:\n\n- Shirt\n- Shoes\n- Socks\n\nThanks"},
+		{"unclosed list items", "<ol><li>One<li>Two</ol>", "- One\n- Two"},
+		{"link is not a list item", `<link rel="icon" href="x.ico"><p>Body</p>`, "Body"},
+		{"entities", "<p>Fish &amp; chips&nbsp;&lt;3</p>", "Fish & chips <3"},
+		{"head, style and script removed", "<html><head><title>T</title></head><style>p{}</style><script>x()</script><p>Body</p></html>", "Body"},
+		{"comments removed", "<p>Shown<!-- hidden --></p>", "Shown"},
+		{"source line breaks are spaces", "<p>Wrapped\nsource   line</p>", "Wrapped source line"},
+		{"blank lines collapse", "<div><p>A</p></div><hr><div><p>B</p></div>", "A\n\nB"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HTMLToText(tt.html); got != tt.want {
+				t.Errorf("HTMLToText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInlineCSS(t *testing.T) {
+	tests := []struct {
+		name, html, want string
+	}{
+		{"no style block", `<p class="a">x</p>`, `<p class="a">x</p>`},
+		{"tag selector", `<style>p { color: red; }</style><p>x</p>`, `<p style="color: red">x</p>`},
+		{"class selector", `<style>.note { color: red }</style><p class="big note">x</p><p>y</p>`, `<p class="big note" style="color: red">x</p><p>y</p>`},
+		{"id selector", `<style>#top { margin: 0 }</style><div id="top">x</div>`, `<div id="top" style="margin: 0">x</div>`},
+		{"tag and class", `<style>p.note { color: red }</style><p class="note">x</p><span class="note">y</span>`, `<p class="note" style="color: red">x</p><span class="note">y</span>`},
+		{"specificity beats order", `<style>.note { color: blue } p { color: red }</style><p class="note">x</p>`, `<p class="note" style="color: blue">x</p>`},
+		{"later rule wins at equal specificity", `<style>p { color: red } p { color: green }</style><p>x</p>`, `<p style="color: green">x</p>`},
+		{"style attribute wins", `<style>p { color: red; margin: 0 }</style><p style="color: blue">x</p>`, `<p style="color: blue; margin: 0">x</p>`},
+		{"selector list", `<style>h1, h2 { font-weight: bold }</style><h1>a</h1><h2>b</h2>`, `<h1 style="font-weight: bold">a</h1><h2 style="font-weight: bold">b</h2>`},
+		{"quotes in values", `<style>p { font-family: "Helvetica Neue" }</style><p>x</p>`, `<p style="font-family: 'Helvetica Neue'">x</p>`},
+		{"self-closing tag", `<style>img { border: 0 }</style><img src="a.png" />`, `<img src="a.png" style="border: 0"/>`},
+		{"comments ignored", `<style>/* p { color: red } */ p { margin: 0 }</style><p>x</p>`, `<p style="margin: 0">x</p>`},
+		{"media query kept", `<style>p { margin: 0 } @media (max-width: 600px) { p { margin: 4px } }</style><p>x</p>`, "<style>\n@media (max-width: 600px) { p { margin: 4px } }\n</style><p style=\"margin: 0\">x</p>"},
+		{"unsupported selector kept", `<style>a:hover { color: red } td p { margin: 0 }</style><p>x</p>`, "<style>\na:hover { color: red }\ntd p { margin: 0 }\n</style><p>x</p>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := InlineCSS(tt.html); got != tt.want {
+				t.Errorf("InlineCSS() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFinalizeContent(t *testing.T) {
+	t.Run("derives text", func(t *testing.T) {
+		message := sendpost.NewEmailMessageObject()
+		message.SetHtmlBody("<p>Hello</p>")
+		if warnings := finalizeContent(message); len(warnings) != 0 {
+			t.Errorf("warnings = %v", warnings)
+		}
+		if message.GetTextBody() != "Hello" {
+			t.Errorf("text body = %q", message.GetTextBody())
+		}
+	})
+
+	t.Run("keeps given text", func(t *testing.T) {
+		message := sendpost.NewEmailMessageObject()
+		message.SetHtmlBody("<p>Hello</p>")
+		message.SetTextBody("Custom")
+		finalizeContent(message)
+		if message.GetTextBody() != "Custom" {
+			t.Errorf("text body = %q", message.GetTextBody())
+		}
+	})
+
+	t.Run("warns above the Gmail clip size", func(t *testing.T) {
+		message := sendpost.NewEmailMessageObject()
+		message.SetHtmlBody("<p>" + strings.Repeat("x", gmailClipThreshold) + "</p>")
+		warnings := finalizeContent(message)
+		if len(warnings) != 1 || !strings.Contains(warnings[0], "Gmail clips") {
+			t.Errorf("warnings = %v", warnings)
+		}
+	})
+}
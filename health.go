@@ -0,0 +1,337 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// HealthStatus grades a sub-account's deliverability
+type HealthStatus string
+
+const (
+	healthUnknown  HealthStatus = "not enough volume"
+	healthHealthy  HealthStatus = "healthy"
+	healthWarning  HealthStatus = "warning"
+	healthCritical HealthStatus = "critical"
+)
+
+// StatsRates are deliverability rates derived from stats counters, as fractions
+//
+// Delivery and bounce rates are relative to sent mail (processed when the API reports no
+// sent count). Complaint, open, click and unsubscribe rates are relative to delivered
+// mail, the way mailbox providers measure complaints.
+type StatsRates struct {
+	Volume          int64
+	DeliveryRate    float64
+	BounceRate      float64
+	ComplaintRate   float64
+	OpenRate        float64
+	ClickRate       float64
+	UnsubscribeRate float64
+}
+
+// ratio returns part/whole, or 0 when whole is 0
+func ratio(part, whole int64) float64 {
+	if whole <= 0 {
+		return 0
+	}
+	return float64(part) / float64(whole)
+}
+
+// Rates derives deliverability rates from the counters
+func (s DailyStats) Rates() StatsRates {
+	sent := s.Sent
+	if sent == 0 {
+		sent = s.Processed
+	}
+	return StatsRates{
+		Volume:          sent,
+		DeliveryRate:    ratio(s.Delivered, sent),
+		BounceRate:      ratio(s.HardBounced, sent),
+		ComplaintRate:   ratio(s.Spam, s.Delivered),
+		OpenRate:        ratio(s.Opened, s.Delivered),
+		ClickRate:       ratio(s.Clicked, s.Delivered),
+		UnsubscribeRate: ratio(s.Unsubscribed, s.Delivered),
+	}
+}
+
+// Add sums the counters of other into s, for period totals
+func (s *DailyStats) Add(other DailyStats) {
+	s.Processed += other.Processed
+	s.Sent += other.Sent
+	s.Delivered += other.Delivered
+	s.Dropped += other.Dropped
+	s.SMTPDropped += other.SMTPDropped
+	s.HardBounced += other.HardBounced
+	s.SoftBounced += other.SoftBounced
+	s.Opened += other.Opened
+	s.Clicked += other.Clicked
+	s.Unsubscribed += other.Unsubscribed
+	s.Spam += other.Spam
+}
+
+// statCounterSource is implemented by the SDK's StatStat, AggregateStat and
+// AccountStatsStat, which carry the same counters; their getters return zero for a nil
+// receiver or an unset counter
+type statCounterSource interface {
+	GetProcessed() int32
+	GetSent() int32
+	GetDelivered() int32
+	GetDropped() int32
+	GetSmtpDropped() int32
+	GetHardBounced() int32
+	GetSoftBounced() int32
+	GetOpened() int32
+	GetClicked() int32
+	GetUnsubscribed() int32
+	GetSpam() int32
+}
+
+// statCounters converts the counters of the daily, aggregate or account stats API
+func statCounters(data statCounterSource) DailyStats {
+	return DailyStats{
+		Processed:    int64(data.GetProcessed()),
+		Sent:         int64(data.GetSent()),
+		Delivered:    int64(data.GetDelivered()),
+		Dropped:      int64(data.GetDropped()),
+		SMTPDropped:  int64(data.GetSmtpDropped()),
+		HardBounced:  int64(data.GetHardBounced()),
+		SoftBounced:  int64(data.GetSoftBounced()),
+		Opened:       int64(data.GetOpened()),
+		Clicked:      int64(data.GetClicked()),
+		Unsubscribed: int64(data.GetUnsubscribed()),
+		Spam:         int64(data.GetSpam()),
+	}
+}
+
+// HealthThresholds are the rates, in percent, at which a sub-account turns warning or
+// critical; MinVolume is the sent count below which rates are too noisy to grade
+type HealthThresholds struct {
+	BounceWarning     float64
+	BounceCritical    float64
+	ComplaintWarning  float64
+	ComplaintCritical float64
+	DeliveryWarning   float64
+	DeliveryCritical  float64
+	MinVolume         int64
+}
+
+// defaultHealthThresholds follow common mailbox provider guidance: complaints under
+// 0.1% and never above 0.3%, hard bounces under 2%
+var defaultHealthThresholds = HealthThresholds{
+	BounceWarning:     2,
+	BounceCritical:    5,
+	ComplaintWarning:  0.1,
+	ComplaintCritical: 0.3,
+	DeliveryWarning:   95,
+	DeliveryCritical:  90,
+	MinVolume:         100,
+}
+
+// parseHealthThresholds overrides defaults with a spec such as
+// "bounce=2:5,complaint=0.1:0.3,delivery=95:90,volume=100" (warning:critical, in percent)
+func parseHealthThresholds(spec string, defaults HealthThresholds) (HealthThresholds, error) {
+	t := defaults
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return t, fmt.Errorf("invalid threshold %q: use name=warning:critical", part)
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "volume" {
+			volume, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+			if err != nil || volume < 0 {
+				return t, fmt.Errorf("invalid threshold %q: volume must be a count", part)
+			}
+			t.MinVolume = volume
+			continue
+		}
+
+		warnText, critText, ok := strings.Cut(value, ":")
+		warning, err1 := strconv.ParseFloat(strings.TrimSpace(warnText), 64)
+		critical, err2 := strconv.ParseFloat(strings.TrimSpace(critText), 64)
+		if !ok || err1 != nil || err2 != nil || warning < 0 || critical < 0 || warning > 100 || critical > 100 {
+			return t, fmt.Errorf("invalid threshold %q: use name=warning:critical in percent", part)
+		}
+		switch name {
+		case "bounce":
+			t.BounceWarning, t.BounceCritical = warning, critical
+		case "complaint":
+			t.ComplaintWarning, t.ComplaintCritical = warning, critical
+		case "delivery":
+			t.DeliveryWarning, t.DeliveryCritical = warning, critical
+		default:
+			return t, fmt.Errorf("unknown threshold %q: use bounce, complaint, delivery or volume", name)
+		}
+	}
+
+	if t.BounceCritical < t.BounceWarning || t.ComplaintCritical < t.ComplaintWarning {
+		return t, fmt.Errorf("bounce and complaint critical thresholds must not be below their warning thresholds")
+	}
+	if t.DeliveryCritical > t.DeliveryWarning {
+		return t, fmt.Errorf("the delivery critical threshold must not be above its warning threshold")
+	}
+	return t, nil
+}
+
+// healthThresholdsFromEnv reads SENDPOST_HEALTH_THRESHOLDS over the defaults
+func healthThresholdsFromEnv() (HealthThresholds, error) {
+	t, err := parseHealthThresholds(os.Getenv("SENDPOST_HEALTH_THRESHOLDS"), defaultHealthThresholds)
+	if err != nil {
+		return t, fmt.Errorf("SENDPOST_HEALTH_THRESHOLDS: %w", err)
+	}
+	return t, nil
+}
+
+// Evaluate grades rates against the thresholds and returns the reasons for the grade
+func (t HealthThresholds) Evaluate(rates StatsRates) (HealthStatus, []string) {
+	if rates.Volume < t.MinVolume || rates.Volume == 0 {
+		return healthUnknown, nil
+	}
+
+	status := healthHealthy
+	var reasons []string
+	check := func(name string, rate, warning, critical float64, low bool) {
+		percent := rate * 100
+		past, direction := func(limit float64) bool { return percent > limit }, "above"
+		if low {
+			past, direction = func(limit float64) bool { return percent < limit }, "below"
+		}
+		switch {
+		case past(critical):
+			status = healthCritical
+			reasons = append(reasons, fmt.Sprintf("%s %s is %s the critical threshold of %s", name, formatPercent(rate), direction, formatPercent(critical/100)))
+		case past(warning):
+			if status != healthCritical {
+				status = healthWarning
+			}
+			reasons = append(reasons, fmt.Sprintf("%s %s is %s the warning threshold of %s", name, formatPercent(rate), direction, formatPercent(warning/100)))
+		}
+	}
+	check("bounce rate", rates.BounceRate, t.BounceWarning, t.BounceCritical, false)
+	check("complaint rate", rates.ComplaintRate, t.ComplaintWarning, t.ComplaintCritical, false)
+	check("delivery rate", rates.DeliveryRate, t.DeliveryWarning, t.DeliveryCritical, true)
+	return status, reasons
+}
+
+// formatPercent formats a fraction as a percentage, keeping small rates visible
+func formatPercent(rate float64) string {
+	percent := rate * 100
+	if percent != 0 && percent < 1 {
+		return strconv.FormatFloat(percent, 'f', 2, 64) + "%"
+	}
+	return strconv.FormatFloat(percent, 'f', 1, 64) + "%"
+}
+
+// healthIcon returns the output marker of a status
+func healthIcon(status HealthStatus) string {
+	switch status {
+	case healthCritical:
+		return "✗"
+	case healthWarning:
+		return "⚠️ "
+	case healthHealthy:
+		return "✓"
+	default:
+		return "-"
+	}
+}
+
+// printRates prints the rates of a day or period with the given indent
+func printRates(indent string, rates StatsRates) {
+	fmt.Printf("%sDelivery Rate: %s\n", indent, formatPercent(rates.DeliveryRate))
+	fmt.Printf("%sBounce Rate: %s\n", indent, formatPercent(rates.BounceRate))
+	fmt.Printf("%sComplaint Rate: %s\n", indent, formatPercent(rates.ComplaintRate))
+	fmt.Printf("%sOpen Rate: %s\n", indent, formatPercent(rates.OpenRate))
+	fmt.Printf("%sClick Rate: %s\n", indent, formatPercent(rates.ClickRate))
+	fmt.Printf("%sUnsubscribe Rate: %s\n", indent, formatPercent(rates.UnsubscribeRate))
+}
+
+// formatRates formats rates on one line, for daily rows
+func formatRates(rates StatsRates) string {
+	return fmt.Sprintf("delivery %s, bounce %s, complaint %s, open %s, click %s, unsubscribe %s",
+		formatPercent(rates.DeliveryRate), formatPercent(rates.BounceRate), formatPercent(rates.ComplaintRate),
+		formatPercent(rates.OpenRate), formatPercent(rates.ClickRate), formatPercent(rates.UnsubscribeRate))
+}
+
+// printHealth prints the health grade of rates with its reasons
+func (t HealthThresholds) printHealth(indent string, rates StatsRates) HealthStatus {
+	status, reasons := t.Evaluate(rates)
+	if status == healthUnknown {
+		fmt.Printf("%sHealth: %s %s (%d sent, at least %d needed)\n", indent, healthIcon(status), status, rates.Volume, t.MinVolume)
+		return status
+	}
+	fmt.Printf("%sHealth: %s %s\n", indent, healthIcon(status), status)
+	for _, reason := range reasons {
+		fmt.Printf("%s  - %s\n", indent, reason)
+	}
+	return status
+}
+
+// ReportStats prints daily and period rates of sub-accounts with their health grade
+func (e *ESPExample) ReportStats(r StatsRange, ids []int64) error {
+	fmt.Println("\n=== Stats Report ===")
+	fmt.Printf("  Range: %s\n", r)
+
+	rows, err := e.CollectDailyStats(r, ids)
+	if err != nil {
+		return err
+	}
+
+	var order []int64
+	bySubAccount := map[int64][]DailyStats{}
+	for _, row := range rows {
+		if _, ok := bySubAccount[row.SubAccountID]; !ok {
+			order = append(order, row.SubAccountID)
+		}
+		bySubAccount[row.SubAccountID] = append(bySubAccount[row.SubAccountID], row)
+	}
+	sort.Slice(order, func(i, j int) bool { return order[i] < order[j] })
+
+	type grade struct {
+		id     int64
+		name   string
+		status HealthStatus
+	}
+	var grades []grade
+	for _, id := range order {
+		days := bySubAccount[id]
+		fmt.Printf("\nSub-account %d (%s):\n", id, days[0].SubAccountName)
+		fmt.Printf("  %-10s %8s %9s %7s %10s %7s %7s %7s  %s\n", "Date", "Sent", "Delivery", "Bounce", "Complaint", "Open", "Click", "Unsub", "Health")
+
+		var period DailyStats
+		for _, day := range days {
+			period.Add(day)
+			printRatesRow(day.Date.Format(statDateLayout), day.Rates(), e.health)
+		}
+		printRatesRow("Period", period.Rates(), e.health)
+		status := e.health.printHealth("  ", period.Rates())
+		grades = append(grades, grade{id, days[0].SubAccountName, status})
+	}
+
+	if len(grades) == 0 {
+		fmt.Println("\n  No stats in this range")
+		return nil
+	}
+	fmt.Println("\nOverview:")
+	for _, g := range grades {
+		fmt.Printf("  %s %d %-30s %s\n", healthIcon(g.status), g.id, g.name, g.status)
+	}
+	return nil
+}
+
+// printRatesRow prints one row of the stats report table
+func printRatesRow(label string, rates StatsRates, thresholds HealthThresholds) {
+	status, _ := thresholds.Evaluate(rates)
+	fmt.Printf("  %-10s %8d %9s %7s %10s %7s %7s %7s  %s %s\n", label, rates.Volume,
+		formatPercent(rates.DeliveryRate), formatPercent(rates.BounceRate), formatPercent(rates.ComplaintRate),
+		formatPercent(rates.OpenRate), formatPercent(rates.ClickRate), formatPercent(rates.UnsubscribeRate),
+		healthIcon(status), status)
+}
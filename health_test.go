@@ -0,0 +1,156 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	sendpost "github.com/sendpost/sendpost-go-sdk"
+)
+
+func TestDailyStatsRates(t *testing.T) {
+	tests := []struct {
+		name  string
+		stats DailyStats
+		want  StatsRates
+	}{
+		{"relative to sent and delivered",
+			DailyStats{Processed: 1100, Sent: 1000, Delivered: 800, HardBounced: 30, Spam: 2, Opened: 400, Clicked: 80, Unsubscribed: 4},
+			StatsRates{Volume: 1000, DeliveryRate: 0.8, BounceRate: 0.03, ComplaintRate: 0.0025, OpenRate: 0.5, ClickRate: 0.1, UnsubscribeRate: 0.005}},
+		{"processed when sent is missing",
+			DailyStats{Processed: 200, Delivered: 190, HardBounced: 4},
+			StatsRates{Volume: 200, DeliveryRate: 0.95, BounceRate: 0.02}},
+		{"nothing sent", DailyStats{Opened: 3}, StatsRates{}},
+		{"nothing delivered", DailyStats{Sent: 10, HardBounced: 10, Spam: 1}, StatsRates{Volume: 10, BounceRate: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.stats.Rates(); got != tt.want {
+				t.Errorf("Rates() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHealthEvaluate(t *testing.T) {
+	// sent 1000 with the given delivered, hard bounced and spam counts
+	rates := func(delivered, bounced, spam int64) StatsRates {
+		return DailyStats{Sent: 1000, Delivered: delivered, HardBounced: bounced, Spam: spam}.Rates()
+	}
+
+	tests := []struct {
+		name        string
+		rates       StatsRates
+		want        HealthStatus
+		wantReasons []string
+	}{
+		{"healthy", rates(990, 5, 0), healthHealthy, nil},
+		{"bounce at the warning threshold", rates(1000, 20, 0), healthHealthy, nil},
+		{"bounce past the warning threshold", rates(1000, 21, 0), healthWarning, []string{"bounce rate 2.1% is above the warning threshold of 2.0%"}},
+		{"bounce at the critical threshold", rates(1000, 50, 0), healthWarning, []string{"warning threshold"}},
+		{"bounce past the critical threshold", rates(1000, 51, 0), healthCritical, []string{"bounce rate 5.1% is above the critical threshold of 5.0%"}},
+		{"complaints at the warning threshold", rates(1000, 0, 1), healthHealthy, nil},
+		{"complaints past the warning threshold", rates(1000, 0, 2), healthWarning, []string{"complaint rate 0.20% is above the warning threshold of 0.10%"}},
+		{"complaints at the critical threshold", rates(1000, 0, 3), healthWarning, []string{"complaint rate 0.30% is above the warning threshold"}},
+		{"complaints past the critical threshold", rates(1000, 0, 4), healthCritical, []string{"complaint rate 0.40% is above the critical threshold of 0.30%"}},
+		{"delivery at the warning threshold", rates(950, 0, 0), healthHealthy, nil},
+		{"delivery below the warning threshold", rates(949, 0, 0), healthWarning, []string{"delivery rate 94.9% is below the warning threshold of 95.0%"}},
+		{"delivery at the critical threshold", rates(900, 0, 0), healthWarning, []string{"warning threshold"}},
+		{"delivery below the critical threshold", rates(899, 0, 0), healthCritical, []string{"delivery rate 89.9% is below the critical threshold of 90.0%"}},
+		{"critical wins over an earlier warning", rates(899, 30, 0), healthCritical, []string{"bounce rate 3.0% is above the warning", "delivery rate 89.9% is below the critical"}},
+		{"critical wins over a later warning", rates(949, 60, 0), healthCritical, []string{"bounce rate 6.0% is above the critical", "delivery rate 94.9% is below the warning"}},
+		{"volume below the minimum", DailyStats{Sent: 99, Delivered: 10}.Rates(), healthUnknown, nil},
+		{"volume at the minimum", DailyStats{Sent: 100, Delivered: 100}.Rates(), healthHealthy, nil},
+		{"minimum volume from processed", DailyStats{Processed: 100, Delivered: 80}.Rates(), healthCritical, []string{"delivery rate 80.0%"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, reasons := defaultHealthThresholds.Evaluate(tt.rates)
+			if status != tt.want || len(reasons) != len(tt.wantReasons) {
+				t.Fatalf("Evaluate() = %s, %q; want %s with %d reason(s)", status, reasons, tt.want, len(tt.wantReasons))
+			}
+			for i, want := range tt.wantReasons {
+				if !strings.Contains(reasons[i], want) {
+					t.Errorf("reason %d = %q, want %q", i, reasons[i], want)
+				}
+			}
+		})
+	}
+
+	// Without a minimum volume, an account that sent nothing is still not graded
+	if status, _ := (HealthThresholds{}).Evaluate(StatsRates{}); status != healthUnknown {
+		t.Errorf("Evaluate() of no volume = %s, want %s", status, healthUnknown)
+	}
+}
+
+func TestParseHealthThresholds(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    HealthThresholds
+		wantErr string
+	}{
+		{"empty keeps the defaults", "", defaultHealthThresholds, ""},
+		{"all thresholds", " Bounce=1:3 , complaint=0.05:0.2,delivery=98:92,volume=500",
+			HealthThresholds{BounceWarning: 1, BounceCritical: 3, ComplaintWarning: 0.05, ComplaintCritical: 0.2, DeliveryWarning: 98, DeliveryCritical: 92, MinVolume: 500}, ""},
+		{"one threshold", "volume=0", HealthThresholds{BounceWarning: 2, BounceCritical: 5, ComplaintWarning: 0.1, ComplaintCritical: 0.3, DeliveryWarning: 95, DeliveryCritical: 90}, ""},
+		{"equal warning and critical", "bounce=4:4", HealthThresholds{BounceWarning: 4, BounceCritical: 4, ComplaintWarning: 0.1, ComplaintCritical: 0.3, DeliveryWarning: 95, DeliveryCritical: 90, MinVolume: 100}, ""},
+		{"missing value", "bounce", HealthThresholds{}, "use name=warning:critical"},
+		{"missing critical", "bounce=2", HealthThresholds{}, "in percent"},
+		{"not a number", "complaint=low:high", HealthThresholds{}, "in percent"},
+		{"above 100 percent", "delivery=101:90", HealthThresholds{}, "in percent"},
+		{"negative", "bounce=-1:5", HealthThresholds{}, "in percent"},
+		{"bad volume", "volume=-5", HealthThresholds{}, "volume must be a count"},
+		{"unknown name", "spam=1:2", HealthThresholds{}, "unknown threshold"},
+		{"critical below warning", "bounce=5:2", HealthThresholds{}, "must not be below"},
+		{"delivery critical above warning", "delivery=90:95", HealthThresholds{}, "must not be above"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseHealthThresholds(tt.spec, defaultHealthThresholds)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("parseHealthThresholds() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("parseHealthThresholds() = %+v, %v; want %+v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatCounters(t *testing.T) {
+	stat := sendpost.NewStatStat()
+	stat.SetProcessed(12)
+	stat.SetSent(11)
+	stat.SetDelivered(10)
+	stat.SetHardBounced(1)
+	stat.SetSpam(2)
+	aggregate := sendpost.NewAggregateStat()
+	aggregate.SetDropped(3)
+	aggregate.SetSmtpDropped(4)
+	aggregate.SetSoftBounced(5)
+	account := sendpost.NewAccountStatsStat()
+	account.SetOpened(6)
+	account.SetClicked(7)
+	account.SetUnsubscribed(8)
+
+	tests := []struct {
+		name string
+		data statCounterSource
+		want DailyStats
+	}{
+		{"daily", stat, DailyStats{Processed: 12, Sent: 11, Delivered: 10, HardBounced: 1, Spam: 2}},
+		{"aggregate", aggregate, DailyStats{Dropped: 3, SMTPDropped: 4, SoftBounced: 5}},
+		{"account", account, DailyStats{Opened: 6, Clicked: 7, Unsubscribed: 8}},
+		{"missing", (*sendpost.AggregateStat)(nil), DailyStats{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := statCounters(tt.data); got != tt.want {
+				t.Errorf("statCounters() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	sendpost "github.com/sendpost/sendpost-go-sdk"
+)
+
+// Idempotency settings
+const (
+	idempotencyHeader         = "X-Idempotency-Key"
+	defaultIdempotencyFile    = ".sendpost-idempotency.jsonl"
+	maxIdempotencyKeyLength   = 255
+	idempotencyStatusInFlight = "in-flight"
+	idempotencyStatusSent     = "sent"
+	idempotencyStatusReleased = "released"
+)
+
+// idempotencyRecord is one line of the idempotency log
+type idempotencyRecord struct {
+	Key         string                   `json:"key"`
+	Status      string                   `json:"status"`
+	Fingerprint string                   `json:"fingerprint"`
+	Time        time.Time                `json:"time"`
+	Responses   []sendpost.EmailResponse `json:"responses,omitempty"`
+}
+
+// IdempotencyConflictError is returned when a key cannot be sent with safely
+type IdempotencyConflictError struct {
+	Key    string
+	Reason string
+}
+
+func (e *IdempotencyConflictError) Error() string {
+	return fmt.Sprintf("idempotency key %q: %s", e.Key, e.Reason)
+}
+
+// IdempotencyStore records idempotency key → SendEmail responses in a local append-only log
+//
+// A key is logged in-flight before SendEmail is called and sent with the responses
+// afterwards. A request rejected by the API (4xx) releases the key so it can be retried.
+// If the process stops, or the request fails without a definite answer (timeout, 5xx),
+// the key stays in-flight: SendPost may have accepted the message, so repeats are refused
+// until the message is checked by its X-Idempotency-Key header and the key is released.
+type IdempotencyStore struct {
+	mu       sync.Mutex
+	path     string
+	file     *os.File
+	records  map[string]idempotencyRecord
+	inFlight map[string]bool
+}
+
+// OpenIdempotencyStore opens (or creates) the idempotency log at path and replays it
+func OpenIdempotencyStore(path string) (*IdempotencyStore, error) {
+	s := &IdempotencyStore{path: path, records: map[string]idempotencyRecord{}, inFlight: map[string]bool{}}
+	if err := s.replay(); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open idempotency log: %w", err)
+	}
+	s.file = file
+	return s, nil
+}
+
+// replay loads the latest record per key, truncating a torn final line
+func (s *IdempotencyStore) replay() error {
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not read idempotency log: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		data, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(data) > 0 {
+				if err := os.Truncate(s.path, offset); err != nil {
+					return fmt.Errorf("could not repair idempotency log: %w", err)
+				}
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("could not read idempotency log: %w", err)
+		}
+		offset += int64(len(data))
+
+		var record idempotencyRecord
+		if err := json.Unmarshal(data, &record); err == nil {
+			s.records[record.Key] = record
+		}
+	}
+}
+
+// append writes a record to the log and syncs it to disk; the caller holds s.mu
+func (s *IdempotencyStore) append(record idempotencyRecord) error {
+	record.Time = time.Now().UTC()
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("could not write idempotency log: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("could not sync idempotency log: %w", err)
+	}
+	s.records[record.Key] = record
+	return nil
+}
+
+// Begin reserves a key before sending
+// It returns the recorded responses and true when the key was already sent with the same message,
+// and an *IdempotencyConflictError when it was used for a different message or its
+// earlier attempt has an unknown outcome.
+func (s *IdempotencyStore) Begin(key, fingerprint string) ([]sendpost.EmailResponse, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.inFlight[key] {
+		return nil, false, &IdempotencyConflictError{Key: key, Reason: "a send with this key is already in progress"}
+	}
+
+	record, ok := s.records[key]
+	if ok && record.Status != idempotencyStatusReleased {
+		if record.Fingerprint != fingerprint {
+			return nil, false, &IdempotencyConflictError{Key: key, Reason: "already used for a different message"}
+		}
+		if record.Status == idempotencyStatusSent {
+			return record.Responses, true, nil
+		}
+		return nil, false, &IdempotencyConflictError{Key: key, Reason: fmt.Sprintf("an attempt at %s did not complete and may have been sent; check GetMessageById for the %s header, then run \"idempotency release --key %s\" to retry", formatTime(record.Time), idempotencyHeader, key)}
+	}
+
+	if err := s.append(idempotencyRecord{Key: key, Status: idempotencyStatusInFlight, Fingerprint: fingerprint}); err != nil {
+		return nil, false, err
+	}
+	s.inFlight[key] = true
+	return nil, false, nil
+}
+
+// Complete records the responses of a successful send
+func (s *IdempotencyStore) Complete(key string, responses []sendpost.EmailResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.inFlight, key)
+	return s.append(idempotencyRecord{Key: key, Status: idempotencyStatusSent, Fingerprint: s.records[key].Fingerprint, Responses: responses})
+}
+
+// Abandon ends an attempt whose outcome is unknown; the key stays reserved
+func (s *IdempotencyStore) Abandon(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.inFlight, key)
+}
+
+// Release forgets a key so the next send with it goes out again
+func (s *IdempotencyStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.records[key]; !ok {
+		return fmt.Errorf("idempotency key %q is not recorded", key)
+	}
+	delete(s.inFlight, key)
+	return s.append(idempotencyRecord{Key: key, Status: idempotencyStatusReleased})
+}
+
+// Close closes the idempotency log
+func (s *IdempotencyStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// messageFingerprint hashes a message so a reused key with different content is detected
+// Transport settings (IP pool, webhook endpoint) are left out: a retry through another
+// pool is still the same logical message.
+func messageFingerprint(message *sendpost.EmailMessageObject) (string, error) {
+	content := *message
+	content.Ippool, content.WebhookEndpoint = nil, nil
+	data, err := json.Marshal(content)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// withIdempotencyKey sets the key given with --idempotency-key on a builder
+// Without the flag no key is set, so every run sends a new message.
+func (e *ESPExample) withIdempotencyKey(builder *EmailBuilder) *EmailBuilder {
+	if e.idempotencyKey == "" {
+		return builder
+	}
+	return builder.IdempotencyKey(e.idempotencyKey)
+}
+
+// idempotencyStore opens the idempotency log on first use
+func (e *ESPExample) idempotencyStore() (*IdempotencyStore, error) {
+	if e.idempotency == nil {
+		store, err := OpenIdempotencyStore(e.idempotencyFile)
+		if err != nil {
+			return nil, err
+		}
+		e.idempotency = store
+	}
+	return e.idempotency, nil
+}
+
+// sendEmail sends a message, honouring its X-Idempotency-Key header
+// Messages without the header are sent directly. cached reports that the responses come
+// from an earlier send with the same key and nothing was sent now.
+func (e *ESPExample) sendEmail(ctx context.Context, message *sendpost.EmailMessageObject) (responses []sendpost.EmailResponse, resp *http.Response, cached bool, err error) {
+	key := message.GetHeaders()[idempotencyHeader]
+	if key == "" {
+		responses, resp, err = e.deliver(ctx, message)
+		return responses, resp, false, err
+	}
+
+	store, err := e.idempotencyStore()
+	if err != nil {
+		return nil, nil, false, err
+	}
+	fingerprint, err := messageFingerprint(message)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	recorded, sent, err := store.Begin(key, fingerprint)
+	if err != nil || sent {
+		return recorded, nil, sent, err
+	}
+
+	responses, resp, err = e.deliver(ctx, message)
+	switch {
+	case err == nil:
+		if commitErr := store.Complete(key, responses); commitErr != nil {
+			fmt.Printf("⚠️  Could not record idempotency key %s: %v\n", key, commitErr)
+		}
+	case resp != nil && resp.StatusCode >= 400 && resp.StatusCode < 500:
+		// The API rejected the request, so nothing was sent and the key can be reused
+		if releaseErr := store.Release(key); releaseErr != nil {
+			fmt.Printf("⚠️  Could not release idempotency key %s: %v\n", key, releaseErr)
+		}
+	default:
+		store.Abandon(key)
+	}
+	return responses, resp, false, err
+}
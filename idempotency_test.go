@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	sendpost "github.com/sendpost/sendpost-go-sdk"
+)
+
+func TestMessageFingerprint(t *testing.T) {
+	base := testMessage(t, "a@example.com")
+	fingerprint, _ := messageFingerprint(base)
+
+	tests := []struct {
+		name   string
+		change func(m *sendpost.EmailMessageObject)
+		same   bool
+	}{
+		{"ip pool", func(m *sendpost.EmailMessageObject) { m.SetIppool("Marketing Pool 1760000000") }, true},
+		{"webhook endpoint", func(m *sendpost.EmailMessageObject) { m.SetWebhookEndpoint("https://example.com/hook") }, true},
+		{"subject", func(m *sendpost.EmailMessageObject) { m.SetSubject("Other") }, false},
+		{"recipient", func(m *sendpost.EmailMessageObject) { m.SetTo(testMessage(t, "b@example.com").GetTo()) }, false},
+		{"header", func(m *sendpost.EmailMessageObject) { m.SetHeaders(map[string]string{"X-Order-ID": "1"}) }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed := *base
+			tt.change(&changed)
+			got, err := messageFingerprint(&changed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (got == fingerprint) != tt.same {
+				t.Errorf("fingerprint equal = %v, want %v", got == fingerprint, tt.same)
+			}
+		})
+	}
+	if base.HasIppool() {
+		t.Error("messageFingerprint changed the message")
+	}
+}
+
+func TestIdempotencyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "idempotency.jsonl")
+	store, err := OpenIdempotencyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, sent, err := store.Begin("k1", "f1"); err != nil || sent {
+		t.Fatalf("first Begin() = %v, %v", sent, err)
+	}
+	var conflict *IdempotencyConflictError
+	if _, _, err := store.Begin("k1", "f1"); !errors.As(err, &conflict) {
+		t.Errorf("Begin() while in progress error = %v", err)
+	}
+	response := sendpost.NewEmailResponse()
+	response.SetMessageId("m1")
+	store.Complete("k1", []sendpost.EmailResponse{*response})
+
+	store.Begin("k2", "f2")
+	store.Abandon("k2")
+	store.Close()
+
+	// State survives a restart
+	store, err = OpenIdempotencyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	tests := []struct {
+		name, key, fingerprint string
+		wantSent               bool
+		wantConflict           bool
+	}{
+		{"repeat returns recorded result", "k1", "f1", true, false},
+		{"different message", "k1", "other", false, true},
+		{"unknown outcome", "k2", "f2", false, true},
+		{"new key", "k3", "f3", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			responses, sent, err := store.Begin(tt.key, tt.fingerprint)
+			if sent != tt.wantSent || errors.As(err, &conflict) != tt.wantConflict {
+				t.Fatalf("Begin() = %v, %v; want sent %v, conflict %v", sent, err, tt.wantSent, tt.wantConflict)
+			}
+			if tt.wantSent && (len(responses) != 1 || responses[0].GetMessageId() != "m1") {
+				t.Errorf("responses = %+v", responses)
+			}
+		})
+	}
+
+	if err := store.Release("k2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, sent, err := store.Begin("k2", "changed"); err != nil || sent {
+		t.Errorf("Begin() after release = %v, %v", sent, err)
+	}
+	if err := store.Release("missing"); err == nil {
+		t.Error("Release(missing) succeeded")
+	}
+}
+
+func TestSendEmailIdempotency(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		body      string
+		wantCalls int32
+	}{
+		{"sent once", 200, `[{"to":"a@example.com","messageId":"m1"}]`, 1},
+		{"rejection releases the key", 422, `{"error":"invalid"}`, 2},
+		{"server error keeps the key", 503, `{"error":"unavailable"}`, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			e := newTestExample(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				jsonHandler(tt.status, tt.body).ServeHTTP(w, r)
+			}))
+			e.idempotencyKey = "order-1"
+
+			for i := 0; i < 2; i++ {
+				message, err := e.withIdempotencyKey(NewEmailBuilder().
+					From("sender@example.com", "").
+					To("a@example.com", "").
+					Subject("Hello").
+					Text("Hello").
+					IPPool(fmt.Sprintf("Marketing Pool %d", i))).
+					Build()
+				if err != nil {
+					t.Fatal(err)
+				}
+				e.sendEmail(context.Background(), message)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("SendEmail called %d times, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestWithoutIdempotencyKeyEveryRunSends(t *testing.T) {
+	e := newTestExample(t, jsonHandler(200, `[]`))
+	message, err := e.withIdempotencyKey(validBuilder()).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, ok := message.GetHeaders()[idempotencyHeader]; ok {
+		t.Errorf("message has idempotency key %q without --idempotency-key", key)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	sendpost "github.com/sendpost/sendpost-go-sdk"
@@ -22,6 +23,25 @@ type ESPExample struct {
 	createdIPPoolID      *int64
 	createdIPPoolName    string
 	sentMessageID        string
+	senders              *senderValidator
+	templatesDir         string
+	templates            *TemplateRegistry
+	attachments          *Attachments
+	idempotencyFile      string
+	idempotency          *IdempotencyStore
+	idempotencyKey       string
+	queueDir             string
+	sendAt               time.Time
+	campaignsDir         string
+	campaign             *Campaign
+	dryRun               bool
+	emlDir               string
+	sandbox              *Sandbox
+	unsubscribe          *UnsubscribeLinks
+	preferences          *PreferenceStore
+	sendLog              *SendLog
+	health               HealthThresholds
+	statsRange           StatsRange
 }
 
 // Configuration constants - Update these with your values
@@ -46,6 +66,53 @@ func NewESPExample() *ESPExample {
 		subAccountAPIKey = "YOUR_SUB_ACCOUNT_API_KEY_HERE"
 	}
 
+	templatesDir := os.Getenv("SENDPOST_TEMPLATES_DIR")
+	if templatesDir == "" {
+		templatesDir = defaultTemplatesDir
+	}
+
+	idempotencyFile := os.Getenv("SENDPOST_IDEMPOTENCY_FILE")
+	if idempotencyFile == "" {
+		idempotencyFile = defaultIdempotencyFile
+	}
+
+	campaignsDir := os.Getenv("SENDPOST_CAMPAIGNS_DIR")
+	if campaignsDir == "" {
+		campaignsDir = defaultCampaignsDir
+	}
+
+	preferencesFile := os.Getenv("SENDPOST_PREFERENCES_FILE")
+	if preferencesFile == "" {
+		preferencesFile = defaultPreferencesFile
+	}
+
+	sendLogFile := os.Getenv("SENDPOST_SEND_LOG")
+	if sendLogFile == "" {
+		sendLogFile = defaultSendLogFile
+	}
+
+	// A misconfigured sandbox must not fall back to sending real mail
+	sandbox, err := sandboxFromEnv()
+	if err != nil {
+		fmt.Printf("✗ Invalid sandbox configuration:\n")
+		fmt.Printf("  Error: %v\n", err)
+		os.Exit(2)
+	}
+
+	unsubscribe, err := unsubscribeFromEnv()
+	if err != nil {
+		fmt.Printf("✗ Invalid unsubscribe configuration:\n")
+		fmt.Printf("  Error: %v\n", err)
+		os.Exit(2)
+	}
+
+	health, err := healthThresholdsFromEnv()
+	if err != nil {
+		fmt.Printf("✗ Invalid health threshold configuration:\n")
+		fmt.Printf("  Error: %v\n", err)
+		os.Exit(2)
+	}
+
 	// Create configuration
 	cfg := sendpost.NewConfiguration()
 	cfg.Servers = sendpost.ServerConfigurations{
@@ -61,6 +128,18 @@ func NewESPExample() *ESPExample {
 		client:           client,
 		accountAPIKey:    accountAPIKey,
 		subAccountAPIKey: subAccountAPIKey,
+		senders:          newSenderValidator(client),
+		templatesDir:     templatesDir,
+		idempotencyFile:  sandbox.statePath(idempotencyFile),
+		queueDir:         defaultQueueDir,
+		campaignsDir:     sandbox.statePath(campaignsDir),
+		campaign:         defaultCampaign(),
+		sandbox:          sandbox,
+		unsubscribe:      unsubscribe,
+		preferences:      NewPreferenceStore(preferencesFile),
+		sendLog:          NewSendLog(sandbox.statePath(sendLogFile)),
+		health:           health,
+		statsRange:       defaultStatsRange(),
 	}
 }
 
@@ -77,11 +156,16 @@ func (e *ESPExample) createAccountAuthContext() context.Context {
 
 // createSubAccountAuthContext creates a context with sub-account API key authentication
 func (e *ESPExample) createSubAccountAuthContext() context.Context {
+	return subAccountContext(e.subAccountAPIKey)
+}
+
+// subAccountContext creates a context authenticated with the given sub-account API key
+func subAccountContext(subAccountAPIKey string) context.Context {
 	return context.WithValue(
 		context.Background(),
 		sendpost.ContextAPIKeys,
 		map[string]sendpost.APIKey{
-			"subAccountAuth": {Key: e.subAccountAPIKey},
+			"subAccountAuth": {Key: subAccountAPIKey},
 		},
 	)
 }
@@ -127,7 +211,7 @@ func (e *ESPExample) ListSubAccounts() {
 			fmt.Printf("    Blocked: %s\n", blocked)
 		}
 		if subAccount.Created != nil {
-			fmt.Printf("    Created: %d\n", *subAccount.Created)
+			fmt.Printf("    Created: %s\n", formatEpoch(*subAccount.Created))
 		}
 		fmt.Println()
 
@@ -298,6 +382,7 @@ func (e *ESPExample) AddDomain() {
 	if domain.Id != nil {
 		e.createdDomainID = strconv.FormatInt(int64(*domain.Id), 10)
 	}
+	e.senders.Invalidate(e.subAccountAPIKey)
 
 	fmt.Println("✓ Domain added successfully!")
 	if domain.Id != nil {
@@ -338,6 +423,8 @@ func (e *ESPExample) ListDomains() {
 		return
 	}
 
+	e.senders.Store(e.subAccountAPIKey, domains)
+
 	fmt.Printf("✓ Retrieved %d domain(s)\n", len(domains))
 	for _, domain := range domains {
 		if domain.Id != nil {
@@ -358,54 +445,54 @@ func (e *ESPExample) ListDomains() {
 }
 
 // SendTransactionalEmail sends a transactional email
-func (e *ESPExample) SendTransactionalEmail() {
+func (e *ESPExample) SendTransactionalEmail() error {
 	fmt.Println("\n=== Step 7: Sending Transactional Email ===")
 
-	ctx := e.createSubAccountAuthContext()
-	emailAPI := e.client.EmailAPI
+	if !e.validateSender(testFromEmail, "testFromEmail") {
+		return errNotSent
+	}
 
-	// Create email message
-	from := sendpost.NewEmailAddress()
-	from.SetEmail(testFromEmail)
-	from.SetName("Your Company")
+	ctx := e.createSubAccountAuthContext()
 
+	// Recipient with custom fields used by the template
 	to := sendpost.NewRecipient()
 	to.SetEmail(testToEmail)
 	to.SetName("Customer")
-
-	// Add custom fields
-	customFields := map[string]interface{}{
+	to.SetCustomFields(map[string]interface{}{
 		"customer_id": "67890",
 		"order_value": "99.99",
-	}
-	to.SetCustomFields(customFields)
-
-	toList := []sendpost.Recipient{*to}
+	})
 
-	emailMessage := sendpost.NewEmailMessageObject()
-	emailMessage.SetFrom(*from)
-	emailMessage.SetTo(toList)
-	emailMessage.SetSubject("Order Confirmation - Transactional Email")
-	emailMessage.SetHtmlBody("<h1>Thank you for your order!</h1><p>Your order has been confirmed and will be processed shortly.</p>")
-	emailMessage.SetTextBody("Thank you for your order! Your order has been confirmed and will be processed shortly.")
-
-	// Enable tracking
-	trackOpens := true
-	trackClicks := true
-	emailMessage.SetTrackOpens(trackOpens)
-	emailMessage.SetTrackClicks(trackClicks)
+	// Render subject and bodies from the order confirmation template
+	rendered, err := e.renderEmail("order-confirmation", "", *to)
+	if err != nil {
+		fmt.Printf("✗ Failed to render template:\n")
+		fmt.Printf("  Error: %v\n", err)
+		return errNotSent
+	}
 
-	// Add custom headers
-	headers := map[string]string{
-		"X-Order-ID":   "12345",
-		"X-Email-Type": "transactional",
+	// Assemble the message; CSS is inlined and the text part derived on Build
+	emailMessage, ok := e.buildEmail(e.withIdempotencyKey(NewEmailBuilder().
+		From(testFromEmail, "Your Company").
+		Recipient(*to).
+		Content(rendered).
+		Track(true, true).
+		Header("X-Order-ID", "12345").
+		Header("X-Email-Type", "transactional").
+		IPPool(e.createdIPPoolName).
+		Attach(e.attachments)))
+	if !ok {
+		return errNotSent
+	}
+	if e.previewEmail(emailMessage) {
+		return nil
 	}
-	emailMessage.SetHeaders(headers)
 
-	// Use IP pool if available
-	if e.createdIPPoolName != "" {
-		emailMessage.SetIppool(e.createdIPPoolName)
-		fmt.Printf("  Using IP Pool: %s\n", e.createdIPPoolName)
+	if !e.sendAt.IsZero() {
+		if !e.scheduleEmail(emailMessage, e.sendAt) {
+			return errNotSent
+		}
+		return nil
 	}
 
 	fmt.Println("Sending transactional email...")
@@ -413,13 +500,19 @@ func (e *ESPExample) SendTransactionalEmail() {
 	fmt.Printf("  To: %s\n", testToEmail)
 	fmt.Printf("  Subject: %s\n", emailMessage.GetSubject())
 
-	responses, resp, err := emailAPI.SendEmail(ctx).EmailMessageObject(*emailMessage).Execute()
+	// Retries with the same idempotency key return the recorded result instead of resending
+	responses, resp, cached, err := e.sendEmail(ctx, emailMessage)
 
 	if err != nil {
 		fmt.Printf("✗ Failed to send email:\n")
-		fmt.Printf("  Status code: %d\n", resp.StatusCode)
+		if resp != nil {
+			fmt.Printf("  Status code: %d\n", resp.StatusCode)
+		}
 		fmt.Printf("  Error: %v\n", err)
-		return
+		return errNotSent
+	}
+	if cached {
+		fmt.Printf("  Already sent with idempotency key %s; not sending again\n", emailMessage.GetHeaders()[idempotencyHeader])
 	}
 
 	if len(responses) > 0 {
@@ -436,74 +529,116 @@ func (e *ESPExample) SendTransactionalEmail() {
 			fmt.Printf("  To: %s\n", *response.To)
 		}
 	}
+	return nil
 }
 
 // SendMarketingEmail sends a marketing email
-func (e *ESPExample) SendMarketingEmail() {
+func (e *ESPExample) SendMarketingEmail() error {
 	fmt.Println("\n=== Step 8: Sending Marketing Email ===")
 
-	ctx := e.createSubAccountAuthContext()
-	emailAPI := e.client.EmailAPI
+	campaign := e.campaign
+	if !e.validateSender(campaign.FromEmail, fmt.Sprintf("the sender of campaign %s", campaign.ID)) {
+		return errNotSent
+	}
 
-	// Create email message
-	from := sendpost.NewEmailAddress()
-	from.SetEmail(testFromEmail)
-	from.SetName("Marketing Team")
+	ctx := e.createSubAccountAuthContext()
 
+	// Recipient with custom fields used by the template
 	to := sendpost.NewRecipient()
 	to.SetEmail(testToEmail)
 	to.SetName("Customer 1")
+	to.SetCustomFields(map[string]interface{}{
+		"discount_code": "SAVE20",
+	})
 
-	toList := []sendpost.Recipient{*to}
-
-	emailMessage := sendpost.NewEmailMessageObject()
-	emailMessage.SetFrom(*from)
-	emailMessage.SetTo(toList)
-	emailMessage.SetSubject("Special Offer - 20% Off Everything!")
-	emailMessage.SetHtmlBody(
-		"<html><body>" +
-			"<h1>Special Offer!</h1>" +
-			"<p>Get 20% off on all products. Use code: <strong>SAVE20</strong></p>" +
-			"<p><a href=\"https://example.com/shop\">Shop Now</a></p>" +
-			"</body></html>",
-	)
-	emailMessage.SetTextBody("Special Offer! Get 20% off on all products. Use code: SAVE20. Visit: https://example.com/shop")
+	// Recipients who opted out of the campaign's categories are not sent to
+	if _, skipped, err := e.preferences.Filter([]sendpost.Recipient{*to}, campaign.groups()); err != nil {
+		fmt.Printf("✗ Failed to check preferences:\n")
+		fmt.Printf("  Error: %v\n", err)
+		return errNotSent
+	} else if len(skipped) > 0 {
+		fmt.Printf("Skipping %s: %s\n", skipped[0].Email, skipped[0].Skipped)
+		return nil
+	}
+	categories, _ := e.preferences.CategoriesFor(campaign.groups())
+	list := strings.Join(categories, ",")
+	if e.unsubscribe != nil {
+		to.CustomFields["preferences_url"] = e.unsubscribe.URL(testToEmail, list)
+	}
+
+	// --send-at overrides the campaign's send time
+	sendAt := e.sendAt
+	if sendAt.IsZero() && campaign.SendAt != "" {
+		loc, err := recipientLocation(*to, campaign.TimezoneField)
+		if err == nil {
+			sendAt, err = resolveSendAt(campaign.SendAt, loc, time.Now())
+		}
+		if err != nil {
+			fmt.Printf("✗ Failed to resolve the campaign's send time:\n")
+			fmt.Printf("  Error: %v\n", err)
+			return errNotSent
+		}
+	}
 
-	// Enable tracking
-	trackOpens := true
-	trackClicks := true
-	emailMessage.SetTrackOpens(trackOpens)
-	emailMessage.SetTrackClicks(trackClicks)
+	// Render subject and bodies from the campaign's template
+	rendered, err := e.renderEmail(campaign.Template, campaign.TemplateVersion, *to)
+	if err != nil {
+		fmt.Printf("✗ Failed to render template:\n")
+		fmt.Printf("  Error: %v\n", err)
+		return errNotSent
+	}
 
-	// Add groups for analytics
-	groups := []string{"marketing", "promotional"}
-	emailMessage.SetGroups(groups)
+	// Assemble the message; the campaign adds its headers and groups for analytics
+	builder := campaign.Tag(e.withIdempotencyKey(NewEmailBuilder().
+		From(campaign.FromEmail, campaign.FromName).
+		Recipient(*to).
+		Content(rendered).
+		Track(true, true).
+		Header("X-Email-Type", "marketing").
+		IPPool(e.createdIPPoolName).
+		Attach(e.attachments)))
 
-	// Add custom headers
-	headers := map[string]string{
-		"X-Email-Type":  "marketing",
-		"X-Campaign-ID": "campaign-001",
+	// Bulk sender rules at Gmail and Yahoo require one-click unsubscribe on marketing mail
+	if e.unsubscribe != nil {
+		e.unsubscribe.Tag(builder, testToEmail, list)
+	} else {
+		fmt.Println("⚠️  SENDPOST_UNSUBSCRIBE_URL is not set: sending without List-Unsubscribe headers")
 	}
-	emailMessage.SetHeaders(headers)
 
-	// Use IP pool if available
-	if e.createdIPPoolName != "" {
-		emailMessage.SetIppool(e.createdIPPoolName)
-		fmt.Printf("  Using IP Pool: %s\n", e.createdIPPoolName)
+	emailMessage, ok := e.buildEmail(builder)
+	if !ok {
+		return errNotSent
+	}
+	if e.previewEmail(emailMessage) {
+		return nil
+	}
+
+	// Campaigns usually go out at a planned time; a send time hands the message to the scheduler
+	if !sendAt.IsZero() {
+		if !e.scheduleEmail(emailMessage, sendAt) {
+			return errNotSent
+		}
+		return nil
 	}
 
 	fmt.Println("Sending marketing email...")
-	fmt.Printf("  From: %s\n", testFromEmail)
+	fmt.Printf("  From: %s\n", campaign.FromEmail)
 	fmt.Printf("  To: %s\n", testToEmail)
 	fmt.Printf("  Subject: %s\n", emailMessage.GetSubject())
 
-	responses, resp, err := emailAPI.SendEmail(ctx).EmailMessageObject(*emailMessage).Execute()
+	// Retries with the same idempotency key return the recorded result instead of resending
+	responses, resp, cached, err := e.sendEmail(ctx, emailMessage)
 
 	if err != nil {
 		fmt.Printf("✗ Failed to send email:\n")
-		fmt.Printf("  Status code: %d\n", resp.StatusCode)
+		if resp != nil {
+			fmt.Printf("  Status code: %d\n", resp.StatusCode)
+		}
 		fmt.Printf("  Error: %v\n", err)
-		return
+		return errNotSent
+	}
+	if cached {
+		fmt.Printf("  Already sent with idempotency key %s; not sending again\n", emailMessage.GetHeaders()[idempotencyHeader])
 	}
 
 	if len(responses) > 0 {
@@ -520,6 +655,7 @@ func (e *ESPExample) SendMarketingEmail() {
 			fmt.Printf("  To: %s\n", *response.To)
 		}
 	}
+	return nil
 }
 
 // GetMessageDetails retrieves message details by message ID
@@ -546,89 +682,45 @@ func (e *ESPExample) GetMessageDetails() {
 	}
 
 	fmt.Println("✓ Message retrieved successfully!")
-	if message.MessageID != nil {
-		fmt.Printf("  Message ID: %s\n", *message.MessageID)
-	}
-	if message.AccountID != nil {
-		fmt.Printf("  Account ID: %d\n", *message.AccountID)
-	}
-	if message.SubAccountID != nil {
-		fmt.Printf("  Sub-Account ID: %d\n", *message.SubAccountID)
-	}
-	if message.IpID != nil {
-		fmt.Printf("  IP ID: %d\n", *message.IpID)
-	}
-	if message.PublicIP != nil {
-		fmt.Printf("  Public IP: %s\n", *message.PublicIP)
-	}
-	if message.LocalIP != nil {
-		fmt.Printf("  Local IP: %s\n", *message.LocalIP)
-	}
-	if message.EmailType != nil {
-		fmt.Printf("  Email Type: %s\n", *message.EmailType)
-	}
-	if message.SubmittedAt != nil {
-		fmt.Printf("  Submitted At: %d\n", *message.SubmittedAt)
-	}
-	if message.From.Email != nil {
-		fmt.Printf("  From: %s\n", *message.From.Email)
-	}
-	if message.To != nil && message.To.Email != nil {
-		fmt.Printf("  To: %s\n", *message.To.Email)
-		if message.To.Name != nil {
-			fmt.Printf("    Name: %s\n", *message.To.Name)
-		}
-	}
-	if message.Subject != nil {
-		fmt.Printf("  Subject: %s\n", *message.Subject)
-	}
-	if message.IpPool != nil && *message.IpPool != "" {
-		fmt.Printf("  IP Pool: %s\n", *message.IpPool)
-	}
-	if message.Attempt != nil {
-		fmt.Printf("  Delivery Attempts: %d\n", *message.Attempt)
+	printMessage(message)
+
+	// Without stored webhook events the timeline shows the submission and send log record
+	timeline, err := e.MessageTimeline(message, e.sentMessageID, "")
+	if err != nil {
+		fmt.Printf("⚠️  Could not build timeline: %v\n", err)
+		return
 	}
+	PrintTimeline(timeline)
 }
 
 // GetSubAccountStats retrieves sub-account statistics
-func (e *ESPExample) GetSubAccountStats() {
+func (e *ESPExample) GetSubAccountStats() error {
 	fmt.Println("\n=== Step 10: Getting Sub-Account Statistics ===")
 
 	if e.createdSubAccountID == nil {
 		fmt.Println("✗ No sub-account ID available. Please create or list sub-accounts first.")
-		return
+		return errNoStats
 	}
 
-	ctx := e.createAccountAuthContext()
-	statsAPI := e.client.StatsAPI
-
-	// Get stats for the last 7 days
-	toDate := time.Now()
-	fromDate := toDate.AddDate(0, 0, -7)
-
 	fmt.Printf("Retrieving stats for sub-account ID: %d\n", *e.createdSubAccountID)
-	fmt.Printf("  From: %s\n", fromDate.Format("2006-01-02"))
-	fmt.Printf("  To: %s\n", toDate.Format("2006-01-02"))
-
-	stats, resp, err := statsAPI.AccountSubaccountStatSubaccountIdGet(ctx, int64(*e.createdSubAccountID)).
-		From(fromDate.Format("2006-01-02")).
-		To(toDate.Format("2006-01-02")).
-		Execute()
+	fmt.Printf("  From: %s\n", e.statsRange.From.Format(statDateLayout))
+	fmt.Printf("  To: %s\n", e.statsRange.To.Format(statDateLayout))
 
+	stats, err := e.fetchSubAccountStats(int64(*e.createdSubAccountID), e.statsRange)
 	if err != nil {
 		fmt.Printf("✗ Failed to get stats:\n")
-		fmt.Printf("  Status code: %d\n", resp.StatusCode)
 		fmt.Printf("  Error: %v\n", err)
-		return
+		return errNoStats
 	}
 
 	fmt.Println("✓ Stats retrieved successfully!")
 	fmt.Printf("  Retrieved %d stat record(s)\n", len(stats))
 
 	var totalProcessed, totalDelivered int64
+	var period DailyStats
 	for _, stat := range stats {
 		if stat.Date != nil {
-			fmt.Printf("\n  Date: %s\n", *stat.Date)
+			fmt.Printf("\n  Date: %s\n", formatStatDate(*stat.Date))
 		}
 		if stat.Stat != nil {
 			statData := stat.Stat
@@ -656,43 +748,51 @@ func (e *ESPExample) GetSubAccountStats() {
 				fmt.Printf("    Spam: %d\n", *statData.Spam)
 			}
 		}
+		day := statCounters(stat.Stat)
+		period.Add(day)
+		fmt.Printf("    Rates: %s\n", formatRates(day.Rates()))
 	}
 
-	fmt.Println("\n  Summary (Last 7 days):")
+	fmt.Printf("\n  Summary (%s):\n", e.statsRange)
 	fmt.Printf("    Total Processed: %d\n", totalProcessed)
 	fmt.Printf("    Total Delivered: %d\n", totalDelivered)
+	printRates("    ", period.Rates())
+	e.health.printHealth("    ", period.Rates())
+	return nil
 }
 
 // GetAggregateStats retrieves aggregate statistics
-func (e *ESPExample) GetAggregateStats() {
+func (e *ESPExample) GetAggregateStats() error {
 	fmt.Println("\n=== Step 11: Getting Aggregate Statistics ===")
 
 	if e.createdSubAccountID == nil {
 		fmt.Println("✗ No sub-account ID available. Please create or list sub-accounts first.")
-		return
+		return errNoStats
 	}
 
-	ctx := e.createAccountAuthContext()
 	statsAPI := e.client.StatsAPI
 
-	// Get aggregate stats for the last 7 days
-	toDate := time.Now()
-	fromDate := toDate.AddDate(0, 0, -7)
-
 	fmt.Printf("Retrieving aggregate stats for sub-account ID: %d\n", *e.createdSubAccountID)
-	fmt.Printf("  From: %s\n", fromDate.Format("2006-01-02"))
-	fmt.Printf("  To: %s\n", toDate.Format("2006-01-02"))
-
-	aggregateStat, resp, err := statsAPI.AccountSubaccountStatSubaccountIdAggregateGet(ctx, int64(*e.createdSubAccountID)).
-		From(fromDate.Format("2006-01-02")).
-		To(toDate.Format("2006-01-02")).
-		Execute()
-
+	fmt.Printf("  From: %s\n", e.statsRange.From.Format(statDateLayout))
+	fmt.Printf("  To: %s\n", e.statsRange.To.Format(statDateLayout))
+
+	// Aggregates of long ranges are the sums of their windows
+	aggregateStat, err := fetchAggregate(e.statsRange, func(from, to string) (*sendpost.AggregateStat, error) {
+		ctx, cancel := context.WithTimeout(e.createAccountAuthContext(), statsRequestTimeout)
+		defer cancel()
+		stat, resp, err := statsAPI.AccountSubaccountStatSubaccountIdAggregateGet(ctx, int64(*e.createdSubAccountID)).
+			From(from).
+			To(to).
+			Execute()
+		if err != nil {
+			return nil, apiError("could not get aggregate stats", resp, err)
+		}
+		return stat, nil
+	})
 	if err != nil {
 		fmt.Printf("✗ Failed to get aggregate stats:\n")
-		fmt.Printf("  Status code: %d\n", resp.StatusCode)
 		fmt.Printf("  Error: %v\n", err)
-		return
+		return errNoStats
 	}
 
 	fmt.Println("✓ Aggregate stats retrieved successfully!")
@@ -717,6 +817,10 @@ func (e *ESPExample) GetAggregateStats() {
 	if aggregateStat.Spam != nil {
 		fmt.Printf("  Spam: %d\n", *aggregateStat.Spam)
 	}
+	rates := statCounters(aggregateStat).Rates()
+	printRates("  ", rates)
+	e.health.printHealth("  ", rates)
+	return nil
 }
 
 // ListIPs lists all IPs
@@ -746,7 +850,7 @@ func (e *ESPExample) ListIPs() {
 			fmt.Printf("    Reverse DNS: %s\n", *ip.ReverseDNSHostname)
 		}
 		if ip.Created != 0 {
-			fmt.Printf("    Created: %d\n", ip.Created)
+			fmt.Printf("    Created: %s\n", formatEpoch(ip.Created))
 		}
 		fmt.Println()
 	}
@@ -878,38 +982,27 @@ func (e *ESPExample) ListIPPools() {
 }
 
 // GetAccountStats retrieves account-level statistics
-func (e *ESPExample) GetAccountStats() {
+func (e *ESPExample) GetAccountStats() error {
 	fmt.Println("\n=== Step 15: Getting Account-Level Statistics ===")
 
-	ctx := e.createAccountAuthContext()
-	statsAAPI := e.client.StatsAAPI
-
-	// Get stats for the last 7 days
-	toDate := time.Now()
-	fromDate := toDate.AddDate(0, 0, -7)
-
 	fmt.Println("Retrieving account-level stats...")
-	fmt.Printf("  From: %s\n", fromDate.Format("2006-01-02"))
-	fmt.Printf("  To: %s\n", toDate.Format("2006-01-02"))
-
-	accountStats, resp, err := statsAAPI.GetAllAccountStats(ctx).
-		From(fromDate.Format("2006-01-02")).
-		To(toDate.Format("2006-01-02")).
-		Execute()
+	fmt.Printf("  From: %s\n", e.statsRange.From.Format(statDateLayout))
+	fmt.Printf("  To: %s\n", e.statsRange.To.Format(statDateLayout))
 
+	accountStats, err := e.fetchAccountStats(e.statsRange)
 	if err != nil {
 		fmt.Printf("✗ Failed to get account stats:\n")
-		fmt.Printf("  Status code: %d\n", resp.StatusCode)
 		fmt.Printf("  Error: %v\n", err)
-		return
+		return errNoStats
 	}
 
 	fmt.Println("✓ Account stats retrieved successfully!")
 	fmt.Printf("  Retrieved %d stat record(s)\n", len(accountStats))
 
+	var period DailyStats
 	for _, stat := range accountStats {
 		if stat.Date != nil {
-			fmt.Printf("\n  Date: %s\n", *stat.Date)
+			fmt.Printf("\n  Date: %s\n", formatStatDate(*stat.Date))
 		}
 		if stat.Stat != nil {
 			statData := stat.Stat
@@ -941,7 +1034,15 @@ func (e *ESPExample) GetAccountStats() {
 				fmt.Printf("    Spam: %d\n", *statData.Spam)
 			}
 		}
+		day := statCounters(stat.Stat)
+		period.Add(day)
+		fmt.Printf("    Rates: %s\n", formatRates(day.Rates()))
 	}
+
+	fmt.Printf("\n  Summary (%s):\n", e.statsRange)
+	printRates("    ", period.Rates())
+	e.health.printHealth("    ", period.Rates())
+	return nil
 }
 
 // RunCompleteWorkflow runs the complete ESP workflow
@@ -977,6 +1078,7 @@ func (e *ESPExample) RunCompleteWorkflow() {
 	e.ListIPPools()
 
 	// Step 5: Send emails (using the created IP pool)
+	// Each step prints its own failure; the workflow carries on to show the remaining steps
 	e.SendTransactionalEmail()
 	e.SendMarketingEmail()
 
@@ -996,6 +1098,20 @@ func (e *ESPExample) RunCompleteWorkflow() {
 }
 
 func main() {
+	args, zone, err := extractTimezoneFlag(os.Args[1:])
+	if err == nil {
+		err = setDisplayZone(zone)
+	}
+	if err != nil {
+		fmt.Printf("✗ Invalid time zone:\n")
+		fmt.Printf("  Error: %v\n", err)
+		os.Exit(2)
+	}
+
+	if len(args) > 0 {
+		os.Exit(runCommand(args))
+	}
+
 	example := NewESPExample()
 	example.RunCompleteWorkflow()
 }
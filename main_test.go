@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	sendpost "github.com/sendpost/sendpost-go-sdk"
+)
+
+// newTestExample returns an ESPExample whose API calls go to handler and whose local
+// state lives in a temporary directory
+func newTestExample(t *testing.T, handler http.Handler) *ESPExample {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	cfg := sendpost.NewConfiguration()
+	cfg.Servers = sendpost.ServerConfigurations{{URL: server.URL}}
+	client := sendpost.NewAPIClient(cfg)
+
+	dir := t.TempDir()
+	return &ESPExample{
+		client:           client,
+		accountAPIKey:    "account-key",
+		subAccountAPIKey: "sub-account-key",
+		senders:          newSenderValidator(client),
+		templatesDir:     defaultTemplatesDir,
+		idempotencyFile:  filepath.Join(dir, "idempotency.jsonl"),
+		queueDir:         filepath.Join(dir, "queue"),
+		campaignsDir:     filepath.Join(dir, "campaigns"),
+		campaign:         defaultCampaign(),
+		preferences:      NewPreferenceStore(filepath.Join(dir, "preferences.json")),
+		sendLog:          NewSendLog(filepath.Join(dir, "sends.jsonl")),
+		health:           defaultHealthThresholds,
+		statsRange:       defaultStatsRange(),
+	}
+}
+
+// jsonHandler answers every request with status and a JSON body
+func jsonHandler(status int, body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	})
+}
+
+// testMessage builds a minimal valid message to one recipient
+func testMessage(t *testing.T, to string) *sendpost.EmailMessageObject {
+	t.Helper()
+	message, err := NewEmailBuilder().
+		From("sender@example.com", "").
+		To(to, "").
+		Subject("Hello").
+		Text("Hello").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return message
+}
+
+// captureStdout returns what fn prints
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		output <- string(data)
+	}()
+	fn()
+	w.Close()
+	return <-output
+}
@@ -0,0 +1,671 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	sendpost "github.com/sendpost/sendpost-go-sdk"
+)
+
+// Send log and message search settings
+const (
+	defaultSendLogFile = ".sendpost-sends.jsonl"
+	defaultSearchLimit = 50
+	messageStatusSent  = "sent"
+	messageStatusError = "rejected"
+)
+
+// Webhook event type codes (Event.Type); the SDK does not enumerate them, so keep these
+// in line with SendPost's webhook reference
+const (
+	eventTypeProcessed    = 0
+	eventTypeDropped      = 1
+	eventTypeDelivered    = 2
+	eventTypeSoftBounced  = 3
+	eventTypeHardBounced  = 4
+	eventTypeOpened       = 5
+	eventTypeClicked      = 6
+	eventTypeUnsubscribed = 7
+	eventTypeSpam         = 8
+)
+
+// eventTypeNames are the statuses shown for webhook event types
+var eventTypeNames = map[int32]string{
+	eventTypeProcessed:    "processed",
+	eventTypeDropped:      "dropped",
+	eventTypeDelivered:    "delivered",
+	eventTypeSoftBounced:  "soft-bounced",
+	eventTypeHardBounced:  "hard-bounced",
+	eventTypeOpened:       "opened",
+	eventTypeClicked:      "clicked",
+	eventTypeUnsubscribed: "unsubscribed",
+	eventTypeSpam:         "spam",
+}
+
+// eventTypeName returns the status name of an event type
+func eventTypeName(eventType int32) string {
+	if name, ok := eventTypeNames[eventType]; ok {
+		return name
+	}
+	return fmt.Sprintf("event-%d", eventType)
+}
+
+// MessageRecord is one message to one recipient, as logged when it was sent and updated
+// from webhook events and the message API
+type MessageRecord struct {
+	MessageID    string    `json:"messageId,omitempty"`
+	SentAt       time.Time `json:"sentAt"`
+	From         string    `json:"from"`
+	To           string    `json:"to"`
+	Subject      string    `json:"subject,omitempty"`
+	Groups       []string  `json:"groups,omitempty"`
+	IPPool       string    `json:"ipPool,omitempty"`
+	SubAccountID int32     `json:"subAccountId,omitempty"`
+	Status       string    `json:"status"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// SendLog appends a record per recipient of every SendEmail response to a local JSONL file
+// SendPost has no message listing API, so this log is what message search starts from.
+type SendLog struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewSendLog creates a send log at path
+func NewSendLog(path string) *SendLog {
+	return &SendLog{path: path}
+}
+
+// Record logs the responses of one SendEmail request
+func (l *SendLog) Record(message *sendpost.EmailMessageObject, responses []sendpost.EmailResponse) error {
+	now := time.Now().UTC()
+	var lines []byte
+	for _, response := range responses {
+		record := MessageRecord{
+			MessageID: response.GetMessageId(),
+			SentAt:    now,
+			From:      message.From.GetEmail(),
+			To:        response.GetTo(),
+			Subject:   message.GetSubject(),
+			Groups:    message.GetGroups(),
+			IPPool:    message.GetIppool(),
+			Status:    messageStatusSent,
+		}
+		if response.GetErrorCode() != 0 {
+			record.Status = messageStatusError
+			record.Error = fmt.Sprintf("error %d: %s", response.GetErrorCode(), response.GetMessage())
+		}
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, data...), '\n')
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("could not open send log: %w", err)
+	}
+	if _, err := file.Write(lines); err != nil {
+		file.Close()
+		return fmt.Errorf("could not write send log: %w", err)
+	}
+	return file.Close()
+}
+
+// Read returns every record of the log, skipping lines it cannot parse
+func (l *SendLog) Read() ([]MessageRecord, error) {
+	file, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read send log: %w", err)
+	}
+	defer file.Close()
+
+	var records []MessageRecord
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var record MessageRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err == nil {
+			records = append(records, record)
+		}
+	}
+	return records, scanner.Err()
+}
+
+// readEvents calls fn for every event in a JSONL file of webhook payloads, as stored by a
+// webhook endpoint; each line is a webhook object or a bare event
+func readEvents(path string, fn func(event *sendpost.Event)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var object sendpost.WebhookObject
+		if err := json.Unmarshal([]byte(text), &object); err != nil {
+			return fmt.Errorf("events line %d: %w", line, err)
+		}
+		event := object.Event
+		if event == nil {
+			event = sendpost.NewEvent()
+			if err := json.Unmarshal([]byte(text), event); err != nil {
+				return fmt.Errorf("events line %d: %w", line, err)
+			}
+		}
+		fn(event)
+	}
+	return scanner.Err()
+}
+
+// MessageQuery selects messages for a search; empty fields match everything
+// To, From and Subject match case-insensitive substrings. Since and Until bound the send
+// time; messages known only from events without SubmittedAt have no send time and are
+// kept. Fetch looks messages up with GetMessageById to fill in what the local sources lack.
+type MessageQuery struct {
+	To           string
+	From         string
+	Subject      string
+	Since        time.Time
+	Until        time.Time
+	SubAccountID int32
+	IPPool       string
+	Status       string
+	EventsFile   string
+	Fetch        bool
+}
+
+// matchesLocal checks the filters the local sources can always answer
+func (q MessageQuery) matchesLocal(record MessageRecord) bool {
+	contains := func(value, part string) bool {
+		return part == "" || strings.Contains(strings.ToLower(value), strings.ToLower(part))
+	}
+	// An unknown send time is not known to be outside the range
+	known := !record.SentAt.IsZero()
+	switch {
+	case !contains(record.To, q.To), !contains(record.From, q.From), !contains(record.Subject, q.Subject):
+		return false
+	case known && !q.Since.IsZero() && record.SentAt.Before(q.Since):
+		return false
+	case known && !q.Until.IsZero() && !record.SentAt.Before(q.Until):
+		return false
+	case q.Status != "" && !strings.EqualFold(record.Status, q.Status):
+		return false
+	}
+	return true
+}
+
+// matchesRemote checks the filters that may need the message API to answer
+func (q MessageQuery) matchesRemote(record MessageRecord) bool {
+	if q.SubAccountID != 0 && record.SubAccountID != q.SubAccountID {
+		return false
+	}
+	return q.IPPool == "" || strings.EqualFold(record.IPPool, q.IPPool)
+}
+
+// SearchMessages returns the messages matching a query, newest first
+//
+// Messages come from the send log, joined by message ID with webhook events from
+// q.EventsFile: the last event of a message sets its status, and events of messages sent
+// elsewhere add those messages. With q.Fetch every match without a sub-account is looked
+// up with GetMessageById before the sub-account and IP pool filters are applied.
+func (e *ESPExample) SearchMessages(q MessageQuery) ([]MessageRecord, error) {
+	logged, err := e.sendLog.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*MessageRecord
+	byID := map[string]*MessageRecord{}
+	for i := range logged {
+		record := &logged[i]
+		records = append(records, record)
+		if record.MessageID != "" {
+			byID[record.MessageID] = record
+		}
+	}
+
+	if q.EventsFile != "" {
+		err := readEvents(q.EventsFile, func(event *sendpost.Event) {
+			id := event.GetMessageID()
+			if id == "" {
+				return
+			}
+			record, ok := byID[id]
+			if !ok {
+				record = &MessageRecord{
+					MessageID: id,
+					From:      event.GetFrom(),
+					To:        event.GetTo(),
+					Subject:   event.GetMessageSubject(),
+					Groups:    event.Groups,
+				}
+				if event.SubmittedAt != nil {
+					record.SentAt = epochTime(int64(event.GetSubmittedAt())).UTC()
+				}
+				byID[id] = record
+				records = append(records, record)
+			}
+			if record.SubAccountID == 0 {
+				record.SubAccountID = event.GetSubAccountID()
+			}
+			record.Status = eventTypeName(event.GetType())
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not read events: %w", err)
+		}
+	}
+
+	ctx := e.createAccountAuthContext()
+	var matches []MessageRecord
+	for _, record := range records {
+		if !q.matchesLocal(*record) {
+			continue
+		}
+		if q.Fetch && record.MessageID != "" && record.SubAccountID == 0 {
+			message, resp, err := e.client.MessageAPI.GetMessageById(ctx, record.MessageID).Execute()
+			if err != nil {
+				if resp != nil {
+					err = fmt.Errorf("status %d: %w", resp.StatusCode, err)
+				}
+				fmt.Printf("  ⚠️  Could not fetch message %s: %v\n", record.MessageID, err)
+			} else {
+				record.SubAccountID = message.GetSubAccountID()
+				if record.IPPool == "" {
+					record.IPPool = message.GetIpPool()
+				}
+			}
+		}
+		if q.matchesRemote(*record) {
+			matches = append(matches, *record)
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].SentAt.After(matches[j].SentAt) })
+	return matches, nil
+}
+
+// parseSearchTime parses a --since or --until value: RFC 3339, a local "YYYY-MM-DD HH:MM"
+// or a date, which for --until means the end of that day
+func parseSearchTime(value string, endOfDay bool) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if day, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		if endOfDay {
+			return day.AddDate(0, 0, 1), nil
+		}
+		return day, nil
+	}
+	at, err := resolveSendAt(value, time.Local, time.Now())
+	if err != nil || strings.HasPrefix(value, "+") {
+		return time.Time{}, fmt.Errorf("invalid time %q: use YYYY-MM-DD, \"YYYY-MM-DD HH:MM\" or RFC 3339", value)
+	}
+	return at, nil
+}
+
+// PrintMessages prints one page of search results; page counts from 1
+func PrintMessages(records []MessageRecord, page, limit int) {
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	pages := (len(records) + limit - 1) / limit
+	if len(records) == 0 {
+		fmt.Println("  No messages found")
+		return
+	}
+	if page < 1 || page > pages {
+		fmt.Printf("  Page %d is out of range: %d page(s) of %d message(s)\n", page, pages, len(records))
+		return
+	}
+
+	start := (page - 1) * limit
+	end := start + limit
+	if end > len(records) {
+		end = len(records)
+	}
+	fmt.Printf("  %-24s %-28s %-28s %-13s %s\n", "Sent", "To", "From", "Status", "Subject")
+	for _, record := range records[start:end] {
+		fmt.Printf("  %-24s %-28s %-28s %-13s %s\n", formatTableTime(record.SentAt), record.To, record.From, record.Status, record.Subject)
+		if record.MessageID != "" {
+			fmt.Printf("  %-24s %s\n", "", record.MessageID)
+		}
+		if record.Error != "" {
+			fmt.Printf("  %-24s ✗ %s\n", "", record.Error)
+		}
+	}
+	fmt.Printf("\n  Page %d of %d (%d message(s))\n", page, pages, len(records))
+	if page < pages {
+		fmt.Printf("  Next page: --page %d\n", page+1)
+	}
+}
+
+// WriteMessagesCSV writes search results to a CSV file
+func WriteMessagesCSV(path string, records []MessageRecord) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"sent_at", "message_id", "from", "to", "subject", "status", "error", "groups", "ip_pool", "sub_account_id"})
+	for _, record := range records {
+		sentAt, subAccount := "", ""
+		if !record.SentAt.IsZero() {
+			sentAt = formatRFC3339(record.SentAt)
+		}
+		if record.SubAccountID != 0 {
+			subAccount = strconv.Itoa(int(record.SubAccountID))
+		}
+		writer.Write([]string{sentAt, record.MessageID, record.From, record.To, record.Subject, record.Status,
+			record.Error, strings.Join(record.Groups, " "), record.IPPool, subAccount})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// TimelineEntry is one step in a message's delivery
+type TimelineEntry struct {
+	At      time.Time
+	Status  string
+	Source  string
+	Details []string
+}
+
+// MessageTimeline merges what is known about a message into chronological order: the
+// submission time from the message API, the send log record and webhook events
+// Events carry no arrival time of their own beyond SubmittedAt; entries without a time
+// keep their place after the entry before them.
+func (e *ESPExample) MessageTimeline(message *sendpost.Message, id, eventsFile string) ([]TimelineEntry, error) {
+	var timeline []TimelineEntry
+	if message != nil && message.SubmittedAt != nil {
+		timeline = append(timeline, TimelineEntry{
+			At:     epochTime(message.GetSubmittedAt()),
+			Status: "submitted",
+			Source: "message API",
+		})
+	}
+
+	logged, err := e.sendLog.Read()
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range logged {
+		if record.MessageID != id {
+			continue
+		}
+		entry := TimelineEntry{At: record.SentAt, Status: record.Status, Source: "send log"}
+		if record.Error != "" {
+			entry.Details = append(entry.Details, record.Error)
+		}
+		timeline = append(timeline, entry)
+	}
+
+	if eventsFile != "" {
+		err := readEvents(eventsFile, func(event *sendpost.Event) {
+			if event.GetMessageID() != id {
+				return
+			}
+			entry := TimelineEntry{Status: eventTypeName(event.GetType()), Source: "webhook"}
+			if event.SubmittedAt != nil {
+				entry.At = epochTime(int64(event.GetSubmittedAt()))
+			}
+			entry.Details = eventDetails(event)
+			timeline = append(timeline, entry)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not read events: %w", err)
+		}
+	}
+
+	for i := 1; i < len(timeline); i++ {
+		if timeline[i].At.IsZero() {
+			timeline[i].At = timeline[i-1].At
+		}
+	}
+	sort.SliceStable(timeline, func(i, j int) bool { return timeline[i].At.Before(timeline[j].At) })
+	return timeline, nil
+}
+
+// eventDetails describes an event: the remote MTA response for deliveries and bounces,
+// and the client for opens and clicks
+func eventDetails(event *sendpost.Event) []string {
+	var details []string
+	metadata := event.GetEventMetadata()
+
+	code, description := event.GetSmtpCode(), event.GetSmtpDescription()
+	if code == 0 {
+		code = metadata.GetSmtpCode()
+	}
+	if description == "" {
+		description = metadata.GetSmtpDescription()
+	}
+	switch {
+	case code != 0 || description != "":
+		response := strings.TrimSpace(fmt.Sprintf("%d %s", code, description))
+		if code == 0 {
+			response = description
+		}
+		label := "MTA response"
+		switch event.GetType() {
+		case eventTypeSoftBounced, eventTypeHardBounced, eventTypeDropped:
+			label = "Reason"
+		}
+		details = append(details, label+": "+response)
+	case event.GetType() == eventTypeSoftBounced || event.GetType() == eventTypeHardBounced:
+		details = append(details, "Reason: not reported")
+	}
+
+	if url := metadata.GetClickedURL(); url != "" {
+		details = append(details, "URL: "+url)
+	}
+	var client []string
+	if device := metadata.GetDevice(); device.GetFamily() != "" {
+		client = append(client, device.GetFamily())
+	}
+	if system := metadata.GetOs(); system.GetFamily() != "" {
+		client = append(client, strings.TrimSpace(system.GetFamily()+" "+system.GetMajor()))
+	}
+	if geo := metadata.GetGeo(); geo.GetCountryCode() != "" {
+		client = append(client, geo.GetCountryCode())
+	}
+	if ip := metadata.GetTrackedIP(); ip != "" {
+		client = append(client, ip)
+	}
+	if len(client) > 0 {
+		details = append(details, "Client: "+strings.Join(client, ", "))
+	}
+	return details
+}
+
+// PrintTimeline prints a message's delivery timeline
+func PrintTimeline(timeline []TimelineEntry) {
+	fmt.Println("\n  Timeline:")
+	if len(timeline) == 0 {
+		fmt.Println("    No events known; pass --events with stored webhook payloads")
+		return
+	}
+	for _, entry := range timeline {
+		fmt.Printf("    %-24s %-13s (%s)\n", formatTableTime(entry.At), entry.Status, entry.Source)
+		for _, detail := range entry.Details {
+			fmt.Printf("    %-24s   %s\n", "", detail)
+		}
+	}
+}
+
+// printMessage prints every field of a message returned by GetMessageById
+func printMessage(message *sendpost.Message) {
+	printField := func(name string, value interface{}) {
+		fmt.Printf("  %s: %v\n", name, value)
+	}
+	printList := func(name string, values []string) {
+		if len(values) > 0 {
+			printField(name, strings.Join(values, ", "))
+		}
+	}
+	printMap := func(name string, values map[string]string) {
+		if len(values) == 0 {
+			return
+		}
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		fmt.Printf("  %s:\n", name)
+		indent := name[:len(name)-len(strings.TrimLeft(name, " "))]
+		for _, key := range keys {
+			fmt.Printf("    %s%s: %s\n", indent, key, values[key])
+		}
+	}
+	printPerson := func(name string, email, personName *string) {
+		if email == nil {
+			return
+		}
+		if personName != nil && *personName != "" {
+			printField(name, formatAddress(*email, *personName))
+		} else {
+			printField(name, *email)
+		}
+	}
+	printBody := func(name string, body *string) {
+		if body != nil && *body != "" {
+			printField(name, fmt.Sprintf("%d bytes (use --raw to see it)", len(*body)))
+		}
+	}
+
+	if message.MessageID != nil {
+		printField("Message ID", *message.MessageID)
+	}
+	if message.AccountID != nil {
+		printField("Account ID", *message.AccountID)
+	}
+	if message.SubAccountID != nil {
+		printField("Sub-Account ID", *message.SubAccountID)
+	}
+	if message.EmailType != nil {
+		printField("Email Type", *message.EmailType)
+	}
+	if message.SubmittedAt != nil {
+		printField("Submitted At", formatEpoch(*message.SubmittedAt))
+	}
+	if message.Attempt != nil {
+		printField("Delivery Attempts", *message.Attempt)
+	}
+
+	if message.From != nil {
+		printPerson("From", message.From.Email, message.From.Name)
+	}
+	if message.ReplyTo != nil {
+		printPerson("Reply-To", message.ReplyTo.Email, message.ReplyTo.Name)
+	}
+	if message.To != nil {
+		printPerson("To", message.To.Email, message.To.Name)
+		printList("  Cc", message.To.Cc)
+		printList("  Bcc", message.To.Bcc)
+		printMap("  Custom Fields", message.To.CustomFields)
+	}
+	if message.HeaderTo != nil {
+		printPerson("Header To", message.HeaderTo.Email, message.HeaderTo.Name)
+	}
+	printList("Header Cc", message.HeaderCc)
+	printList("Header Bcc", message.HeaderBcc)
+	if message.Subject != nil {
+		printField("Subject", *message.Subject)
+	}
+	if message.PreText != nil && *message.PreText != "" {
+		printField("Pre-Text", *message.PreText)
+	}
+	printBody("HTML Body", message.HtmlBody)
+	printBody("Text Body", message.TextBody)
+	printBody("AMP Body", message.AmpBody)
+	printList("Attachments", message.Attachments)
+
+	if message.IpID != nil {
+		printField("IP ID", *message.IpID)
+	}
+	if message.PublicIP != nil {
+		printField("Public IP", *message.PublicIP)
+	}
+	if message.LocalIP != nil {
+		printField("Local IP", *message.LocalIP)
+	}
+	if message.AccountIPPoolID != nil {
+		printField("IP Pool ID", *message.AccountIPPoolID)
+	}
+	if message.IpPool != nil && *message.IpPool != "" {
+		printField("IP Pool", *message.IpPool)
+	}
+	printList("MX Records", message.MxRecords)
+
+	printList("Groups", message.Groups)
+	if message.TrackOpens != nil {
+		printField("Track Opens", *message.TrackOpens)
+	}
+	if message.TrackClicks != nil {
+		printField("Track Clicks", *message.TrackClicks)
+	}
+	if message.WebhookEndpoint != nil && *message.WebhookEndpoint != "" {
+		printField("Webhook Endpoint", *message.WebhookEndpoint)
+	}
+	if key := message.Headers[idempotencyHeader]; key != "" {
+		printField("Idempotency Key", key)
+	}
+	printMap("Headers", message.Headers)
+	printMap("Custom Fields", message.CustomFields)
+}
+
+// ShowMessage prints a message with every field the message API returns and its delivery
+// timeline; raw prints the API response as JSON instead of the field list
+func (e *ESPExample) ShowMessage(id, eventsFile string, raw bool) error {
+	fmt.Println("\n=== Message Details ===")
+
+	message, resp, err := e.client.MessageAPI.GetMessageById(e.createAccountAuthContext(), id).Execute()
+	if err != nil {
+		fmt.Printf("✗ Failed to get message:\n")
+		if resp != nil {
+			fmt.Printf("  Status code: %d\n", resp.StatusCode)
+		}
+		fmt.Printf("  Error: %v\n", err)
+		// The timeline can still be built from the local sources
+		message = nil
+	} else if raw {
+		data, err := json.MarshalIndent(message, "  ", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("  %s\n", data)
+	} else {
+		printMessage(message)
+	}
+
+	timeline, err := e.MessageTimeline(message, id, eventsFile)
+	if err != nil {
+		return err
+	}
+	PrintTimeline(timeline)
+	return nil
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	sendpost "github.com/sendpost/sendpost-go-sdk"
+)
+
+// writeLines writes a JSONL file with one line per value; strings are written as they are
+func writeLines(t *testing.T, path string, values ...interface{}) {
+	t.Helper()
+	var lines []string
+	for _, value := range values {
+		if line, ok := value.(string); ok {
+			lines = append(lines, line)
+			continue
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, string(data))
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// messageIDs lists the message IDs of search results, or the recipient when there is none
+func messageIDs(records []MessageRecord) string {
+	ids := make([]string, len(records))
+	for i, record := range records {
+		ids[i] = record.MessageID
+		if ids[i] == "" {
+			ids[i] = record.To
+		}
+	}
+	return strings.Join(ids, " ")
+}
+
+// searchTestExample has a send log of three messages and an events file that updates
+// one of them and adds two sent elsewhere
+func searchTestExample(t *testing.T, handler http.Handler) (*ESPExample, string) {
+	t.Helper()
+	e := newTestExample(t, handler)
+	writeLines(t, e.sendLog.path,
+		MessageRecord{MessageID: "m1", SentAt: time.Date(2026, 10, 10, 10, 0, 0, 0, time.Local), From: "shop@example.com", To: "anna@example.com", Subject: "Spring offer", Status: messageStatusSent},
+		MessageRecord{MessageID: "m2", SentAt: time.Date(2026, 10, 15, 23, 30, 0, 0, time.Local), From: "shop@example.com", To: "bob@example.com", Subject: "Your order", Status: messageStatusSent},
+		MessageRecord{SentAt: time.Date(2026, 10, 5, 8, 0, 0, 0, time.Local), From: "shop@example.com", To: "carol@example.com", Subject: "Spring offer", Status: messageStatusError, Error: "error 406: suppressed"},
+		"not json",
+	)
+
+	events := filepath.Join(t.TempDir(), "events.jsonl")
+	submitted := time.Date(2026, 10, 12, 12, 0, 0, 0, time.Local).Unix()
+	writeLines(t, events,
+		`{"event":{"messageID":"m1","type":2,"subAccountID":12}}`,
+		`{"messageID":"m1","type":5}`,
+		fmt.Sprintf(`{"event":{"messageID":"m9","type":6,"from":"crm@example.com","to":"dave@example.com","messageSubject":"Newsletter","submittedAt":%d}}`, submitted),
+		`{"event":{"messageID":"m8","type":2,"from":"crm@example.com","to":"erin@example.com"}}`,
+		`{"event":{"type":2,"to":"nobody@example.com"}}`,
+	)
+	return e, events
+}
+
+func TestSearchMessagesJoinsEvents(t *testing.T) {
+	e, events := searchTestExample(t, jsonHandler(500, `{}`))
+
+	records, err := e.SearchMessages(MessageQuery{EventsFile: events})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Newest first; m8 has no send time and comes last
+	if got := messageIDs(records); got != "m2 m9 m1 carol@example.com m8" {
+		t.Fatalf("results = %s", got)
+	}
+	byID := map[string]MessageRecord{}
+	for _, record := range records {
+		byID[record.MessageID] = record
+	}
+	if m1 := byID["m1"]; m1.Status != "opened" || m1.SubAccountID != 12 || m1.Subject != "Spring offer" {
+		t.Errorf("m1 = %+v, want the last event's status and the sub-account from events", m1)
+	}
+	if m2 := byID["m2"]; m2.Status != messageStatusSent {
+		t.Errorf("m2 status = %s, want it unchanged without events", m2.Status)
+	}
+	m9 := byID["m9"]
+	if m9.Status != "clicked" || m9.From != "crm@example.com" || m9.To != "dave@example.com" || m9.Subject != "Newsletter" ||
+		!m9.SentAt.Equal(time.Date(2026, 10, 12, 12, 0, 0, 0, time.Local)) {
+		t.Errorf("m9 = %+v, want a record built from its event", m9)
+	}
+	if m8 := byID["m8"]; m8.Status != "delivered" || !m8.SentAt.IsZero() {
+		t.Errorf("m8 = %+v, want delivered with no send time", m8)
+	}
+
+	if _, err := e.SearchMessages(MessageQuery{EventsFile: filepath.Join(t.TempDir(), "missing.jsonl")}); err == nil {
+		t.Error("SearchMessages() with a missing events file succeeded")
+	}
+}
+
+func TestSearchMessagesFilters(t *testing.T) {
+	e, events := searchTestExample(t, jsonHandler(200, `{"subAccountID":7,"ipPool":"marketing"}`))
+	searchTime := func(value string, endOfDay bool) time.Time {
+		at, err := parseSearchTime(value, endOfDay)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return at
+	}
+
+	tests := []struct {
+		name  string
+		query MessageQuery
+		want  string
+	}{
+		{"recipient ignores case", MessageQuery{To: "ANNA"}, "m1"},
+		{"subject substring", MessageQuery{Subject: "spring"}, "m1 carol@example.com"},
+		{"status from last event", MessageQuery{Status: "Opened"}, "m1"},
+		{"rejected", MessageQuery{Status: messageStatusError}, "carol@example.com"},
+		{"since keeps unknown send times", MessageQuery{Since: searchTime("2026-10-11", false)}, "m2 m9 m8"},
+		{"until a date includes that day", MessageQuery{Until: searchTime("2026-10-15", true)}, "m2 m9 m1 carol@example.com m8"},
+		{"until the day before", MessageQuery{Until: searchTime("2026-10-14", true)}, "m9 m1 carol@example.com m8"},
+		{"until a time excludes it", MessageQuery{Until: searchTime("2026-10-15 23:30", true)}, "m9 m1 carol@example.com m8"},
+		{"status still applies without a send time", MessageQuery{Since: searchTime("2026-10-11", false), Status: "delivered"}, "m8"},
+		{"sub-account from events", MessageQuery{SubAccountID: 12}, "m1"},
+		{"unknown sub-account without fetch", MessageQuery{SubAccountID: 7}, ""},
+		{"sub-account from the message API", MessageQuery{SubAccountID: 7, Fetch: true}, "m2 m9 m8"},
+		{"ip pool from the message API", MessageQuery{IPPool: "Marketing", Fetch: true}, "m2 m9 m8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.query.EventsFile = events
+			var records []MessageRecord
+			var err error
+			captureStdout(t, func() { records, err = e.SearchMessages(tt.query) })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := messageIDs(records); got != tt.want {
+				t.Errorf("results = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSearchTime(t *testing.T) {
+	tests := []struct {
+		value    string
+		endOfDay bool
+		want     time.Time
+		wantErr  bool
+	}{
+		{"2026-10-15", false, time.Date(2026, 10, 15, 0, 0, 0, 0, time.Local), false},
+		{"2026-10-15", true, time.Date(2026, 10, 16, 0, 0, 0, 0, time.Local), false},
+		{"2026-12-31", true, time.Date(2027, 1, 1, 0, 0, 0, 0, time.Local), false},
+		{" 2026-10-15 09:30 ", true, time.Date(2026, 10, 15, 9, 30, 0, 0, time.Local), false},
+		{"2026-10-15T09:30:00Z", false, time.Date(2026, 10, 15, 9, 30, 0, 0, time.UTC), false},
+		{"+2h", false, time.Time{}, true},
+		{"yesterday", false, time.Time{}, true},
+		{"2026-13-01", false, time.Time{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSearchTime(tt.value, tt.endOfDay)
+		if (err != nil) != tt.wantErr || !got.Equal(tt.want) {
+			t.Errorf("parseSearchTime(%q, %v) = %s, %v; want %s", tt.value, tt.endOfDay, got, err, tt.want)
+		}
+	}
+}
+
+func TestPrintMessagesPages(t *testing.T) {
+	var records []MessageRecord
+	for i := 0; i < 5; i++ {
+		records = append(records, MessageRecord{MessageID: fmt.Sprintf("m%d", i), To: fmt.Sprintf("r%d@example.com", i), Status: messageStatusSent})
+	}
+
+	tests := []struct {
+		name    string
+		records []MessageRecord
+		page    int
+		limit   int
+		want    []string
+		notWant []string
+	}{
+		{"first page", records, 1, 2, []string{"r0@", "r1@", "Page 1 of 3 (5 message(s))", "Next page: --page 2"}, []string{"r2@"}},
+		{"last page", records, 3, 2, []string{"r4@", "Page 3 of 3"}, []string{"r3@", "Next page"}},
+		{"page after the last", records, 4, 2, []string{"Page 4 is out of range: 3 page(s) of 5 message(s)"}, []string{"r0@"}},
+		{"page zero", records, 0, 2, []string{"Page 0 is out of range"}, []string{"r0@"}},
+		{"default limit", records, 1, 0, []string{"r4@", "Page 1 of 1"}, []string{"Next page"}},
+		{"no results", nil, 2, 2, []string{"No messages found"}, []string{"out of range"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := captureStdout(t, func() { PrintMessages(tt.records, tt.page, tt.limit) })
+			for _, want := range tt.want {
+				if !strings.Contains(output, want) {
+					t.Errorf("output lacks %q:\n%s", want, output)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(output, notWant) {
+					t.Errorf("output contains %q:\n%s", notWant, output)
+				}
+			}
+		})
+	}
+}
+
+func TestMessageTimeline(t *testing.T) {
+	e := newTestExample(t, jsonHandler(500, `{}`))
+	submitted := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	writeLines(t, e.sendLog.path,
+		MessageRecord{MessageID: "m1", SentAt: submitted.Add(time.Second), To: "anna@example.com", Status: messageStatusSent},
+		MessageRecord{MessageID: "other", SentAt: submitted, To: "bob@example.com", Status: messageStatusSent},
+	)
+	at := func(d time.Duration) int64 { return submitted.Add(d).Unix() }
+	events := filepath.Join(t.TempDir(), "events.jsonl")
+	writeLines(t, events,
+		`{"event":{"messageID":"m1","type":6}}`,
+		fmt.Sprintf(`{"event":{"messageID":"m1","type":2,"submittedAt":%d}}`, at(5*time.Second)),
+		`{"event":{"messageID":"m1","type":5}}`,
+		fmt.Sprintf(`{"event":{"messageID":"other","type":4,"submittedAt":%d}}`, at(2*time.Second)),
+		fmt.Sprintf(`{"event":{"messageID":"m1","type":0,"submittedAt":%d}}`, at(2*time.Second)),
+	)
+	message := &sendpost.Message{}
+	message.SetSubmittedAt(submitted.UnixNano())
+
+	// Entries without a time take the time of the entry before them in source order (message
+	// API, send log, events file), so the click listed first in the file sorts before the
+	// delivery and the open stays after it
+	tests := []struct {
+		name    string
+		message *sendpost.Message
+		events  string
+		want    []string
+	}{
+		{"all sources", message, events, []string{
+			"09:00:00 submitted message API",
+			"09:00:01 sent send log",
+			"09:00:01 clicked webhook",
+			"09:00:02 processed webhook",
+			"09:00:05 delivered webhook",
+			"09:00:05 opened webhook",
+		}},
+		{"without the message API", nil, events, []string{
+			"09:00:01 sent send log",
+			"09:00:01 clicked webhook",
+			"09:00:02 processed webhook",
+			"09:00:05 delivered webhook",
+			"09:00:05 opened webhook",
+		}},
+		{"without events", message, "", []string{
+			"09:00:00 submitted message API",
+			"09:00:01 sent send log",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timeline, err := e.MessageTimeline(tt.message, "m1", tt.events)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, entry := range timeline {
+				got = append(got, entry.At.UTC().Format("15:04:05")+" "+entry.Status+" "+entry.Source)
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("timeline =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+
+	// With nothing before it, an event without a time has none and sorts first
+	e = newTestExample(t, jsonHandler(500, `{}`))
+	lone := filepath.Join(t.TempDir(), "lone.jsonl")
+	writeLines(t, lone,
+		`{"event":{"messageID":"m1","type":5}}`,
+		fmt.Sprintf(`{"event":{"messageID":"m1","type":2,"submittedAt":%d}}`, at(0)),
+	)
+	timeline, err := e.MessageTimeline(nil, "m1", lone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(timeline) != 2 || !timeline[0].At.IsZero() || timeline[0].Status != "opened" || timeline[1].Status != "delivered" {
+		t.Errorf("timeline = %+v, want the open without a time first", timeline)
+	}
+}
+
+func TestEventDetails(t *testing.T) {
+	tests := []struct {
+		name  string
+		event string
+		want  []string
+	}{
+		{"delivery", `{"type":2,"smtpCode":250,"smtpDescription":"2.0.0 OK"}`, []string{"MTA response: 250 2.0.0 OK"}},
+		{"bounce from metadata", `{"type":4,"eventMetadata":{"smtpCode":550,"smtpDescription":"5.1.1 no such user"}}`, []string{"Reason: 550 5.1.1 no such user"}},
+		{"event fields win over metadata", `{"type":3,"smtpCode":421,"eventMetadata":{"smtpCode":450,"smtpDescription":"try later"}}`, []string{"Reason: 421 try later"}},
+		{"drop with description only", `{"type":1,"smtpDescription":"recipient suppressed"}`, []string{"Reason: recipient suppressed"}},
+		{"bounce without reason", `{"type":3}`, []string{"Reason: not reported"}},
+		{"drop without reason", `{"type":1}`, nil},
+		{"click", `{"type":6,"eventMetadata":{"clickedURL":"https://example.com/offer","device":{"Family":"iPhone"},"os":{"Family":"iOS","Major":"17"},"geo":{"countryCode":"DE"},"trackedIP":"203.0.113.7"}}`,
+			[]string{"URL: https://example.com/offer", "Client: iPhone, iOS 17, DE, 203.0.113.7"}},
+		{"open with partial client", `{"type":5,"eventMetadata":{"os":{"Family":"Windows"}}}`, []string{"Client: Windows"}},
+		{"open without metadata", `{"type":5}`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := sendpost.NewEvent()
+			if err := json.Unmarshal([]byte(tt.event), event); err != nil {
+				t.Fatal(err)
+			}
+			if got := eventDetails(event); strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("eventDetails() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// transportHeaders describe the MIME structure; they are consumed while parsing and
+// never passed on as custom headers
+var transportHeaders = map[string]bool{
+	"content-transfer-encoding": true,
+	"content-disposition":       true,
+	"content-id":                true,
+}
+
+// overriddenHeaders are set by SendPost itself or describe an earlier delivery; they are
+// dropped with a warning
+var overriddenHeaders = map[string]string{
+	"date":                       "Date is set by SendPost when the message is sent",
+	"message-id":                 "Message-ID %s is replaced by the one SendPost assigns",
+	"dkim-signature":             "DKIM-Signature is dropped; SendPost signs the message for your sending domain",
+	"received":                   "Received headers are dropped; they describe an earlier delivery",
+	"return-path":                "Return-Path %s is replaced by SendPost's bounce address",
+	"delivered-to":               "Delivered-To is dropped; it describes an earlier delivery",
+	"authentication-results":     "Authentication-Results is dropped; it describes an earlier delivery",
+	"arc-seal":                   "ARC headers are dropped; they describe an earlier delivery",
+	"arc-message-signature":      "ARC headers are dropped; they describe an earlier delivery",
+	"arc-authentication-results": "ARC headers are dropped; they describe an earlier delivery",
+}
+
+// windows1252 maps the bytes 0x80-0x9F of Windows-1252 to Unicode; the rest of the
+// code page matches ISO-8859-1
+var windows1252 = [32]rune{
+	'€', '\u0081', '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', '\u008d', 'Ž', '\u008f',
+	'\u0090', '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', '\u009d', 'ž', 'Ÿ',
+}
+
+// ParsedEmail is an RFC 5322 / MIME message mapped onto the fields of a SendPost send request
+type ParsedEmail struct {
+	From        *mail.Address
+	ReplyTo     *mail.Address
+	To          []*mail.Address
+	Cc          []*mail.Address
+	Bcc         []*mail.Address
+	Subject     string
+	HTML        string
+	Text        string
+	AMP         string
+	Headers     map[string]string
+	Attachments *Attachments
+	// Warnings lists what could not be carried over as it was, such as headers SendPost sets
+	Warnings []string
+}
+
+// ParseMIME reads a MIME message
+// The first text/plain and text/html parts that are not attachments become the bodies.
+// Other parts become attachments; parts with a Content-ID inside multipart/related or
+// marked inline become inline images referenced from the HTML as cid:<Content-ID>.
+// Bodies are converted to UTF-8 from US-ASCII, ISO-8859-1 or Windows-1252, with LF line
+// endings.
+func ParseMIME(r io.Reader) (*ParsedEmail, error) {
+	message, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse message: %w", err)
+	}
+
+	parsed := &ParsedEmail{Headers: map[string]string{}, Attachments: NewAttachments()}
+	header := message.Header
+	decoder := new(mime.WordDecoder)
+
+	if value := header.Get("From"); value != "" {
+		if parsed.From, err = mail.ParseAddress(value); err != nil {
+			return nil, fmt.Errorf("invalid From header: %w", err)
+		}
+	}
+	if value := header.Get("Reply-To"); value != "" {
+		if parsed.ReplyTo, err = mail.ParseAddress(value); err != nil {
+			return nil, fmt.Errorf("invalid Reply-To header: %w", err)
+		}
+	}
+	for name, list := range map[string]*[]*mail.Address{"To": &parsed.To, "Cc": &parsed.Cc, "Bcc": &parsed.Bcc} {
+		if value := header.Get(name); value != "" {
+			if *list, err = header.AddressList(name); err != nil {
+				return nil, fmt.Errorf("invalid %s header: %w", name, err)
+			}
+		}
+	}
+	if parsed.Subject, err = decoder.DecodeHeader(header.Get("Subject")); err != nil {
+		parsed.Subject = header.Get("Subject")
+	}
+
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	warned := map[string]bool{}
+	for _, name := range names {
+		values := header[name]
+		lower := strings.ToLower(name)
+		if warning, ok := overriddenHeaders[lower]; ok {
+			if strings.Contains(warning, "%s") {
+				warning = fmt.Sprintf(warning, values[0])
+			}
+			if !warned[warning] {
+				warned[warning] = true
+				parsed.Warnings = append(parsed.Warnings, warning)
+			}
+			continue
+		}
+		if _, reserved := reservedHeaders[lower]; reserved || transportHeaders[lower] || strings.HasPrefix(lower, "content-") {
+			continue
+		}
+		value, err := decoder.DecodeHeader(values[0])
+		if err != nil {
+			value = values[0]
+		}
+		if len(values) > 1 {
+			parsed.Warnings = append(parsed.Warnings, fmt.Sprintf("%s appears %d times; only the first value is sent", name, len(values)))
+		}
+		parsed.Headers[name] = value
+	}
+
+	var inlineIDs []string
+	if err := parsed.walk(header, message.Body, false, &inlineIDs); err != nil {
+		return nil, err
+	}
+
+	// Content-IDs may have gained an extension when attached; keep the HTML references in step
+	for i := 0; i+1 < len(inlineIDs); i += 2 {
+		if inlineIDs[i] != inlineIDs[i+1] {
+			parsed.HTML = strings.ReplaceAll(parsed.HTML, "cid:"+inlineIDs[i], "cid:"+inlineIDs[i+1])
+		}
+	}
+	return parsed, nil
+}
+
+// partHeader is the subset of header access shared by mail.Header and textproto.MIMEHeader
+type partHeader interface {
+	Get(key string) string
+}
+
+// walk maps one MIME part, recursing into multiparts; inlineIDs collects pairs of
+// original and attached Content-IDs
+func (p *ParsedEmail) walk(header partHeader, body io.Reader, related bool, inlineIDs *[]string) error {
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "text/plain; charset=us-ascii"
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, params = "application/octet-stream", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("could not read MIME part: %w", err)
+			}
+			if err := p.walk(part.Header, part, related || mediaType == "multipart/related", inlineIDs); err != nil {
+				return err
+			}
+		}
+	}
+
+	content, err := decodeTransfer(header.Get("Content-Transfer-Encoding"), body)
+	if err != nil {
+		return err
+	}
+
+	disposition, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	filename := dispositionParams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+	if filename != "" {
+		if decoded, err := new(mime.WordDecoder).DecodeHeader(filename); err == nil {
+			filename = decoded
+		}
+	}
+
+	// Bodies: text parts that are not attachments, first one of each kind wins
+	if disposition != "attachment" && filename == "" {
+		// MIME text has CRLF line endings; bodies keep the plain newlines they were built with
+		text := bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n"))
+		switch {
+		case mediaType == "text/plain" && p.Text == "":
+			p.Text, err = toUTF8(text, params["charset"])
+			return err
+		case mediaType == "text/html" && p.HTML == "":
+			p.HTML, err = toUTF8(text, params["charset"])
+			return err
+		case mediaType == "text/x-amp-html" && p.AMP == "":
+			p.AMP, err = toUTF8(text, params["charset"])
+			return err
+		}
+	}
+
+	contentID := strings.Trim(header.Get("Content-ID"), "<> ")
+	if contentID != "" && (related || disposition == "inline") && strings.HasPrefix(mediaType, "image/") {
+		attached, err := p.Attachments.AddInlineBytes(contentID, content)
+		if err != nil {
+			return err
+		}
+		*inlineIDs = append(*inlineIDs, contentID, attached)
+		return nil
+	}
+
+	if filename == "" {
+		filename = "attachment"
+		if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+			filename += exts[0]
+		}
+		if strings.HasPrefix(mediaType, "text/") {
+			p.Warnings = append(p.Warnings, fmt.Sprintf("an additional %s body part is sent as the attachment %s", mediaType, filename))
+		}
+	}
+	return p.Attachments.AddBytes(filename, content)
+}
+
+// decodeTransfer undoes a Content-Transfer-Encoding; multipart.Reader already decodes
+// quoted-printable parts and removes their header
+func decodeTransfer(encoding string, body io.Reader) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		content, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, body))
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 content: %w", err)
+		}
+		return content, nil
+	case "quoted-printable":
+		content, err := io.ReadAll(quotedprintable.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("invalid quoted-printable content: %w", err)
+		}
+		return content, nil
+	default:
+		return io.ReadAll(body)
+	}
+}
+
+// toUTF8 converts a text body from its declared charset
+func toUTF8(content []byte, charset string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(charset)) {
+	case "", "utf-8", "utf8", "us-ascii", "ascii":
+		if !utf8.Valid(content) {
+			return string(bytes.ToValidUTF8(content, []byte("�"))), nil
+		}
+		return string(content), nil
+	case "iso-8859-1", "latin1", "windows-1252", "cp1252":
+		runes := make([]rune, 0, len(content))
+		for _, b := range content {
+			if b >= 0x80 && b <= 0x9f {
+				runes = append(runes, windows1252[b-0x80])
+			} else {
+				runes = append(runes, rune(b))
+			}
+		}
+		return string(runes), nil
+	default:
+		return "", fmt.Errorf("unsupported charset %q; re-encode the message as UTF-8", charset)
+	}
+}
+
+// Builder maps the message onto an EmailBuilder
+// With envelope recipients (SMTP RCPT TO) those decide who receives the message: addresses
+// in the To and Cc headers keep their role and display name and the rest are sent as Bcc.
+// Without them the To, Cc and Bcc headers are used. SendPost sends a copy per To recipient,
+// so copies are attached to the first To recipient only.
+func (p *ParsedEmail) Builder(envelope []string) *EmailBuilder {
+	builder := NewEmailBuilder().Subject(p.Subject)
+	if p.From != nil {
+		builder.From(p.From.Address, p.From.Name)
+	}
+	if p.ReplyTo != nil {
+		builder.ReplyTo(p.ReplyTo.Address, p.ReplyTo.Name)
+	}
+	if p.HTML != "" {
+		builder.HTML(p.HTML)
+	}
+	if p.Text != "" {
+		builder.Text(p.Text)
+	}
+	if p.AMP != "" {
+		builder.AMP(p.AMP)
+	}
+	for name, value := range p.Headers {
+		builder.Header(name, value)
+	}
+	builder.Attach(p.Attachments)
+
+	to, cc, bcc := p.To, p.Cc, p.Bcc
+	if envelope != nil {
+		remaining := make(map[string]bool, len(envelope))
+		for _, address := range envelope {
+			remaining[strings.ToLower(address)] = true
+		}
+		keep := func(list []*mail.Address) []*mail.Address {
+			var kept []*mail.Address
+			for _, address := range list {
+				if remaining[strings.ToLower(address.Address)] {
+					delete(remaining, strings.ToLower(address.Address))
+					kept = append(kept, address)
+				}
+			}
+			return kept
+		}
+		to, cc, bcc = keep(p.To), keep(p.Cc), nil
+		for _, address := range envelope {
+			if remaining[strings.ToLower(address)] {
+				delete(remaining, strings.ToLower(address))
+				bcc = append(bcc, &mail.Address{Address: address})
+			}
+		}
+		if len(to) == 0 && len(bcc) > 0 {
+			to, bcc = bcc[:1], bcc[1:]
+		} else if len(to) == 0 && len(cc) > 0 {
+			to, cc = cc[:1], cc[1:]
+		}
+	}
+
+	for i, address := range to {
+		builder.To(address.Address, address.Name)
+		if i > 0 {
+			continue
+		}
+		for _, copyTo := range cc {
+			builder.Cc(copyTo.Address, copyTo.Name)
+		}
+		for _, copyTo := range bcc {
+			builder.Bcc(copyTo.Address, copyTo.Name)
+		}
+	}
+	return builder
+}
+
+// SendEML sends a prebuilt RFC 5322 message file
+// recipients replace the To, Cc and Bcc headers when given; the message is otherwise sent
+// to the addresses in those headers.
+func (e *ESPExample) SendEML(path string, recipients []string) error {
+	fmt.Println("\n=== Sending EML ===")
+
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Printf("✗ Failed to read message:\n")
+		fmt.Printf("  Error: %v\n", err)
+		return errNotSent
+	}
+	parsed, err := ParseMIME(file)
+	file.Close()
+	if err != nil {
+		fmt.Printf("✗ Failed to parse message:\n")
+		fmt.Printf("  Error: %v\n", err)
+		return errNotSent
+	}
+	for _, warning := range parsed.Warnings {
+		fmt.Printf("⚠️  %s\n", warning)
+	}
+	if parsed.From == nil {
+		fmt.Printf("✗ Failed to build email:\n")
+		fmt.Printf("  Error: %s has no From header\n", path)
+		return errNotSent
+	}
+	if err := parsed.Attachments.Merge(e.attachments); err != nil {
+		fmt.Printf("✗ Failed to attach files:\n")
+		fmt.Printf("  Error: %v\n", err)
+		return errNotSent
+	}
+
+	if !e.validateSender(parsed.From.Address, "the From header of "+path) {
+		return errNotSent
+	}
+
+	builder := parsed.Builder(recipients).IPPool(e.createdIPPoolName)
+	if e.idempotencyKey != "" {
+		builder.IdempotencyKey(e.idempotencyKey)
+	}
+	emailMessage, ok := e.buildEmail(builder)
+	if !ok {
+		return errNotSent
+	}
+	if e.previewEmail(emailMessage) {
+		return nil
+	}
+
+	if !e.sendAt.IsZero() {
+		if !e.scheduleEmail(emailMessage, e.sendAt) {
+			return errNotSent
+		}
+		return nil
+	}
+
+	fmt.Println("Sending email...")
+	fmt.Printf("  From: %s\n", parsed.From.Address)
+	for _, recipient := range emailMessage.GetTo() {
+		fmt.Printf("  To: %s\n", recipient.GetEmail())
+	}
+	fmt.Printf("  Subject: %s\n", emailMessage.GetSubject())
+
+	responses, resp, cached, err := e.sendEmail(e.createSubAccountAuthContext(), emailMessage)
+	if err != nil {
+		fmt.Printf("✗ Failed to send email:\n")
+		if resp != nil {
+			fmt.Printf("  Status code: %d\n", resp.StatusCode)
+		}
+		fmt.Printf("  Error: %v\n", err)
+		return errNotSent
+	}
+	if cached {
+		fmt.Printf("  Already sent with idempotency key %s; not sending again\n", emailMessage.GetHeaders()[idempotencyHeader])
+	}
+
+	fmt.Println("✓ Email sent successfully!")
+	failed := 0
+	for _, response := range responses {
+		if response.GetErrorCode() != 0 {
+			fmt.Printf("  ✗ %s: error %d: %s\n", response.GetTo(), response.GetErrorCode(), response.GetMessage())
+			failed++
+			continue
+		}
+		fmt.Printf("  Message ID: %s (%s)\n", response.GetMessageId(), response.GetTo())
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d recipient(s) were rejected", failed, len(responses))
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	sendpost "github.com/sendpost/sendpost-go-sdk"
+)
+
+// testPNG is a minimal PNG header, enough for content type detection
+var testPNG = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01\x08\x02\x00\x00\x00")
+
+func TestEMLRoundTrip(t *testing.T) {
+	attachments := NewAttachments()
+	if err := attachments.AddBytes("invoice.pdf", []byte("%PDF-1.4 invoice")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := attachments.AddInlineBytes("logo.png", testPNG); err != nil {
+		t.Fatal(err)
+	}
+	original, err := NewEmailBuilder().
+		From("sender@example.com", "Café Müller").
+		ReplyTo("support@example.com", "Support").
+		To("anna@example.com", "Anna Schmidt").
+		Cc("copy@example.com", "Copy").
+		Subject("Ihre Bestellung – 20% Rabatt").
+		Text("Hallo Anna,\ndanke für Ihre Bestellung.").
+		HTML(`<p>Hallo Anna, danke für Ihre Bestellung.</p><img src="cid:logo.png">`).
+		Header("X-Order-ID", "12345").
+		Attach(attachments).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var eml bytes.Buffer
+	if err := RenderEML(&eml, original, original.GetTo()[0], time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseMIME(&eml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The preview's Date header is the only one SendPost would set itself
+	if len(parsed.Warnings) != 1 || !strings.HasPrefix(parsed.Warnings[0], "Date") {
+		t.Errorf("warnings = %v", parsed.Warnings)
+	}
+	rebuilt, err := parsed.Builder(nil).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	from, replyTo := rebuilt.GetFrom(), rebuilt.GetReplyTo()
+	checks := []struct {
+		field     string
+		got, want string
+	}{
+		{"subject", rebuilt.GetSubject(), original.GetSubject()},
+		{"from", formatAddress(from.GetEmail(), from.GetName()), formatAddress("sender@example.com", "Café Müller")},
+		{"reply-to", replyTo.GetEmail(), "support@example.com"},
+		{"to", rebuilt.GetTo()[0].GetEmail() + " " + rebuilt.GetTo()[0].GetName(), "anna@example.com Anna Schmidt"},
+		{"cc", rebuilt.GetTo()[0].GetCc()[0].GetEmail(), "copy@example.com"},
+		{"text", rebuilt.GetTextBody(), original.GetTextBody()},
+		{"html", rebuilt.GetHtmlBody(), original.GetHtmlBody()},
+		{"header", headerValue(rebuilt, "X-Order-ID"), "12345"},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s = %q, want %q", c.field, c.got, c.want)
+		}
+	}
+
+	contents := func(message *sendpost.EmailMessageObject) map[string]string {
+		files := map[string]string{}
+		for _, attachment := range message.GetAttachments() {
+			files[attachment.GetFilename()] = attachment.GetContent()
+		}
+		return files
+	}
+	want, got := contents(original), contents(rebuilt)
+	if len(got) != len(want) {
+		t.Errorf("attachments = %v, want %v", got, want)
+	}
+	for name, content := range want {
+		if got[name] != content {
+			t.Errorf("attachment %s did not survive the round trip", name)
+		}
+	}
+}
+
+func TestParseMIMEBodies(t *testing.T) {
+	tests := []struct {
+		name        string
+		message     string
+		wantSubject string
+		wantText    string
+		wantErr     string
+	}{
+		{
+			"plain ascii",
+			"From: a@example.com\r\nSubject: Hi\r\n\r\nHello",
+			"Hi", "Hello", "",
+		},
+		{
+			"encoded subject, latin1 quoted-printable",
+			"From: a@example.com\r\nSubject: =?utf-8?q?Gr=C3=BC=C3=9Fe?=\r\nContent-Type: text/plain; charset=iso-8859-1\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nGr=FC=DFe",
+			"Grüße", "Grüße", "",
+		},
+		{
+			"windows-1252 base64",
+			"From: a@example.com\r\nSubject: Quote\r\nContent-Type: text/plain; charset=windows-1252\r\nContent-Transfer-Encoding: base64\r\n\r\nk3F1b3RllA==",
+			"Quote", "“quote”", "",
+		},
+		{
+			"unsupported charset",
+			"From: a@example.com\r\nSubject: Hi\r\nContent-Type: text/plain; charset=koi8-r\r\n\r\nHello",
+			"", "", "unsupported charset",
+		},
+		{
+			"invalid from",
+			"From: not an address\r\nSubject: Hi\r\n\r\nHello",
+			"", "", "invalid From header",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := ParseMIME(strings.NewReader(tt.message))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("ParseMIME() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parsed.Subject != tt.wantSubject || parsed.Text != tt.wantText {
+				t.Errorf("subject, text = %q, %q; want %q, %q", parsed.Subject, parsed.Text, tt.wantSubject, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestParsedEmailEnvelope(t *testing.T) {
+	message := "From: a@example.com\r\nTo: To One <one@example.com>, two@example.com\r\nCc: Copy <copy@example.com>\r\nSubject: Hi\r\n\r\nHello"
+	parsed, err := ParseMIME(strings.NewReader(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		envelope []string
+		wantTo   []string
+		wantCc   []string
+		wantBcc  []string
+	}{
+		{"headers", nil, []string{"one@example.com", "two@example.com"}, []string{"copy@example.com"}, nil},
+		{"envelope keeps header roles", []string{"ONE@example.com", "copy@example.com", "hidden@example.com"}, []string{"one@example.com"}, []string{"copy@example.com"}, []string{"hidden@example.com"}},
+		{"bcc only", []string{"hidden@example.com", "other@example.com"}, []string{"hidden@example.com"}, nil, []string{"other@example.com"}},
+		{"cc only", []string{"copy@example.com"}, []string{"copy@example.com"}, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			built, err := parsed.Builder(tt.envelope).Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var to, cc, bcc []string
+			for _, recipient := range built.GetTo() {
+				to = append(to, recipient.GetEmail())
+				for _, copyTo := range recipient.GetCc() {
+					cc = append(cc, copyTo.GetEmail())
+				}
+				for _, copyTo := range recipient.GetBcc() {
+					bcc = append(bcc, copyTo.GetEmail())
+				}
+			}
+			if strings.Join(to, ",") != strings.Join(tt.wantTo, ",") || strings.Join(cc, ",") != strings.Join(tt.wantCc, ",") || strings.Join(bcc, ",") != strings.Join(tt.wantBcc, ",") {
+				t.Errorf("to %v, cc %v, bcc %v; want %v, %v, %v", to, cc, bcc, tt.wantTo, tt.wantCc, tt.wantBcc)
+			}
+		})
+	}
+}
+
+func TestSendEMLReturnsFailures(t *testing.T) {
+	e := newTestExample(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/domain") {
+			jsonHandler(200, `[{"id":1,"name":"example.com","verified":true}]`).ServeHTTP(w, r)
+			return
+		}
+		jsonHandler(200, `[{"to":"one@example.com","messageId":"m1"},{"to":"two@example.com","errorCode":422,"message":"suppressed"}]`).ServeHTTP(w, r)
+	}))
+	path := filepath.Join(t.TempDir(), "message.eml")
+	message := "From: sender@example.com\r\nTo: one@example.com, two@example.com\r\nSubject: Hi\r\n\r\nHello"
+	if err := os.WriteFile(path, []byte(message), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var err error
+	captureStdout(t, func() { err = e.SendEML(filepath.Join(t.TempDir(), "missing.eml"), nil) })
+	if !errors.Is(err, errNotSent) {
+		t.Errorf("SendEML(missing file) error = %v, want %v", err, errNotSent)
+	}
+
+	captureStdout(t, func() { err = e.SendEML(path, nil) })
+	if err == nil || !strings.Contains(err.Error(), "1 of 2 recipient(s)") {
+		t.Errorf("SendEML() error = %v, want the rejected recipient counted", err)
+	}
+
+	e.dryRun = true
+	captureStdout(t, func() { err = e.SendEML(path, nil) })
+	if err != nil {
+		t.Errorf("SendEML() in a dry run error = %v", err)
+	}
+}
+
+// headerValue looks up a custom header case-insensitively; parsing canonicalizes names
+func headerValue(message *sendpost.EmailMessageObject, name string) string {
+	for key, value := range message.GetHeaders() {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
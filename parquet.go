@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+// parquetKind is the type of a Parquet column written by writeParquet
+type parquetKind int
+
+const (
+	parquetInt64  parquetKind = iota // INT64
+	parquetString                    // BYTE_ARRAY annotated as UTF-8 string
+	parquetDate                      // INT32 annotated as DATE (days since 1970-01-01)
+)
+
+// parquetColumn holds the values of one required column
+// Integer and date columns use ints, string columns use strings.
+type parquetColumn struct {
+	name    string
+	kind    parquetKind
+	ints    []int64
+	strings []string
+}
+
+// Parquet format constants (parquet.thrift)
+const (
+	parquetMagic            = "PAR1"
+	parquetTypeInt32        = 1
+	parquetTypeInt64        = 2
+	parquetTypeByteArray    = 6
+	parquetRequired         = 0
+	parquetConvertedUTF8    = 0
+	parquetConvertedDate    = 6
+	parquetLogicalString    = 1
+	parquetLogicalDate      = 6
+	parquetEncodingPlain    = 0
+	parquetEncodingRLE      = 3
+	parquetCodecNone        = 0
+	parquetPageData         = 0
+	parquetCreatedBy        = "sendpost example-sdk-go"
+	parquetFileMetaVersion  = 1
+	thriftCompactI32        = 5
+	thriftCompactI64        = 6
+	thriftCompactBinary     = 8
+	thriftCompactList       = 9
+	thriftCompactStruct     = 12
+	thriftCompactMaxShortID = 15
+)
+
+// physicalType returns the Parquet physical type of a column
+func (c parquetColumn) physicalType() int32 {
+	switch c.kind {
+	case parquetString:
+		return parquetTypeByteArray
+	case parquetDate:
+		return parquetTypeInt32
+	default:
+		return parquetTypeInt64
+	}
+}
+
+// count returns the number of values in a column
+func (c parquetColumn) count() int {
+	if c.kind == parquetString {
+		return len(c.strings)
+	}
+	return len(c.ints)
+}
+
+// plainValues encodes a column's values with the PLAIN encoding
+func (c parquetColumn) plainValues() []byte {
+	var buf bytes.Buffer
+	var scratch [8]byte
+	switch c.kind {
+	case parquetString:
+		for _, value := range c.strings {
+			binary.LittleEndian.PutUint32(scratch[:4], uint32(len(value)))
+			buf.Write(scratch[:4])
+			buf.WriteString(value)
+		}
+	case parquetDate:
+		for _, value := range c.ints {
+			binary.LittleEndian.PutUint32(scratch[:4], uint32(int32(value)))
+			buf.Write(scratch[:4])
+		}
+	default:
+		for _, value := range c.ints {
+			binary.LittleEndian.PutUint64(scratch[:], uint64(value))
+			buf.Write(scratch[:])
+		}
+	}
+	return buf.Bytes()
+}
+
+// writeParquet writes columns of equal length as a Parquet file with one row group
+//
+// Every column is required, PLAIN encoded and uncompressed in a single data page. That
+// keeps the writer small enough to need no dependency, and any Parquet reader (Spark,
+// DuckDB, BigQuery, pyarrow) loads the result.
+func writeParquet(path string, columns []parquetColumn) error {
+	rows := 0
+	if len(columns) > 0 {
+		rows = columns[0].count()
+	}
+	for _, column := range columns {
+		if column.count() != rows {
+			return fmt.Errorf("parquet column %s has %d values, expected %d", column.name, column.count(), rows)
+		}
+	}
+
+	var file bytes.Buffer
+	file.WriteString(parquetMagic)
+
+	type chunk struct {
+		offset int64
+		size   int64
+	}
+	chunks := make([]chunk, len(columns))
+	for i, column := range columns {
+		data := column.plainValues()
+		header := &thriftWriter{}
+		header.i32(1, parquetPageData)
+		header.i32(2, int32(len(data)))
+		header.i32(3, int32(len(data)))
+		header.beginStruct(5)
+		header.i32(1, int32(rows))
+		header.i32(2, parquetEncodingPlain)
+		header.i32(3, parquetEncodingRLE)
+		header.i32(4, parquetEncodingRLE)
+		header.endStruct()
+		header.stop()
+
+		chunks[i] = chunk{offset: int64(file.Len()), size: int64(header.buf.Len() + len(data))}
+		file.Write(header.buf.Bytes())
+		file.Write(data)
+	}
+
+	// FileMetaData
+	meta := &thriftWriter{}
+	meta.i32(1, parquetFileMetaVersion)
+	meta.beginList(2, thriftCompactStruct, len(columns)+1)
+	meta.beginElement()
+	meta.binary(4, "schema")
+	meta.i32(5, int32(len(columns)))
+	meta.endElement()
+	for _, column := range columns {
+		meta.beginElement()
+		meta.i32(1, column.physicalType())
+		meta.i32(3, parquetRequired)
+		meta.binary(4, column.name)
+		switch column.kind {
+		case parquetString:
+			meta.i32(6, parquetConvertedUTF8)
+			meta.beginStruct(10)
+			meta.emptyStruct(parquetLogicalString)
+			meta.endStruct()
+		case parquetDate:
+			meta.i32(6, parquetConvertedDate)
+			meta.beginStruct(10)
+			meta.emptyStruct(parquetLogicalDate)
+			meta.endStruct()
+		}
+		meta.endElement()
+	}
+	meta.i64(3, int64(rows))
+
+	var totalSize int64
+	for _, chunk := range chunks {
+		totalSize += chunk.size
+	}
+	meta.beginList(4, thriftCompactStruct, 1)
+	meta.beginElement()
+	meta.beginList(1, thriftCompactStruct, len(columns))
+	for i, column := range columns {
+		meta.beginElement()
+		meta.i64(2, chunks[i].offset)
+		meta.beginStruct(3)
+		meta.i32(1, column.physicalType())
+		meta.beginList(2, thriftCompactI32, 1)
+		meta.varint(zigzag(parquetEncodingPlain))
+		meta.beginList(3, thriftCompactBinary, 1)
+		meta.rawBinary(column.name)
+		meta.i32(4, parquetCodecNone)
+		meta.i64(5, int64(rows))
+		meta.i64(6, chunks[i].size)
+		meta.i64(7, chunks[i].size)
+		meta.i64(9, chunks[i].offset)
+		meta.endStruct()
+		meta.endElement()
+	}
+	meta.i64(2, totalSize)
+	meta.i64(3, int64(rows))
+	meta.endElement()
+	meta.binary(6, parquetCreatedBy)
+	meta.stop()
+
+	file.Write(meta.buf.Bytes())
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(meta.buf.Len()))
+	file.Write(length[:])
+	file.WriteString(parquetMagic)
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, file.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// thriftWriter encodes structs with the Thrift compact protocol, which Parquet uses for
+// page headers and the file footer
+type thriftWriter struct {
+	buf    bytes.Buffer
+	lastID []int16
+	last   int16
+}
+
+// field writes a field header
+func (w *thriftWriter) field(id int16, typ byte) {
+	if delta := id - w.last; delta > 0 && delta <= thriftCompactMaxShortID {
+		w.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		w.buf.WriteByte(typ)
+		w.varint(zigzag(int64(id)))
+	}
+	w.last = id
+}
+
+// varint writes an unsigned LEB128 value
+func (w *thriftWriter) varint(v uint64) {
+	var scratch [binary.MaxVarintLen64]byte
+	w.buf.Write(scratch[:binary.PutUvarint(scratch[:], v)])
+}
+
+// zigzag maps signed integers to unsigned ones so small magnitudes stay short
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func (w *thriftWriter) i32(id int16, v int32) {
+	w.field(id, thriftCompactI32)
+	w.varint(zigzag(int64(v)))
+}
+
+func (w *thriftWriter) i64(id int16, v int64) {
+	w.field(id, thriftCompactI64)
+	w.varint(zigzag(v))
+}
+
+func (w *thriftWriter) binary(id int16, v string) {
+	w.field(id, thriftCompactBinary)
+	w.rawBinary(v)
+}
+
+// rawBinary writes a string without a field header, as a list element
+func (w *thriftWriter) rawBinary(v string) {
+	w.varint(uint64(len(v)))
+	w.buf.WriteString(v)
+}
+
+// beginStruct starts a struct field; field IDs inside it count from zero again
+func (w *thriftWriter) beginStruct(id int16) {
+	w.field(id, thriftCompactStruct)
+	w.beginElement()
+}
+
+func (w *thriftWriter) endStruct() {
+	w.endElement()
+}
+
+// emptyStruct writes a struct field without fields, such as a logical type marker
+func (w *thriftWriter) emptyStruct(id int16) {
+	w.beginStruct(id)
+	w.endStruct()
+}
+
+// beginList writes a list field header; the caller writes size elements after it
+func (w *thriftWriter) beginList(id int16, elemType byte, size int) {
+	w.field(id, thriftCompactList)
+	if size < 15 {
+		w.buf.WriteByte(byte(size)<<4 | elemType)
+	} else {
+		w.buf.WriteByte(0xf0 | elemType)
+		w.varint(uint64(size))
+	}
+}
+
+// beginElement starts a struct that is a list element
+func (w *thriftWriter) beginElement() {
+	w.lastID = append(w.lastID, w.last)
+	w.last = 0
+}
+
+// endElement ends a struct started with beginElement or beginStruct
+func (w *thriftWriter) endElement() {
+	w.stop()
+	w.last = w.lastID[len(w.lastID)-1]
+	w.lastID = w.lastID[:len(w.lastID)-1]
+}
+
+// stop ends the fields of a struct
+func (w *thriftWriter) stop() {
+	w.buf.WriteByte(0)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// thriftReader decodes the Thrift compact protocol into generic values: structs become
+// map[int16]interface{}, lists []interface{}, integers int64 and binaries strings
+type thriftReader struct {
+	r *bytes.Reader
+}
+
+func (t *thriftReader) varint() uint64 {
+	v, err := binary.ReadUvarint(t.r)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+func (t *thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case thriftCompactI32, thriftCompactI64:
+		v := t.varint()
+		return int64(v>>1) ^ -int64(v&1)
+	case thriftCompactBinary:
+		data := make([]byte, t.varint())
+		t.r.Read(data)
+		return string(data)
+	case thriftCompactList:
+		header, _ := t.r.ReadByte()
+		size := int(header >> 4)
+		if size == 15 {
+			size = int(t.varint())
+		}
+		list := make([]interface{}, size)
+		for i := range list {
+			list[i] = t.value(header & 0x0f)
+		}
+		return list
+	case thriftCompactStruct:
+		return t.structValue()
+	}
+	panic(fmt.Sprintf("unexpected thrift type %d", typ))
+}
+
+func (t *thriftReader) structValue() map[int16]interface{} {
+	fields := map[int16]interface{}{}
+	var last int16
+	for {
+		header, err := t.r.ReadByte()
+		if err != nil {
+			panic(err)
+		}
+		if header == 0 {
+			return fields
+		}
+		id := last + int16(header>>4)
+		if header>>4 == 0 {
+			v := t.varint()
+			id = int16(int64(v>>1) ^ -int64(v&1))
+		}
+		last = id
+		fields[id] = t.value(header & 0x0f)
+	}
+}
+
+// readParquet reads back a file written by writeParquet: its row count, the schema
+// elements after the root, and each column chunk's decoded values
+func readParquet(t *testing.T, path string) (int64, []map[int16]interface{}, [][]interface{}) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte(parquetMagic)) || !bytes.HasSuffix(data, []byte(parquetMagic)) {
+		t.Fatal("missing PAR1 magic")
+	}
+	footerLength := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footer := data[len(data)-8-footerLength : len(data)-8]
+	meta := (&thriftReader{bytes.NewReader(footer)}).structValue()
+
+	schema := meta[2].([]interface{})
+	var columns []map[int16]interface{}
+	for _, element := range schema[1:] {
+		columns = append(columns, element.(map[int16]interface{}))
+	}
+
+	rowGroup := meta[4].([]interface{})[0].(map[int16]interface{})
+	var values [][]interface{}
+	for i, chunk := range rowGroup[1].([]interface{}) {
+		chunkMeta := chunk.(map[int16]interface{})[3].(map[int16]interface{})
+		reader := bytes.NewReader(data[chunkMeta[9].(int64):])
+		page := (&thriftReader{reader}).structValue()
+		rows := int(page[5].(map[int16]interface{})[1].(int64))
+		plain := make([]byte, page[2].(int64))
+		reader.Read(plain)
+
+		var column []interface{}
+		for r := 0; r < rows; r++ {
+			switch columns[i][1].(int64) {
+			case parquetTypeInt64:
+				column = append(column, int64(binary.LittleEndian.Uint64(plain)))
+				plain = plain[8:]
+			case parquetTypeInt32:
+				column = append(column, int64(int32(binary.LittleEndian.Uint32(plain))))
+				plain = plain[4:]
+			case parquetTypeByteArray:
+				n := binary.LittleEndian.Uint32(plain)
+				column = append(column, string(plain[4:4+n]))
+				plain = plain[4+n:]
+			}
+		}
+		if len(plain) != 0 {
+			t.Errorf("column %d has %d bytes left after %d values", i, len(plain), rows)
+		}
+		values = append(values, column)
+	}
+	return meta[3].(int64), columns, values
+}
+
+func TestWriteParquet(t *testing.T) {
+	many := make([]parquetColumn, 15)
+	for i := range many {
+		many[i] = parquetColumn{name: fmt.Sprintf("c%d", i), kind: parquetInt64, ints: []int64{int64(i)}}
+	}
+
+	tests := []struct {
+		name    string
+		columns []parquetColumn
+	}{
+		{"stats row types", []parquetColumn{
+			{name: "date", kind: parquetDate, ints: []int64{20744, 20745, -1}},
+			{name: "sub_account", kind: parquetString, strings: []string{"Marketing", "", "Zürich Büro"}},
+			{name: "delivered", kind: parquetInt64, ints: []int64{0, 1 << 40, -5}},
+		}},
+		{"no rows", []parquetColumn{{name: "date", kind: parquetDate}}},
+		{"long schema list", many},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "stats.parquet")
+			if err := writeParquet(path, tt.columns); err != nil {
+				t.Fatal(err)
+			}
+			rows, schema, values := readParquet(t, path)
+			if rows != int64(tt.columns[0].count()) {
+				t.Errorf("num_rows = %d, want %d", rows, tt.columns[0].count())
+			}
+			if len(schema) != len(tt.columns) || len(values) != len(tt.columns) {
+				t.Fatalf("got %d schema elements and %d chunks, want %d", len(schema), len(values), len(tt.columns))
+			}
+			for i, column := range tt.columns {
+				if schema[i][4] != column.name || schema[i][1] != int64(column.physicalType()) || schema[i][3] != int64(parquetRequired) {
+					t.Errorf("schema[%d] = %v, want required %s", i, schema[i], column.name)
+				}
+				var want []interface{}
+				for _, v := range column.ints {
+					want = append(want, v)
+				}
+				for _, v := range column.strings {
+					want = append(want, v)
+				}
+				if fmt.Sprint(values[i]) != fmt.Sprint(want) {
+					t.Errorf("column %s = %v, want %v", column.name, values[i], want)
+				}
+			}
+			if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+				t.Errorf("temporary file left behind: %v", err)
+			}
+		})
+	}
+}
+
+func TestWriteParquetRejectsUnevenColumns(t *testing.T) {
+	err := writeParquet(filepath.Join(t.TempDir(), "x.parquet"), []parquetColumn{
+		{name: "a", kind: parquetInt64, ints: []int64{1, 2}},
+		{name: "b", kind: parquetString, strings: []string{"x"}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "column b has 1 values, expected 2") {
+		t.Errorf("writeParquet() error = %v", err)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Default worker pool settings for bulk sends
+const (
+	defaultConcurrency = 4
+	maxConcurrency     = 64
+)
+
+// PoolOptions configures the send worker pool
+type PoolOptions struct {
+	// Concurrency is the number of SendEmail requests in flight at once
+	Concurrency int
+	// QueueSize bounds the number of prepared requests waiting for a worker; the producer
+	// blocks while the queue is full
+	QueueSize int
+	// Pacer, when set, holds each request back until its mailbox providers' pacing rules
+	// allow it
+	Pacer *Pacer
+}
+
+// normalize applies defaults and limits to the pool options
+func (o PoolOptions) normalize() PoolOptions {
+	if o.Concurrency <= 0 {
+		o.Concurrency = defaultConcurrency
+	}
+	if o.Concurrency > maxConcurrency {
+		o.Concurrency = maxConcurrency
+	}
+	if o.QueueSize <= 0 {
+		o.QueueSize = 2 * o.Concurrency
+	}
+	return o
+}
+
+// PoolStats summarizes a worker pool run
+type PoolStats struct {
+	Requests    int
+	Recipients  int
+	Sent        int
+	Failed      int
+	Skipped     int
+	OptedOut    int
+	Elapsed     time.Duration
+	Interrupted bool
+	latencies   []time.Duration
+}
+
+// percentile returns the latency below which p percent of the requests completed
+func (s PoolStats) percentile(p float64) time.Duration {
+	if len(s.latencies) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration{}, s.latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	index := int(p / 100 * float64(len(sorted)-1))
+	return sorted[index]
+}
+
+// Print prints throughput and latency figures
+func (s PoolStats) Print() {
+	fmt.Println("\n  Send statistics:")
+	fmt.Printf("    Requests: %d\n", s.Requests)
+	fmt.Printf("    Recipients: %d sent, %d failed, %d not sent\n", s.Sent, s.Failed, s.Skipped)
+	if s.OptedOut > 0 {
+		fmt.Printf("    Opted out: %d recipient(s) skipped by their preferences\n", s.OptedOut)
+	}
+	fmt.Printf("    Elapsed: %s\n", s.Elapsed.Round(time.Millisecond))
+	if seconds := s.Elapsed.Seconds(); seconds > 0 {
+		fmt.Printf("    Throughput: %.1f requests/s, %.1f recipients/s\n", float64(s.Requests)/seconds, float64(s.Sent+s.Failed)/seconds)
+	}
+	if len(s.latencies) > 0 {
+		fmt.Printf("    Latency: p50 %s, p95 %s, max %s\n",
+			s.percentile(50).Round(time.Millisecond),
+			s.percentile(95).Round(time.Millisecond),
+			s.percentile(100).Round(time.Millisecond))
+	}
+}
+
+// interruptContext returns a context that is cancelled on the first SIGINT or SIGTERM
+// After the first signal the default behaviour is restored, so a second Ctrl+C aborts
+// the process immediately. The returned function releases the signal handler.
+func interruptContext(action string) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case <-signals:
+			signal.Reset(os.Interrupt, syscall.SIGTERM)
+			fmt.Printf("\n⚠️  Interrupt received: %s (press Ctrl+C again to abort)\n", action)
+			cancel()
+		case <-finished:
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		close(finished)
+		cancel()
+	}
+}
+
+// notSentResults reports every recipient of a batch that was dropped before its request started
+func notSentResults(batch bulkBatch) []SendResult {
+	results := make([]SendResult, 0, len(batch.recipients))
+	for _, recipient := range batch.recipients {
+		results = append(results, SendResult{Email: recipient.GetEmail(), Error: "not sent: interrupted before this request started"})
+	}
+	return results
+}
+
+// sendFunc performs one request and returns a result per recipient
+type sendFunc func(ctx context.Context, batch bulkBatch) []SendResult
+
+// runSendPool sends batches with a bounded pool of workers
+//
+// Cancelling stopCtx stops handing out new batches; requests already in flight run to
+// completion on sendCtx, which is not cancelled, so no send is abandoned half-way.
+// Batches that were never started are reported with a "not sent" error. onResults is
+// called from a single goroutine in completion order.
+func runSendPool(stopCtx, sendCtx context.Context, opts PoolOptions, batches []bulkBatch, send sendFunc, onResults func([]SendResult)) PoolStats {
+	opts = opts.normalize()
+	start := time.Now()
+
+	type completed struct {
+		results []SendResult
+		latency time.Duration
+		skipped bool
+	}
+
+	jobs := make(chan bulkBatch, opts.QueueSize)
+	done := make(chan completed, opts.Concurrency)
+
+	var workers sync.WaitGroup
+	for i := 0; i < opts.Concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for batch := range jobs {
+				// Queued batches that have not started yet are dropped once interrupted
+				if stopCtx.Err() != nil {
+					done <- completed{results: notSentResults(batch), skipped: true}
+					continue
+				}
+				requestStart := time.Now()
+				results := send(sendCtx, batch)
+				done <- completed{results: results, latency: time.Since(requestStart)}
+			}
+		}()
+	}
+
+	// With pacing, batches are handed out in schedule order at their planned offsets
+	offsets := make([]time.Duration, len(batches))
+	if opts.Pacer != nil {
+		offsets = opts.Pacer.Schedule(batches)
+		ordered := make([]int, len(batches))
+		for i := range ordered {
+			ordered[i] = i
+		}
+		sort.SliceStable(ordered, func(a, b int) bool { return offsets[ordered[a]] < offsets[ordered[b]] })
+		sortedBatches := make([]bulkBatch, len(batches))
+		sortedOffsets := make([]time.Duration, len(batches))
+		for i, index := range ordered {
+			sortedBatches[i], sortedOffsets[i] = batches[index], offsets[index]
+		}
+		batches, offsets = sortedBatches, sortedOffsets
+	}
+	paceStart := time.Now()
+
+	// Producer: blocks while the queue is full, waits for paced batches and stops early
+	// when interrupted
+	var skipped []bulkBatch
+	go func() {
+		defer close(jobs)
+		for i, batch := range batches {
+			// Check for interruption first so no new batch is queued once stopCtx is done
+			if stopCtx.Err() != nil {
+				skipped = batches[i:]
+				return
+			}
+			if wait := time.Until(paceStart.Add(offsets[i])); wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-timer.C:
+				case <-stopCtx.Done():
+					timer.Stop()
+					skipped = batches[i:]
+					return
+				}
+			}
+			select {
+			case jobs <- batch:
+			case <-stopCtx.Done():
+				skipped = batches[i:]
+				return
+			}
+		}
+	}()
+
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
+
+	stats := PoolStats{}
+	for result := range done {
+		if result.skipped {
+			stats.Skipped += len(result.results)
+			onResults(result.results)
+			continue
+		}
+		stats.Requests++
+		stats.latencies = append(stats.latencies, result.latency)
+		for _, r := range result.results {
+			switch {
+			case r.Skipped != "":
+				stats.OptedOut++
+			case r.Error == "":
+				stats.Sent++
+			default:
+				stats.Failed++
+			}
+		}
+		onResults(result.results)
+	}
+
+	// The producer has returned once jobs is closed and all workers are done
+	for _, batch := range skipped {
+		results := notSentResults(batch)
+		stats.Skipped += len(results)
+		onResults(results)
+	}
+
+	stats.Recipients = stats.Sent + stats.Failed + stats.Skipped + stats.OptedOut
+	stats.Elapsed = time.Since(start)
+	stats.Interrupted = stopCtx.Err() != nil
+	return stats
+}
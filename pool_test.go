@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	sendpost "github.com/sendpost/sendpost-go-sdk"
+)
+
+// poolBatches builds count batches; every third one has two recipients
+func poolBatches(count int) []bulkBatch {
+	batches := make([]bulkBatch, count)
+	for i := range batches {
+		n := 1
+		if i%3 == 0 {
+			n = 2
+		}
+		for j := 0; j < n; j++ {
+			r := sendpost.NewRecipient()
+			r.SetEmail(fmt.Sprintf("r%d-%d@example.com", i, j))
+			batches[i].recipients = append(batches[i].recipients, *r)
+		}
+		batches[i].id = fmt.Sprint(i)
+	}
+	return batches
+}
+
+// poolResults collects the results runSendPool reports, keyed by email
+type poolResults struct {
+	byEmail map[string]SendResult
+	repeats []string
+}
+
+func (p *poolResults) add(results []SendResult) {
+	for _, r := range results {
+		if _, seen := p.byEmail[r.Email]; seen {
+			p.repeats = append(p.repeats, r.Email)
+		}
+		p.byEmail[r.Email] = r
+	}
+}
+
+func TestRunSendPoolStats(t *testing.T) {
+	batches := poolBatches(30)
+	var inFlight, maxInFlight int32
+	send := func(ctx context.Context, batch bulkBatch) []SendResult {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		var results []SendResult
+		for i, recipient := range batch.recipients {
+			r := SendResult{Email: recipient.GetEmail()}
+			switch {
+			case i == 1:
+				r.Skipped = "opted out"
+			case strings.HasPrefix(batch.id, "1"):
+				r.Error = "rejected"
+			default:
+				r.MessageID = "m-" + r.Email
+			}
+			results = append(results, r)
+		}
+		return results
+	}
+
+	got := &poolResults{byEmail: map[string]SendResult{}}
+	stats := runSendPool(context.Background(), context.Background(), PoolOptions{Concurrency: 3, QueueSize: 2}, batches, send, got.add)
+
+	// Batch ids 1 and 10-19 fail; batches 0, 3, ..., 27 have an opted-out second recipient
+	want := PoolStats{Requests: 30, Recipients: 40, Sent: 19, Failed: 11, OptedOut: 10}
+	if stats.Requests != want.Requests || stats.Recipients != want.Recipients || stats.Sent != want.Sent ||
+		stats.Failed != want.Failed || stats.OptedOut != want.OptedOut || stats.Skipped != 0 || stats.Interrupted {
+		t.Errorf("stats = %+v, want %+v", stats, want)
+	}
+	if len(stats.latencies) != 30 {
+		t.Errorf("%d latencies recorded, want one per request", len(stats.latencies))
+	}
+	if len(got.byEmail) != 40 || len(got.repeats) != 0 {
+		t.Errorf("%d recipients reported, repeated %v; want each of 40 once", len(got.byEmail), got.repeats)
+	}
+	if maxInFlight > 3 {
+		t.Errorf("%d requests in flight, concurrency is 3", maxInFlight)
+	}
+}
+
+func TestRunSendPoolInterrupted(t *testing.T) {
+	batches := poolBatches(12)
+	stopCtx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	started := make(chan struct{}, len(batches))
+	release := make(chan struct{})
+	var sendCtxErr error
+	var mu sync.Mutex
+	send := func(ctx context.Context, batch bulkBatch) []SendResult {
+		started <- struct{}{}
+		<-release
+		mu.Lock()
+		if ctx.Err() != nil {
+			sendCtxErr = ctx.Err()
+		}
+		mu.Unlock()
+		var results []SendResult
+		for _, recipient := range batch.recipients {
+			results = append(results, SendResult{Email: recipient.GetEmail(), MessageID: "m"})
+		}
+		return results
+	}
+	// Interrupt once both workers are busy; the queue is full by then
+	go func() {
+		<-started
+		<-started
+		stop()
+		close(release)
+	}()
+
+	got := &poolResults{byEmail: map[string]SendResult{}}
+	stats := runSendPool(stopCtx, context.Background(), PoolOptions{Concurrency: 2, QueueSize: 2}, batches, send, got.add)
+
+	if sendCtxErr != nil {
+		t.Errorf("requests in flight saw a cancelled context: %v", sendCtxErr)
+	}
+	if !stats.Interrupted || stats.Requests != 2 || stats.Failed != 0 {
+		t.Errorf("stats = %+v, want interrupted after 2 requests", stats)
+	}
+	if stats.Recipients != 16 || stats.Sent+stats.Skipped != 16 {
+		t.Errorf("stats = %+v, want all 16 recipients accounted for", stats)
+	}
+	if len(got.byEmail) != 16 || len(got.repeats) != 0 {
+		t.Errorf("%d recipients reported, repeated %v; want each of 16 once", len(got.byEmail), got.repeats)
+	}
+	notSent := 0
+	for _, r := range got.byEmail {
+		if strings.HasPrefix(r.Error, "not sent:") {
+			notSent++
+		}
+	}
+	if notSent != stats.Skipped {
+		t.Errorf("%d recipients reported not sent, stats say %d", notSent, stats.Skipped)
+	}
+}
+
+func TestRunSendPoolCancelledBeforeStart(t *testing.T) {
+	stopCtx, stop := context.WithCancel(context.Background())
+	stop()
+	var sends int32
+	send := func(ctx context.Context, batch bulkBatch) []SendResult {
+		atomic.AddInt32(&sends, 1)
+		return nil
+	}
+
+	got := &poolResults{byEmail: map[string]SendResult{}}
+	stats := runSendPool(stopCtx, context.Background(), PoolOptions{}, poolBatches(5), send, got.add)
+	if sends != 0 || stats.Requests != 0 || stats.Skipped != 7 || stats.Recipients != 7 || !stats.Interrupted {
+		t.Errorf("%d sends, stats = %+v; want nothing sent and 7 not sent", sends, stats)
+	}
+	for email, r := range got.byEmail {
+		if !strings.HasPrefix(r.Error, "not sent:") {
+			t.Errorf("%s reported %+v, want not sent", email, r)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	sendpost "github.com/sendpost/sendpost-go-sdk"
+)
+
+// defaultPreferencesFile holds subscription categories and recipients' opt-outs
+const defaultPreferencesFile = ".sendpost-preferences.json"
+
+// SubscriptionCategory is a kind of mail recipients can opt out of
+// A message belongs to every category that shares a group with it, so mail without
+// category groups (order confirmations, password resets) is never held back.
+type SubscriptionCategory struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Groups      []string `json:"groups"`
+}
+
+// defaultSubscriptionCategories are used until the preferences file defines its own
+var defaultSubscriptionCategories = []SubscriptionCategory{
+	{Name: "promotional", Description: "Offers, discounts and sales", Groups: []string{"promotional"}},
+	{Name: "newsletter", Description: "News and product updates", Groups: []string{"newsletter"}},
+}
+
+// RecipientPreferences are the categories one recipient opted out of
+type RecipientPreferences struct {
+	OptedOut  []string  `json:"optedOut,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// preferencesFile is the JSON document stored in the preferences file
+type preferencesFile struct {
+	Categories []SubscriptionCategory          `json:"categories,omitempty"`
+	Recipients map[string]RecipientPreferences `json:"recipients,omitempty"`
+}
+
+// PreferenceStore keeps per-recipient opt-outs in a local JSON file
+// The file is reloaded whenever it changes on disk, so a long-running scheduler sees
+// opt-outs recorded by the preference page.
+type PreferenceStore struct {
+	mu      sync.Mutex
+	path    string
+	data    preferencesFile
+	modTime time.Time
+	size    int64
+}
+
+// NewPreferenceStore creates a store for the preferences file at path
+func NewPreferenceStore(path string) *PreferenceStore {
+	return &PreferenceStore{path: path}
+}
+
+// load refreshes the store from disk when the file changed; the caller holds s.mu
+func (s *PreferenceStore) load() error {
+	info, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		s.data, s.modTime, s.size = preferencesFile{}, time.Time{}, 0
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not read preferences: %w", err)
+	}
+	if info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return nil
+	}
+
+	raw, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("could not read preferences: %w", err)
+	}
+	var data preferencesFile
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return fmt.Errorf("could not parse %s: %w", s.path, err)
+	}
+	s.data, s.modTime, s.size = data, info.ModTime(), info.Size()
+	return nil
+}
+
+// save writes the store atomically; the caller holds s.mu
+func (s *PreferenceStore) save() error {
+	raw, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, append(raw, '\n'), 0o600); err != nil {
+		return fmt.Errorf("could not save preferences: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("could not save preferences: %w", err)
+	}
+	if info, err := os.Stat(s.path); err == nil {
+		s.modTime, s.size = info.ModTime(), info.Size()
+	}
+	return nil
+}
+
+// categories returns the configured categories; the caller holds s.mu
+func (s *PreferenceStore) categories() []SubscriptionCategory {
+	if len(s.data.Categories) > 0 {
+		return s.data.Categories
+	}
+	return defaultSubscriptionCategories
+}
+
+// Categories returns the subscription categories
+func (s *PreferenceStore) Categories() ([]SubscriptionCategory, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s.categories(), nil
+}
+
+// CategoriesFor returns the names of the categories a message with these groups belongs to
+func (s *PreferenceStore) CategoriesFor(groups []string) ([]string, error) {
+	categories, err := s.Categories()
+	if err != nil {
+		return nil, err
+	}
+	return categoriesForGroups(categories, groups), nil
+}
+
+// categoriesForGroups matches groups against categories, case-insensitively
+func categoriesForGroups(categories []SubscriptionCategory, groups []string) []string {
+	var names []string
+	for _, category := range categories {
+		for _, group := range category.Groups {
+			if containsFold(groups, group) {
+				names = append(names, category.Name)
+				break
+			}
+		}
+	}
+	return names
+}
+
+// containsFold reports whether values contains value, ignoring case
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Get returns the preferences of one recipient
+func (s *PreferenceStore) Get(email string) (RecipientPreferences, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return RecipientPreferences{}, err
+	}
+	return s.data.Recipients[strings.ToLower(strings.TrimSpace(email))], nil
+}
+
+// Update opts a recipient out of and back into categories and returns the new preferences
+func (s *PreferenceStore) Update(email string, optOut, optIn []string) (RecipientPreferences, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return RecipientPreferences{}, err
+	}
+
+	known := map[string]bool{}
+	for _, category := range s.categories() {
+		known[category.Name] = true
+	}
+	for _, name := range append(append([]string{}, optOut...), optIn...) {
+		if !known[name] {
+			return RecipientPreferences{}, fmt.Errorf("unknown subscription category %q", name)
+		}
+	}
+
+	email = strings.ToLower(strings.TrimSpace(email))
+	current := s.data.Recipients[email]
+	optedOut := map[string]bool{}
+	for _, name := range current.OptedOut {
+		optedOut[name] = true
+	}
+	for _, name := range optOut {
+		optedOut[name] = true
+	}
+	for _, name := range optIn {
+		delete(optedOut, name)
+	}
+
+	updated := RecipientPreferences{UpdatedAt: time.Now().UTC()}
+	for name := range optedOut {
+		updated.OptedOut = append(updated.OptedOut, name)
+	}
+	sort.Strings(updated.OptedOut)
+
+	if s.data.Recipients == nil {
+		s.data.Recipients = map[string]RecipientPreferences{}
+	}
+	if len(updated.OptedOut) == 0 {
+		delete(s.data.Recipients, email)
+	} else {
+		s.data.Recipients[email] = updated
+	}
+	if err := s.save(); err != nil {
+		return RecipientPreferences{}, err
+	}
+	return updated, nil
+}
+
+// Filter splits recipients of a message with these groups into those who still receive
+// it and skipped results for those who opted out of one of its categories
+func (s *PreferenceStore) Filter(recipients []sendpost.Recipient, groups []string) ([]sendpost.Recipient, []SendResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return nil, nil, err
+	}
+
+	categories := categoriesForGroups(s.categories(), groups)
+	if len(categories) == 0 || len(s.data.Recipients) == 0 {
+		return recipients, nil, nil
+	}
+
+	kept := make([]sendpost.Recipient, 0, len(recipients))
+	var skipped []SendResult
+	for _, recipient := range recipients {
+		preferences := s.data.Recipients[strings.ToLower(strings.TrimSpace(recipient.GetEmail()))]
+		if category := firstOptOut(preferences, categories); category != "" {
+			skipped = append(skipped, SendResult{Email: recipient.GetEmail(), Skipped: "opted out of " + category})
+			continue
+		}
+		kept = append(kept, recipient)
+	}
+	return kept, skipped, nil
+}
+
+// firstOptOut returns the first of categories the recipient opted out of, or ""
+func firstOptOut(preferences RecipientPreferences, categories []string) string {
+	for _, category := range categories {
+		for _, name := range preferences.OptedOut {
+			if name == category {
+				return category
+			}
+		}
+	}
+	return ""
+}
+
+// PrintPreferences prints the categories and whether a recipient receives each of them
+func (e *ESPExample) PrintPreferences(email string) error {
+	categories, err := e.preferences.Categories()
+	if err != nil {
+		return err
+	}
+	preferences, err := e.preferences.Get(email)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Preferences of %s:\n", email)
+	for _, category := range categories {
+		status := "subscribed"
+		if firstOptOut(preferences, []string{category.Name}) != "" {
+			status = "opted out"
+		}
+		fmt.Printf("  %-14s %-11s %s (groups: %s)\n", category.Name, status, category.Description, strings.Join(category.Groups, ", "))
+	}
+	if !preferences.UpdatedAt.IsZero() {
+		fmt.Printf("  Updated: %s\n", formatTime(preferences.UpdatedAt))
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	sendpost "github.com/sendpost/sendpost-go-sdk"
+)
+
+// emlLineLength is the line length base64 parts are wrapped at (RFC 2045)
+const emlLineLength = 76
+
+// previewEmail handles --dry-run and --render-eml for a built message
+// It prints the SendEmail request body and writes an .eml preview per recipient when
+// requested, and reports whether the message was previewed instead of sent.
+func (e *ESPExample) previewEmail(emailMessage *sendpost.EmailMessageObject) bool {
+	if !e.dryRun {
+		return false
+	}
+
+	fmt.Println("Dry run: the message is not sent")
+	payload, err := json.MarshalIndent(emailMessage, "  ", "  ")
+	if err != nil {
+		fmt.Printf("✗ Failed to encode request:\n")
+		fmt.Printf("  Error: %v\n", err)
+		return true
+	}
+	fmt.Println("  POST /subaccount/email/")
+	fmt.Printf("  %s\n", payload)
+
+	if e.emlDir != "" {
+		paths, err := WriteEMLPreviews(e.emlDir, emailMessage)
+		if err != nil {
+			fmt.Printf("✗ Failed to write .eml preview:\n")
+			fmt.Printf("  Error: %v\n", err)
+			return true
+		}
+		for _, path := range paths {
+			fmt.Printf("  Preview written to %s\n", path)
+		}
+	}
+	fmt.Println("✓ Dry run finished: nothing was sent")
+	return true
+}
+
+// WriteEMLPreviews writes one RFC 5322 message per recipient of a request into dir and
+// returns the paths written; files are named after the recipient address
+func WriteEMLPreviews(dir string, emailMessage *sendpost.EmailMessageObject) ([]string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("could not create preview directory: %w", err)
+	}
+
+	var paths []string
+	for _, recipient := range emailMessage.GetTo() {
+		var buf bytes.Buffer
+		if err := RenderEML(&buf, emailMessage, recipient, time.Now()); err != nil {
+			return paths, err
+		}
+		path := filepath.Join(dir, emlFileName(recipient.GetEmail()))
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			return paths, fmt.Errorf("could not write preview: %w", err)
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// emlFileName turns an address into a safe file name
+func emlFileName(email string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_', r == '@', r == '+':
+			return r
+		}
+		return '_'
+	}, strings.ToLower(email))
+	return name + ".eml"
+}
+
+// formatAddress formats a mailbox for a header, encoding non-ASCII display names
+func formatAddress(email, name string) string {
+	address := mail.Address{Name: name, Address: email}
+	return address.String()
+}
+
+// RenderEML writes the message one recipient would receive as an RFC 5322 document
+//
+// The layout is multipart/mixed (regular attachments) around multipart/related (inline
+// images referenced as cid: from the HTML) around multipart/alternative (text and HTML).
+// Levels without content are left out. SendPost adds its own Message-ID, tracking pixel
+// and rewritten links when it sends, so those are not part of the preview.
+func RenderEML(w io.Writer, emailMessage *sendpost.EmailMessageObject, recipient sendpost.Recipient, date time.Time) error {
+	var buf bytes.Buffer
+	writeHeader := func(name, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+	}
+
+	writeHeader("Date", date.Format(time.RFC1123Z))
+	from := emailMessage.GetFrom()
+	writeHeader("From", formatAddress(from.GetEmail(), from.GetName()))
+	if emailMessage.HasReplyTo() {
+		replyTo := emailMessage.GetReplyTo()
+		writeHeader("Reply-To", formatAddress(replyTo.GetEmail(), replyTo.GetName()))
+	}
+	writeHeader("To", formatAddress(recipient.GetEmail(), recipient.GetName()))
+	var cc []string
+	for _, copyTo := range recipient.GetCc() {
+		cc = append(cc, formatAddress(copyTo.GetEmail(), copyTo.GetName()))
+	}
+	if len(cc) > 0 {
+		writeHeader("Cc", strings.Join(cc, ", "))
+	}
+	writeHeader("Subject", mime.QEncoding.Encode("utf-8", emailMessage.GetSubject()))
+
+	custom := emailMessage.GetHeaders()
+	names := make([]string, 0, len(custom))
+	for name := range custom {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		writeHeader(name, mime.QEncoding.Encode("utf-8", custom[name]))
+	}
+
+	html := emailMessage.GetHtmlBody()
+	var inline, attached []sendpost.Attachment
+	for _, attachment := range emailMessage.GetAttachments() {
+		if html != "" && strings.Contains(html, "cid:"+attachment.GetFilename()) {
+			inline = append(inline, attachment)
+		} else {
+			attached = append(attached, attachment)
+		}
+	}
+
+	body, bodyHeader, err := renderEMLBody(emailMessage.GetTextBody(), html, inline, attached)
+	if err != nil {
+		return err
+	}
+	writeHeader("MIME-Version", "1.0")
+	writeHeader("Content-Type", bodyHeader.Get("Content-Type"))
+	if encoding := bodyHeader.Get("Content-Transfer-Encoding"); encoding != "" {
+		writeHeader("Content-Transfer-Encoding", encoding)
+	}
+	buf.WriteString("\r\n")
+	buf.Write(body)
+
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// renderEMLBody builds the MIME body and returns it with its Content-Type and
+// Content-Transfer-Encoding headers
+func renderEMLBody(text, html string, inline, attached []sendpost.Attachment) ([]byte, textproto.MIMEHeader, error) {
+	body, header, err := renderAlternative(text, html)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(inline) > 0 {
+		if body, header, err = wrapMultipart("related", body, header, inline, true); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(attached) > 0 {
+		if body, header, err = wrapMultipart("mixed", body, header, attached, false); err != nil {
+			return nil, nil, err
+		}
+	}
+	return body, header, nil
+}
+
+// textPartHeader returns the headers of a quoted-printable UTF-8 text part
+func textPartHeader(mediaType string) textproto.MIMEHeader {
+	return textproto.MIMEHeader{
+		"Content-Type":              {mediaType + "; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	}
+}
+
+// renderAlternative encodes the text and HTML bodies, as multipart/alternative when both exist
+func renderAlternative(text, html string) ([]byte, textproto.MIMEHeader, error) {
+	if html == "" || text == "" {
+		content, mediaType := text, "text/plain"
+		if html != "" {
+			content, mediaType = html, "text/html"
+		}
+		body, err := quotedPrintable(content)
+		return body, textPartHeader(mediaType), err
+	}
+
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	for _, part := range []struct{ mediaType, content string }{{"text/plain", text}, {"text/html", html}} {
+		encoded, err := quotedPrintable(part.content)
+		if err != nil {
+			return nil, nil, err
+		}
+		partWriter, err := writer.CreatePart(textPartHeader(part.mediaType))
+		if err != nil {
+			return nil, nil, err
+		}
+		partWriter.Write(encoded)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), textproto.MIMEHeader{"Content-Type": {"multipart/alternative; boundary=" + writer.Boundary()}}, nil
+}
+
+// wrapMultipart puts a body and attachments into a multipart container of the given subtype
+// Inline attachments get a Content-ID matching the cid: reference in the HTML.
+func wrapMultipart(subtype string, body []byte, bodyHeader textproto.MIMEHeader, attachments []sendpost.Attachment, inline bool) ([]byte, textproto.MIMEHeader, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	partWriter, err := writer.CreatePart(bodyHeader)
+	if err != nil {
+		return nil, nil, err
+	}
+	partWriter.Write(body)
+
+	for _, attachment := range attachments {
+		content, err := base64.StdEncoding.DecodeString(attachment.GetContent())
+		if err != nil {
+			return nil, nil, fmt.Errorf("attachment %s is not valid base64: %w", attachment.GetFilename(), err)
+		}
+		filename := attachment.GetFilename()
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", detectContentType(filename, content))
+		header.Set("Content-Transfer-Encoding", "base64")
+		disposition := "attachment"
+		if inline {
+			disposition = "inline"
+			header.Set("Content-ID", "<"+filename+">")
+		}
+		header.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": filename}))
+
+		partWriter, err := writer.CreatePart(header)
+		if err != nil {
+			return nil, nil, err
+		}
+		encoded := base64.StdEncoding.EncodeToString(content)
+		for len(encoded) > emlLineLength {
+			io.WriteString(partWriter, encoded[:emlLineLength]+"\r\n")
+			encoded = encoded[emlLineLength:]
+		}
+		io.WriteString(partWriter, encoded+"\r\n")
+	}
+	if err := writer.Close(); err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), textproto.MIMEHeader{"Content-Type": {"multipart/" + subtype + "; boundary=" + writer.Boundary()}}, nil
+}
+
+// quotedPrintable encodes a text part with CRLF line endings
+func quotedPrintable(content string) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := quotedprintable.NewWriter(&buf)
+	if _, err := io.WriteString(writer, strings.ReplaceAll(strings.ReplaceAll(content, "\r\n", "\n"), "\n", "\r\n")); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
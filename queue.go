@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	sendpost "github.com/sendpost/sendpost-go-sdk"
+)
+
+// Queue file names inside the queue directory
+const (
+	defaultQueueDir = ".sendpost-queue"
+	queueLogFile    = "queue.wal"
+)
+
+// queueStatus is the state of a queued request
+type queueStatus string
+
+const (
+	// statusPending requests are waiting to be sent
+	statusPending queueStatus = "pending"
+	// statusInFlight requests have been handed to SendEmail but not committed yet
+	statusInFlight queueStatus = "in-flight"
+	// statusUnknown requests were in flight when the process stopped or got no response
+	// from SendPost; it may or may not have accepted them, so they are never resent
+	// automatically
+	statusUnknown queueStatus = "unknown"
+	// statusSent requests were committed with a message ID for every recipient
+	statusSent queueStatus = "sent"
+	// statusFailed requests were committed with at least one recipient error that
+	// SendPost reported
+	statusFailed queueStatus = "failed"
+	// statusCancelled requests were scheduled and cancelled before their send time
+	statusCancelled queueStatus = "cancelled"
+)
+
+// Write-ahead log operations
+const (
+	opEnqueue    = "enqueue"
+	opInFlight   = "in-flight"
+	opCommit     = "commit"
+	opRequeue    = "requeue"
+	opCancel     = "cancel"
+	opReschedule = "reschedule"
+)
+
+// walRecord is one line of the write-ahead log
+type walRecord struct {
+	Op      string                       `json:"op"`
+	ID      string                       `json:"id"`
+	Time    time.Time                    `json:"time"`
+	SendAt  time.Time                    `json:"sendAt,omitempty"`
+	Message *sendpost.EmailMessageObject `json:"message,omitempty"`
+	Results []SendResult                 `json:"results,omitempty"`
+}
+
+// QueueEntry is the current state of one queued SendEmail request
+// SendAt is zero for requests sent right away; scheduled requests are only sent by the scheduler.
+type QueueEntry struct {
+	ID         string
+	Status     queueStatus
+	Message    *sendpost.EmailMessageObject
+	Results    []SendResult
+	SendAt     time.Time
+	EnqueuedAt time.Time
+	UpdatedAt  time.Time
+}
+
+// SendQueue is a disk-backed outbound queue
+//
+// Every state change is appended to a write-ahead log and synced to disk before the
+// corresponding action happens: a request is logged in-flight before SendEmail is called
+// and committed with its message IDs afterwards. On restart the log is replayed, so
+// committed requests are never sent again and pending ones resume where they left off.
+type SendQueue struct {
+	mu      sync.Mutex
+	dir     string
+	file    *os.File
+	entries map[string]*QueueEntry
+	order   []string
+}
+
+// queueID derives a stable ID from the request content, so enqueuing the same request
+// again after a restart is recognized instead of duplicated
+func queueID(message *sendpost.EmailMessageObject) (string, error) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:12]), nil
+}
+
+// OpenSendQueue opens (or creates) the queue in dir and replays its log
+// Requests that were in flight when the previous process stopped become unknown.
+func OpenSendQueue(dir string) (*SendQueue, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("could not create queue directory: %w", err)
+	}
+
+	q := &SendQueue{dir: dir, entries: map[string]*QueueEntry{}}
+	if err := q.replay(); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, queueLogFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open queue log: %w", err)
+	}
+	q.file = file
+	return q, nil
+}
+
+// replay rebuilds the queue state from the write-ahead log
+// A torn final line, which is what a crash during append leaves behind, is truncated so
+// later appends start on a fresh line; the action it described never started.
+func (q *SendQueue) replay() error {
+	path := filepath.Join(q.dir, queueLogFile)
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not read queue log: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(data) > 0 {
+				fmt.Printf("⚠️  Discarding incomplete queue log line %d\n", line)
+				if err := os.Truncate(path, offset); err != nil {
+					return fmt.Errorf("could not repair queue log: %w", err)
+				}
+			}
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("could not read queue log: %w", err)
+		}
+		offset += int64(len(data))
+
+		var record walRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			fmt.Printf("⚠️  Ignoring unreadable queue log line %d: %v\n", line, err)
+			continue
+		}
+		q.apply(record)
+	}
+
+	for _, entry := range q.entries {
+		if entry.Status == statusInFlight {
+			entry.Status = statusUnknown
+		}
+	}
+	return nil
+}
+
+// apply updates the in-memory state with one log record
+func (q *SendQueue) apply(record walRecord) {
+	entry, ok := q.entries[record.ID]
+	if record.Op == opEnqueue {
+		if ok {
+			return
+		}
+		entry = &QueueEntry{ID: record.ID, Status: statusPending, Message: record.Message, SendAt: record.SendAt, EnqueuedAt: record.Time}
+		q.entries[record.ID] = entry
+		q.order = append(q.order, record.ID)
+	}
+	if entry == nil {
+		return
+	}
+
+	entry.UpdatedAt = record.Time
+	switch record.Op {
+	case opInFlight:
+		entry.Status = statusInFlight
+	case opCommit:
+		entry.Results = record.Results
+		entry.Status = statusSent
+		for _, result := range record.Results {
+			if result.Unknown {
+				entry.Status = statusUnknown
+				break
+			}
+			if result.Error != "" {
+				entry.Status = statusFailed
+			}
+		}
+	case opRequeue:
+		entry.Status = statusPending
+		entry.Results = nil
+	case opCancel:
+		entry.Status = statusCancelled
+	case opReschedule:
+		entry.SendAt = record.SendAt
+		entry.Status = statusPending
+	}
+}
+
+// append writes a record to the log, syncs it to disk and applies it
+func (q *SendQueue) append(record walRecord) error {
+	record.Time = time.Now().UTC()
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, err := q.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("could not write queue log: %w", err)
+	}
+	if err := q.file.Sync(); err != nil {
+		return fmt.Errorf("could not sync queue log: %w", err)
+	}
+	q.apply(record)
+	return nil
+}
+
+// Enqueue adds a request unless an identical one is already queued; it returns the ID and
+// whether the request was newly added
+func (q *SendQueue) Enqueue(message *sendpost.EmailMessageObject) (string, bool, error) {
+	return q.EnqueueAt(message, time.Time{})
+}
+
+// EnqueueAt adds a request to be sent by the scheduler at sendAt; a zero sendAt sends it
+// right away
+func (q *SendQueue) EnqueueAt(message *sendpost.EmailMessageObject, sendAt time.Time) (string, bool, error) {
+	id, err := queueID(message)
+	if err != nil {
+		return "", false, err
+	}
+
+	q.mu.Lock()
+	_, exists := q.entries[id]
+	q.mu.Unlock()
+	if exists {
+		return id, false, nil
+	}
+
+	return id, true, q.append(walRecord{Op: opEnqueue, ID: id, SendAt: sendAt.UTC(), Message: message})
+}
+
+// MarkInFlight records that a request is about to be sent
+func (q *SendQueue) MarkInFlight(id string) error {
+	return q.append(walRecord{Op: opInFlight, ID: id})
+}
+
+// Commit records the outcome of a sent request
+func (q *SendQueue) Commit(id string, results []SendResult) error {
+	return q.append(walRecord{Op: opCommit, ID: id, Results: results})
+}
+
+// Requeue makes an unknown or failed request pending again
+func (q *SendQueue) Requeue(id string) error {
+	return q.append(walRecord{Op: opRequeue, ID: id})
+}
+
+// Cancel cancels a scheduled request that has not been sent yet
+func (q *SendQueue) Cancel(id string) error {
+	if err := q.checkScheduled(id); err != nil {
+		return err
+	}
+	return q.append(walRecord{Op: opCancel, ID: id})
+}
+
+// Reschedule moves a scheduled or cancelled request to a new send time
+func (q *SendQueue) Reschedule(id string, sendAt time.Time) error {
+	if err := q.checkScheduled(id); err != nil {
+		return err
+	}
+	return q.append(walRecord{Op: opReschedule, ID: id, SendAt: sendAt.UTC()})
+}
+
+// checkScheduled reports an error unless id is a scheduled request that has not been sent
+func (q *SendQueue) checkScheduled(id string) error {
+	entry, ok := q.Entry(id)
+	switch {
+	case !ok:
+		return fmt.Errorf("request %s is not in the queue", id)
+	case entry.SendAt.IsZero():
+		return fmt.Errorf("request %s is not scheduled", id)
+	case entry.Status != statusPending && entry.Status != statusCancelled:
+		return fmt.Errorf("request %s is %s and can no longer be changed", id, entry.Status)
+	}
+	return nil
+}
+
+// Entry returns a copy of a queue entry
+func (q *SendQueue) Entry(id string) (QueueEntry, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	entry, ok := q.entries[id]
+	if !ok {
+		return QueueEntry{}, false
+	}
+	return *entry, true
+}
+
+// Entries returns copies of all entries with one of the given statuses (all when none
+// are given), in enqueue order
+func (q *SendQueue) Entries(statuses ...queueStatus) []QueueEntry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var entries []QueueEntry
+	for _, id := range q.order {
+		entry := q.entries[id]
+		if len(statuses) == 0 || containsStatus(statuses, entry.Status) {
+			entries = append(entries, *entry)
+		}
+	}
+	return entries
+}
+
+// containsStatus reports whether status is one of statuses
+func containsStatus(statuses []queueStatus, status queueStatus) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Counts returns the number of entries per status
+func (q *SendQueue) Counts() map[queueStatus]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	counts := map[queueStatus]int{}
+	for _, entry := range q.entries {
+		counts[entry.Status]++
+	}
+	return counts
+}
+
+// Close closes the queue log
+func (q *SendQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.file.Close()
+}
+
+// batchesFor converts queue entries back into batches for the worker pool
+func batchesFor(entries []QueueEntry) []bulkBatch {
+	batches := make([]bulkBatch, 0, len(entries))
+	for _, entry := range entries {
+		batches = append(batches, bulkBatch{id: entry.ID, recipients: entry.Message.GetTo(), message: entry.Message})
+	}
+	return batches
+}
+
+// queuedSend wraps a send function so every request is logged in-flight before it is sent
+// and committed with its results afterwards
+func (q *SendQueue) queuedSend(send sendFunc) sendFunc {
+	return func(ctx context.Context, batch bulkBatch) []SendResult {
+		if err := q.MarkInFlight(batch.id); err != nil {
+			// Without the in-flight record a crash could cause a double send, so do not send
+			results := make([]SendResult, 0, len(batch.recipients))
+			for _, recipient := range batch.recipients {
+				results = append(results, SendResult{Email: recipient.GetEmail(), Error: "not sent: " + err.Error()})
+			}
+			return results
+		}
+
+		results := send(ctx, batch)
+		if err := q.Commit(batch.id, results); err != nil {
+			fmt.Printf("⚠️  Could not commit request %s: %v\n", batch.id, err)
+		}
+		return results
+	}
+}
+
+// PrintStatus prints the number of entries per status and lists unknown requests
+func (q *SendQueue) PrintStatus() {
+	counts := q.Counts()
+	statuses := make([]string, 0, len(counts))
+	for status := range counts {
+		statuses = append(statuses, string(status))
+	}
+	sort.Strings(statuses)
+
+	fmt.Printf("Queue %s:\n", q.dir)
+	if len(statuses) == 0 {
+		fmt.Println("  (empty)")
+	}
+	for _, status := range statuses {
+		fmt.Printf("  %s: %d\n", status, counts[queueStatus(status)])
+	}
+
+	for _, entry := range q.Entries(statusUnknown) {
+		reason := "was in flight when the process stopped"
+		if len(entry.Results) > 0 {
+			reason = "got no response from SendPost"
+		}
+		fmt.Printf("  ⚠️  %s %s (%d recipient(s), since %s)\n",
+			entry.ID, reason, len(entry.Message.To), formatTime(entry.UpdatedAt))
+	}
+}
+
+// immediate selects pending requests that are not scheduled
+func immediate(entry QueueEntry) bool {
+	return entry.SendAt.IsZero()
+}
+
+// RunQueue sends the pending requests selected by include with the worker pool and returns
+// the results of this run
+func (e *ESPExample) RunQueue(queue *SendQueue, pool PoolOptions, include func(QueueEntry) bool) ([]SendResult, PoolStats) {
+	var pending []QueueEntry
+	for _, entry := range queue.Entries(statusPending) {
+		if include(entry) {
+			pending = append(pending, entry)
+		}
+	}
+	batches := batchesFor(pending)
+
+	// Ctrl+C stops queuing new requests; requests in flight finish on their own context
+	stopCtx, stop := interruptContext("finishing in-flight sends")
+	defer stop()
+
+	pool = pool.normalize()
+	fmt.Printf("Sending %d queued request(s) with %d worker(s), queue size %d\n", len(batches), pool.Concurrency, pool.QueueSize)
+
+	var results []SendResult
+	completed := 0
+	sendCtx := e.createSubAccountAuthContext()
+	stats := runSendPool(stopCtx, sendCtx, pool, batches, queue.queuedSend(e.sendBatch), func(batchResults []SendResult) {
+		results = append(results, batchResults...)
+		completed++
+		fmt.Printf("  Completed %d/%d request(s)\n", completed, len(batches))
+	})
+	return results, stats
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openTestQueue opens a queue in dir and closes it when the test ends
+func openTestQueue(t *testing.T, dir string) *SendQueue {
+	t.Helper()
+	queue, err := OpenSendQueue(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { queue.Close() })
+	return queue
+}
+
+func TestQueueReplay(t *testing.T) {
+	tests := []struct {
+		name    string
+		actions func(t *testing.T, q *SendQueue, id string)
+		want    queueStatus
+	}{
+		{"pending", func(t *testing.T, q *SendQueue, id string) {}, statusPending},
+		{"in flight becomes unknown", func(t *testing.T, q *SendQueue, id string) {
+			q.MarkInFlight(id)
+		}, statusUnknown},
+		{"sent", func(t *testing.T, q *SendQueue, id string) {
+			q.MarkInFlight(id)
+			q.Commit(id, []SendResult{{Email: "a@example.com", MessageID: "m1"}})
+		}, statusSent},
+		{"failed", func(t *testing.T, q *SendQueue, id string) {
+			q.MarkInFlight(id)
+			q.Commit(id, []SendResult{{Email: "a@example.com", Error: "status 422: invalid"}})
+		}, statusFailed},
+		{"no response", func(t *testing.T, q *SendQueue, id string) {
+			q.MarkInFlight(id)
+			q.Commit(id, []SendResult{{Email: "a@example.com", Error: "unknown: timeout", Unknown: true}})
+		}, statusUnknown},
+		{"requeued", func(t *testing.T, q *SendQueue, id string) {
+			q.MarkInFlight(id)
+			q.Requeue(id)
+		}, statusPending},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			queue, err := OpenSendQueue(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			id, added, err := queue.Enqueue(testMessage(t, "a@example.com"))
+			if err != nil || !added {
+				t.Fatalf("Enqueue() = %s, %v, %v", id, added, err)
+			}
+			tt.actions(t, queue, id)
+			queue.Close()
+
+			entry, ok := openTestQueue(t, dir).Entry(id)
+			if !ok || entry.Status != tt.want {
+				t.Errorf("status after replay = %q (found %v), want %q", entry.Status, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueueEnqueueIsIdempotent(t *testing.T) {
+	dir := t.TempDir()
+	queue := openTestQueue(t, dir)
+	first, _, _ := queue.Enqueue(testMessage(t, "a@example.com"))
+	second, added, err := queue.Enqueue(testMessage(t, "a@example.com"))
+	if err != nil || added || second != first {
+		t.Errorf("second Enqueue() = %s, %v, %v; want %s, false", second, added, err, first)
+	}
+	if other, added, _ := queue.Enqueue(testMessage(t, "b@example.com")); !added || other == first {
+		t.Errorf("different message got ID %s, added %v", other, added)
+	}
+	if n := len(queue.Entries()); n != 2 {
+		t.Errorf("got %d entries, want 2", n)
+	}
+}
+
+func TestQueueReplayRepairsTornLine(t *testing.T) {
+	dir := t.TempDir()
+	queue := openTestQueue(t, dir)
+	id, _, _ := queue.Enqueue(testMessage(t, "a@example.com"))
+	queue.Close()
+
+	path := filepath.Join(dir, queueLogFile)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"op":"in-flight","id":"` + id)
+	file.Close()
+
+	queue = openTestQueue(t, dir)
+	if entry, _ := queue.Entry(id); entry.Status != statusPending {
+		t.Errorf("status = %q; the torn in-flight record must not apply", entry.Status)
+	}
+	if err := queue.MarkInFlight(id); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 2 {
+		t.Errorf("log has %d lines after repair, want 2:\n%s", len(lines), data)
+	}
+}
+
+func TestQueueSchedule(t *testing.T) {
+	queue := openTestQueue(t, t.TempDir())
+	immediateID, _, _ := queue.Enqueue(testMessage(t, "a@example.com"))
+	scheduledID, _, _ := queue.EnqueueAt(testMessage(t, "b@example.com"), time.Now().Add(time.Hour))
+
+	if err := queue.Cancel(immediateID); err == nil || !strings.Contains(err.Error(), "not scheduled") {
+		t.Errorf("Cancel(immediate) error = %v", err)
+	}
+	if err := queue.Cancel("missing"); err == nil {
+		t.Error("Cancel(missing) succeeded")
+	}
+	if err := queue.Cancel(scheduledID); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(2 * time.Hour).UTC().Truncate(time.Second)
+	if err := queue.Reschedule(scheduledID, later); err != nil {
+		t.Fatal(err)
+	}
+	entry, _ := queue.Entry(scheduledID)
+	if entry.Status != statusPending || !entry.SendAt.Equal(later) {
+		t.Errorf("entry = %s at %s, want pending at %s", entry.Status, entry.SendAt, later)
+	}
+
+	queue.MarkInFlight(scheduledID)
+	queue.Commit(scheduledID, []SendResult{{Email: "b@example.com", MessageID: "m1"}})
+	if err := queue.Reschedule(scheduledID, later); err == nil || !strings.Contains(err.Error(), "can no longer be changed") {
+		t.Errorf("Reschedule(sent) error = %v", err)
+	}
+}
+
+func TestSendBatchOutcomes(t *testing.T) {
+	tests := []struct {
+		name        string
+		handler     http.Handler
+		wantError   string
+		wantUnknown bool
+	}{
+		{"sent", jsonHandler(200, `[{"to":"a@example.com","messageId":"m1"}]`), "", false},
+		{"rejected", jsonHandler(422, `{"error":"invalid sender"}`), "status 422", false},
+		{"recipient error", jsonHandler(200, `[{"to":"a@example.com","errorCode":406,"message":"suppressed"}]`), "error 406: suppressed", false},
+		{"no response", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Drop the connection after reading the request, as a reset or timeout would
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+		}), "unknown: no response from SendPost", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestExample(t, tt.handler)
+			message := testMessage(t, "a@example.com")
+			results := e.sendBatch(context.Background(), bulkBatch{recipients: message.GetTo(), message: message})
+			if len(results) != 1 {
+				t.Fatalf("got %d results, want 1", len(results))
+			}
+			result := results[0]
+			if result.Unknown != tt.wantUnknown || !strings.Contains(result.Error, tt.wantError) || (tt.wantError == "") != (result.Error == "") {
+				t.Errorf("result = %+v, want error %q, unknown %v", result, tt.wantError, tt.wantUnknown)
+			}
+		})
+	}
+}
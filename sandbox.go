@@ -0,0 +1,602 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	sendpost "github.com/sendpost/sendpost-go-sdk"
+)
+
+// Sandbox modes, selected with SENDPOST_SANDBOX
+const (
+	sandboxRedirect = "redirect"
+	sandboxCapture  = "capture"
+)
+
+// Sandbox settings
+const (
+	defaultSandboxDir    = ".sendpost-sandbox"
+	defaultSandboxState  = ".sendpost-sandbox-state"
+	defaultSandboxAddr   = "127.0.0.1:8025"
+	sandboxOriginalTo    = "X-Sandbox-Original-To"
+	sandboxMessagePrefix = "sandbox-"
+)
+
+// Sandbox keeps staging sends away from real recipients
+//
+// In redirect mode every recipient that is not allowlisted is replaced by a safe address
+// before SendEmail is called, and the original addresses are kept in the
+// X-Sandbox-Original-To header. In capture mode SendEmail is not called at all: each
+// recipient's message is written to a Maildir (or an mbox file when the path ends in
+// .mbox) and a sandbox message ID is returned, so the rest of the send path runs unchanged.
+//
+// The queue, idempotency keys, send log and campaigns of sandbox runs live in StateDir, so
+// a rehearsal never settles, deduplicates or launches anything that real sends rely on.
+type Sandbox struct {
+	Mode     string
+	Redirect string
+	Allow    []string
+	Path     string
+	StateDir string
+
+	mu     sync.Mutex
+	banner sync.Once
+}
+
+// sandboxFromEnv configures the sandbox from SENDPOST_SANDBOX, SENDPOST_SANDBOX_TO,
+// SENDPOST_SANDBOX_ALLOW, SENDPOST_SANDBOX_DIR and SENDPOST_SANDBOX_STATE_DIR; it returns
+// nil when sandboxing is off
+func sandboxFromEnv() (*Sandbox, error) {
+	mode := strings.ToLower(strings.TrimSpace(os.Getenv("SENDPOST_SANDBOX")))
+	if mode == "" || mode == "off" {
+		return nil, nil
+	}
+
+	sandbox := &Sandbox{
+		Mode:     mode,
+		Redirect: strings.TrimSpace(os.Getenv("SENDPOST_SANDBOX_TO")),
+		Path:     os.Getenv("SENDPOST_SANDBOX_DIR"),
+		StateDir: os.Getenv("SENDPOST_SANDBOX_STATE_DIR"),
+	}
+	if sandbox.Path == "" {
+		sandbox.Path = defaultSandboxDir
+	}
+	if sandbox.StateDir == "" {
+		sandbox.StateDir = defaultSandboxState
+	}
+	for _, entry := range strings.Split(os.Getenv("SENDPOST_SANDBOX_ALLOW"), ",") {
+		if entry = strings.ToLower(strings.TrimSpace(entry)); entry != "" {
+			sandbox.Allow = append(sandbox.Allow, entry)
+		}
+	}
+
+	switch mode {
+	case sandboxRedirect:
+		if _, err := mail.ParseAddress(sandbox.Redirect); err != nil {
+			return nil, fmt.Errorf("SENDPOST_SANDBOX=redirect needs a valid SENDPOST_SANDBOX_TO address")
+		}
+	case sandboxCapture:
+	default:
+		return nil, fmt.Errorf("SENDPOST_SANDBOX must be %s, %s or off, not %q", sandboxRedirect, sandboxCapture, mode)
+	}
+	return sandbox, nil
+}
+
+// allowed reports whether an address may receive mail in redirect mode: it matches an
+// allowlisted address, or its domain matches an allowlisted domain (written as
+// "example.com" or "@example.com")
+func (s *Sandbox) allowed(email string) bool {
+	email = strings.ToLower(strings.TrimSpace(email))
+	domain := emailDomain(email)
+	for _, entry := range s.Allow {
+		if entry == email || strings.TrimPrefix(entry, "@") == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// statePath moves a queue directory or state file into the sandbox state directory,
+// keeping its base name; without a sandbox it returns path unchanged
+// Mapping an already mapped path returns it as is.
+func (s *Sandbox) statePath(path string) string {
+	if s == nil {
+		return path
+	}
+	return filepath.Join(s.StateDir, filepath.Base(filepath.Clean(path)))
+}
+
+// printBanner announces the sandbox once per process
+func (s *Sandbox) printBanner() {
+	s.banner.Do(func() {
+		switch s.Mode {
+		case sandboxRedirect:
+			fmt.Printf("⚠️  Sandbox mode: recipients outside the allowlist are redirected to %s\n", s.Redirect)
+		case sandboxCapture:
+			fmt.Printf("⚠️  Sandbox mode: messages are captured in %s and not sent\n", s.Path)
+		}
+		fmt.Printf("  Queue, idempotency keys, send log and campaigns are kept in %s\n", s.StateDir)
+	})
+}
+
+// Send handles one SendEmail request in sandbox mode; send performs the real API call
+// Responses always name the original recipients, so callers map results as usual.
+func (s *Sandbox) Send(ctx context.Context, message *sendpost.EmailMessageObject, send func(context.Context, *sendpost.EmailMessageObject) ([]sendpost.EmailResponse, *http.Response, error)) ([]sendpost.EmailResponse, *http.Response, error) {
+	s.printBanner()
+	if s.Mode == sandboxCapture {
+		responses, err := s.capture(message)
+		return responses, nil, err
+	}
+
+	redirected, originals := s.redirect(message)
+	responses, resp, err := send(ctx, redirected)
+	if len(responses) == len(originals) {
+		for i := range responses {
+			responses[i].SetTo(originals[i])
+		}
+	}
+	return responses, resp, err
+}
+
+// redirect returns a copy of the message with every recipient outside the allowlist
+// replaced by the sandbox address, and the original recipient addresses in order
+func (s *Sandbox) redirect(message *sendpost.EmailMessageObject) (*sendpost.EmailMessageObject, []string) {
+	redirected := *message
+	redirected.To = make([]sendpost.Recipient, 0, len(message.To))
+	headers := make(map[string]string, len(message.Headers)+1)
+	for name, value := range message.Headers {
+		headers[name] = value
+	}
+
+	var originals, replaced []string
+	for _, recipient := range message.To {
+		originals = append(originals, recipient.GetEmail())
+		if !s.allowed(recipient.GetEmail()) {
+			replaced = append(replaced, recipient.GetEmail())
+			recipient.SetEmail(s.Redirect)
+		}
+
+		// Copies outside the allowlist are dropped; the sandbox address already gets the message
+		var cc, bcc []sendpost.CopyTo
+		for _, copyTo := range recipient.GetCc() {
+			if s.allowed(copyTo.GetEmail()) {
+				cc = append(cc, copyTo)
+			} else {
+				replaced = append(replaced, copyTo.GetEmail())
+			}
+		}
+		for _, copyTo := range recipient.GetBcc() {
+			if s.allowed(copyTo.GetEmail()) {
+				bcc = append(bcc, copyTo)
+			} else {
+				replaced = append(replaced, copyTo.GetEmail())
+			}
+		}
+		recipient.Cc, recipient.Bcc = cc, bcc
+		redirected.To = append(redirected.To, recipient)
+	}
+
+	if len(replaced) > 0 {
+		headers[sandboxOriginalTo] = strings.Join(replaced, ", ")
+	}
+	redirected.Headers = headers
+	return &redirected, originals
+}
+
+// capture writes one message per recipient to the capture store
+func (s *Sandbox) capture(message *sendpost.EmailMessageObject) ([]sendpost.EmailResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	responses := make([]sendpost.EmailResponse, 0, len(message.To))
+	for _, recipient := range message.To {
+		id, err := sandboxMessageID()
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "X-Sandbox-Message-ID: %s\r\n", id)
+		if err := RenderEML(&buf, message, recipient, now); err != nil {
+			return nil, err
+		}
+		if err := s.store(id, buf.Bytes(), message.From.GetEmail(), now); err != nil {
+			return nil, err
+		}
+
+		response := sendpost.NewEmailResponse()
+		response.SetTo(recipient.GetEmail())
+		response.SetMessageId(id)
+		response.SetSubmittedAt(now.UnixNano())
+		responses = append(responses, *response)
+	}
+	return responses, nil
+}
+
+// sandboxMessageID returns a random message ID marked as coming from the sandbox
+func sandboxMessageID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return sandboxMessagePrefix + hex.EncodeToString(b[:]), nil
+}
+
+// isMbox reports whether the capture store is an mbox file rather than a Maildir
+func (s *Sandbox) isMbox() bool {
+	return strings.HasSuffix(strings.ToLower(s.Path), ".mbox")
+}
+
+// store saves a captured message; the caller holds s.mu
+func (s *Sandbox) store(id string, data []byte, from string, at time.Time) error {
+	if s.isMbox() {
+		return appendMbox(s.Path, data, from, at)
+	}
+
+	// Maildir delivery: write to tmp, then rename into new
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(s.Path, sub), 0o700); err != nil {
+			return fmt.Errorf("could not create Maildir: %w", err)
+		}
+	}
+	hostname, _ := os.Hostname()
+	name := fmt.Sprintf("%d.%s.%s", at.Unix(), id, strings.NewReplacer("/", "_", ":", "_").Replace(hostname))
+	tmp := filepath.Join(s.Path, "tmp", name)
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("could not capture message: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(s.Path, "new", name)); err != nil {
+		return fmt.Errorf("could not capture message: %w", err)
+	}
+	return nil
+}
+
+// appendMbox appends a message to an mbox file in mboxrd format
+func appendMbox(path string, data []byte, from string, at time.Time) error {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return fmt.Errorf("could not create mbox directory: %w", err)
+		}
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("could not open mbox: %w", err)
+	}
+	defer file.Close()
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From %s %s\n", from, at.UTC().Format(time.ANSIC))
+	for _, line := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n") {
+		if strings.HasPrefix(strings.TrimLeft(line, ">"), "From ") {
+			line = ">" + line
+		}
+		buf.WriteString(line + "\n")
+	}
+	buf.WriteString("\n")
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("could not write mbox: %w", err)
+	}
+	return nil
+}
+
+// CapturedMessage is one message in the capture store
+type CapturedMessage struct {
+	ID      string
+	Date    time.Time
+	From    string
+	To      string
+	Subject string
+	Raw     []byte
+}
+
+// Messages reads the capture store, newest first
+func (s *Sandbox) Messages() ([]CapturedMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var raws [][]byte
+	if s.isMbox() {
+		data, err := os.ReadFile(s.Path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("could not read mbox: %w", err)
+		}
+		raws = splitMbox(data)
+	} else {
+		for _, sub := range []string{"new", "cur"} {
+			files, err := os.ReadDir(filepath.Join(s.Path, sub))
+			if err != nil && !os.IsNotExist(err) {
+				return nil, fmt.Errorf("could not read Maildir: %w", err)
+			}
+			for _, file := range files {
+				data, err := os.ReadFile(filepath.Join(s.Path, sub, file.Name()))
+				if err != nil {
+					return nil, fmt.Errorf("could not read Maildir: %w", err)
+				}
+				raws = append(raws, data)
+			}
+		}
+	}
+
+	messages := make([]CapturedMessage, 0, len(raws))
+	decoder := new(mime.WordDecoder)
+	for _, raw := range raws {
+		parsed, err := mail.ReadMessage(bytes.NewReader(raw))
+		if err != nil {
+			continue
+		}
+		decoded := func(name string) string {
+			value, err := decoder.DecodeHeader(parsed.Header.Get(name))
+			if err != nil {
+				return parsed.Header.Get(name)
+			}
+			return value
+		}
+		date, _ := parsed.Header.Date()
+		messages = append(messages, CapturedMessage{
+			ID:      parsed.Header.Get("X-Sandbox-Message-ID"),
+			Date:    date,
+			From:    decoded("From"),
+			To:      decoded("To"),
+			Subject: decoded("Subject"),
+			Raw:     raw,
+		})
+	}
+	sort.SliceStable(messages, func(i, j int) bool { return messages[i].Date.After(messages[j].Date) })
+	return messages, nil
+}
+
+// splitMbox splits an mboxrd file into messages, undoing the From quoting
+func splitMbox(data []byte) [][]byte {
+	var messages [][]byte
+	var current *bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "From ") {
+			if current != nil {
+				messages = append(messages, current.Bytes())
+			}
+			current = &bytes.Buffer{}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		if strings.HasPrefix(strings.TrimLeft(line, ">"), "From ") {
+			line = line[1:]
+		}
+		current.WriteString(line + "\r\n")
+	}
+	if current != nil {
+		messages = append(messages, current.Bytes())
+	}
+	return messages
+}
+
+// Clear removes every captured message
+func (s *Sandbox) Clear() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.isMbox() {
+		if err := os.Remove(s.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.RemoveAll(filepath.Join(s.Path, sub)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PrintMessages lists the captured messages
+func (s *Sandbox) PrintMessages() error {
+	messages, err := s.Messages()
+	if err != nil {
+		return err
+	}
+	if len(messages) == 0 {
+		fmt.Println("  No captured messages")
+		return nil
+	}
+	for _, message := range messages {
+		fmt.Printf("  %s  %s  %-30s  %s\n", formatTableTime(message.Date), message.ID, message.To, message.Subject)
+	}
+	return nil
+}
+
+// messageBodies extracts the text and HTML bodies of a captured message
+func messageBodies(raw []byte) (text, html string) {
+	parsed, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return "", ""
+	}
+	var walk func(contentType string, body io.Reader)
+	walk = func(contentType string, body io.Reader) {
+		mediaType, params, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return
+		}
+		if strings.HasPrefix(mediaType, "multipart/") {
+			reader := multipart.NewReader(body, params["boundary"])
+			for {
+				part, err := reader.NextPart()
+				if err != nil {
+					return
+				}
+				// multipart.Reader decodes quoted-printable parts itself
+				walk(part.Header.Get("Content-Type"), part)
+			}
+		}
+		data, _ := io.ReadAll(body)
+		switch mediaType {
+		case "text/plain":
+			if text == "" {
+				text = string(data)
+			}
+		case "text/html":
+			if html == "" {
+				html = string(data)
+			}
+		}
+	}
+
+	body := io.Reader(parsed.Body)
+	if strings.EqualFold(parsed.Header.Get("Content-Transfer-Encoding"), "quoted-printable") {
+		body = quotedprintable.NewReader(body)
+	}
+	walk(parsed.Header.Get("Content-Type"), body)
+	return text, html
+}
+
+// sandboxPage lists the captured messages and shows the selected one
+var sandboxPage = template.Must(template.New("sandbox").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>SendPost sandbox</title>
+<style>
+body { font-family: Arial, sans-serif; margin: 0; display: flex; height: 100vh; }
+nav { width: 38%; overflow-y: auto; border-right: 1px solid #ddd; }
+nav a { display: block; padding: 8px 12px; border-bottom: 1px solid #eee; color: #222; text-decoration: none; }
+nav a.selected { background: #e8f0fe; }
+nav small { color: #888; }
+main { flex: 1; display: flex; flex-direction: column; }
+header { padding: 12px; border-bottom: 1px solid #ddd; font-size: 14px; }
+iframe { flex: 1; border: 0; }
+pre { flex: 1; margin: 0; padding: 12px; overflow: auto; white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<nav>
+{{range .Messages}}<a href="/?id={{.ID}}"{{if eq .ID $.Selected.ID}} class="selected"{{end}}><strong>{{.Subject}}</strong><br>{{.To}}<br><small>{{.Date.Local.Format "2006-01-02 15:04:05 MST"}}</small></a>
+{{else}}<p style="padding: 12px">No captured messages</p>{{end}}
+</nav>
+<main>
+{{with .Selected}}<header><strong>{{.Subject}}</strong><br>From: {{.From}}<br>To: {{.To}}<br>
+<a href="/html?id={{.ID}}" target="_blank">HTML</a> · <a href="/raw?id={{.ID}}" target="_blank">Raw</a></header>
+{{if $.HTML}}<iframe sandbox src="/html?id={{.ID}}"></iframe>{{else}}<pre>{{$.Text}}</pre>{{end}}{{end}}
+</main>
+</body>
+</html>
+`))
+
+// Serve runs a small web UI for the captured messages until interrupted
+func (s *Sandbox) Serve(addr string) error {
+	find := func(r *http.Request) (CapturedMessage, []CapturedMessage, bool, error) {
+		messages, err := s.Messages()
+		if err != nil {
+			return CapturedMessage{}, nil, false, err
+		}
+		id := r.URL.Query().Get("id")
+		for _, message := range messages {
+			if id == "" || message.ID == id {
+				return message, messages, true, nil
+			}
+		}
+		return CapturedMessage{}, messages, false, nil
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		selected, messages, _, err := find(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		text, html := messageBodies(selected.Raw)
+		data := struct {
+			Messages []CapturedMessage
+			Selected CapturedMessage
+			Text     string
+			HTML     bool
+		}{messages, selected, text, html != ""}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		sandboxPage.Execute(w, data)
+	})
+	mux.HandleFunc("/html", func(w http.ResponseWriter, r *http.Request) {
+		selected, _, ok, err := find(r)
+		if err != nil || !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, html := messageBodies(selected.Raw)
+		// Captured HTML is untrusted; the sandbox CSP keeps its scripts from running
+		w.Header().Set("Content-Security-Policy", "sandbox")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, html)
+	})
+	mux.HandleFunc("/raw", func(w http.ResponseWriter, r *http.Request) {
+		selected, _, ok, err := find(r)
+		if err != nil || !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(selected.Raw)
+	})
+
+	server := &http.Server{Addr: addr, Handler: mux}
+	stopCtx, stop := interruptContext("stopping the sandbox UI")
+	defer stop()
+	go func() {
+		<-stopCtx.Done()
+		server.Close()
+	}()
+
+	fmt.Printf("Serving captured messages from %s at http://%s (Ctrl+C to stop)\n", s.Path, addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	fmt.Println("✓ Sandbox UI stopped")
+	return nil
+}
+
+// openQueue opens the send queue in dir, or its sandbox counterpart in sandbox mode
+func (e *ESPExample) openQueue(dir string) (*SendQueue, error) {
+	return OpenSendQueue(e.sandbox.statePath(dir))
+}
+
+// deliver performs one SendEmail request, through the sandbox when it is enabled, and
+// records the responses in the send log
+func (e *ESPExample) deliver(ctx context.Context, message *sendpost.EmailMessageObject) ([]sendpost.EmailResponse, *http.Response, error) {
+	send := func(ctx context.Context, message *sendpost.EmailMessageObject) ([]sendpost.EmailResponse, *http.Response, error) {
+		return e.client.EmailAPI.SendEmail(ctx).EmailMessageObject(*message).Execute()
+	}
+
+	var responses []sendpost.EmailResponse
+	var resp *http.Response
+	var err error
+	if e.sandbox == nil {
+		responses, resp, err = send(ctx, message)
+	} else {
+		responses, resp, err = e.sandbox.Send(ctx, message, send)
+	}
+	if err == nil {
+		if logErr := e.sendLog.Record(message, responses); logErr != nil {
+			fmt.Printf("⚠️  %v\n", logErr)
+		}
+	}
+	return responses, resp, err
+}
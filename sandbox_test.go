@@ -0,0 +1,57 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSandboxStatePath(t *testing.T) {
+	sandbox := &Sandbox{Mode: sandboxCapture, StateDir: "state"}
+	tests := []struct {
+		sandbox *Sandbox
+		path    string
+		want    string
+	}{
+		{nil, ".sendpost-queue", ".sendpost-queue"},
+		{sandbox, ".sendpost-queue", filepath.Join("state", ".sendpost-queue")},
+		{sandbox, "/var/lib/sendpost/queue/", filepath.Join("state", "queue")},
+		{sandbox, "data/.sendpost-idempotency.jsonl", filepath.Join("state", ".sendpost-idempotency.jsonl")},
+		{sandbox, filepath.Join("state", ".sendpost-queue"), filepath.Join("state", ".sendpost-queue")},
+	}
+	for _, tt := range tests {
+		if got := tt.sandbox.statePath(tt.path); got != tt.want {
+			t.Errorf("statePath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestSandboxRunLeavesRealQueueAlone(t *testing.T) {
+	e := newTestExample(t, jsonHandler(500, `{"error":"the sandbox must not call SendEmail"}`))
+	message := testMessage(t, "a@example.com")
+
+	// A real request waits in the production queue
+	production := openTestQueue(t, e.queueDir)
+	id, _, _ := production.Enqueue(message)
+	production.Close()
+
+	// The same request is rehearsed in capture mode and reported sent
+	e.sandbox = &Sandbox{Mode: sandboxCapture, Path: t.TempDir(), StateDir: t.TempDir()}
+	rehearsal, err := e.openQueue(e.queueDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rehearsal.Entries()) != 0 {
+		t.Fatal("the sandbox queue sees the production queue")
+	}
+	rehearsal.Enqueue(message)
+	_, stats := e.RunQueue(rehearsal, PoolOptions{}, immediate)
+	rehearsal.Close()
+	if stats.Sent != 1 {
+		t.Fatalf("sandbox run stats = %+v, want one captured send", stats)
+	}
+
+	entry, _ := openTestQueue(t, e.queueDir).Entry(id)
+	if entry.Status != statusPending {
+		t.Errorf("production request is %s after a sandbox run, want pending", entry.Status)
+	}
+}
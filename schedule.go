@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	sendpost "github.com/sendpost/sendpost-go-sdk"
+)
+
+// defaultSchedulerInterval is how often the scheduler checks the queue for due requests
+const defaultSchedulerInterval = 30 * time.Second
+
+// sendAtLayouts are the accepted wall-clock formats for --send-at, interpreted in the
+// recipient's (or the local) time zone
+var sendAtLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+}
+
+// resolveSendAt parses a --send-at value
+// It accepts an RFC 3339 timestamp ("2026-11-02T09:00:00+01:00"), a wall-clock time
+// without offset ("2026-11-02 09:00") interpreted in loc, or a delay from now ("+2h30m").
+func resolveSendAt(value string, loc *time.Location, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "+") {
+		delay, err := time.ParseDuration(value[1:])
+		if err != nil || delay <= 0 {
+			return time.Time{}, fmt.Errorf("invalid send delay %q: use a positive duration such as +2h30m", value)
+		}
+		return now.Add(delay), nil
+	}
+	if at, err := time.Parse(time.RFC3339, value); err == nil {
+		return at, nil
+	}
+	for _, layout := range sendAtLayouts {
+		if at, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return at, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid send time %q: use RFC 3339, \"YYYY-MM-DD HH:MM\" or +duration", value)
+}
+
+// parseSendAtFlag resolves a --send-at value in the local time zone and rejects past times
+func parseSendAtFlag(value string) (time.Time, error) {
+	now := time.Now()
+	at, err := resolveSendAt(value, time.Local, now)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if at.Before(now) {
+		return time.Time{}, fmt.Errorf("send time %s is in the past", formatTime(at))
+	}
+	return at, nil
+}
+
+// sendAtHasZone reports whether a --send-at value fixes the instant regardless of time zone
+func sendAtHasZone(value string) bool {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "+") {
+		return true
+	}
+	_, err := time.Parse(time.RFC3339, value)
+	return err == nil
+}
+
+// recipientLocation returns the time zone named by a recipient's custom field, or
+// time.Local when field is empty or the recipient has no such field
+func recipientLocation(recipient sendpost.Recipient, field string) (*time.Location, error) {
+	if field == "" {
+		return time.Local, nil
+	}
+	name, ok := recipient.GetCustomFields()[field].(string)
+	if !ok || strings.TrimSpace(name) == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(strings.TrimSpace(name))
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q in field %s", name, field)
+	}
+	return loc, nil
+}
+
+// scheduleEmail puts a built message in the send queue for the scheduler to send at sendAt
+// and reports whether it is queued
+func (e *ESPExample) scheduleEmail(emailMessage *sendpost.EmailMessageObject, sendAt time.Time) bool {
+	queue, err := e.openQueue(e.queueDir)
+	if err != nil {
+		fmt.Printf("✗ Failed to schedule email:\n")
+		fmt.Printf("  Error: %v\n", err)
+		return false
+	}
+	defer queue.Close()
+
+	id, added, err := queue.EnqueueAt(emailMessage, sendAt)
+	if err != nil {
+		fmt.Printf("✗ Failed to schedule email:\n")
+		fmt.Printf("  Error: %v\n", err)
+		return false
+	}
+	if !added {
+		entry, _ := queue.Entry(id)
+		fmt.Printf("⚠️  This email is already queued as %s (%s)\n", id, entry.Status)
+		return true
+	}
+
+	fmt.Println("✓ Email scheduled successfully!")
+	fmt.Printf("  Request ID: %s\n", id)
+	fmt.Printf("  Send At: %s\n", formatTime(sendAt))
+	fmt.Println("  The scheduler sends it when due: go run . schedule run")
+	return true
+}
+
+// due selects scheduled requests whose send time has passed
+func due(now time.Time) func(QueueEntry) bool {
+	return func(entry QueueEntry) bool {
+		return !entry.SendAt.IsZero() && !entry.SendAt.After(now)
+	}
+}
+
+// RunScheduler sends scheduled requests as they become due until interrupted
+// The queue is reopened on every check so requests scheduled, cancelled or rescheduled by
+// other commands are picked up. With once set it checks a single time and returns, which
+// suits running it from cron.
+func (e *ESPExample) RunScheduler(queueDir string, pool PoolOptions, interval time.Duration, once bool) error {
+	fmt.Println("\n=== Scheduler ===")
+	if interval <= 0 {
+		interval = defaultSchedulerInterval
+	}
+	queueDir = e.sandbox.statePath(queueDir)
+
+	stopCtx, stop := interruptContext("stopping the scheduler")
+	defer stop()
+
+	if !once {
+		fmt.Printf("Checking %s every %s (Ctrl+C to stop)\n", queueDir, interval)
+	}
+	for {
+		queue, err := e.openQueue(queueDir)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		dueCount := 0
+		for _, entry := range queue.Entries(statusPending) {
+			if due(now)(entry) {
+				dueCount++
+			}
+		}
+		if dueCount > 0 {
+			_, stats := e.RunQueue(queue, pool, due(now))
+			stats.Print()
+		}
+		next := nextScheduled(queue, now)
+		queue.Close()
+
+		if once {
+			return nil
+		}
+		if dueCount > 0 && !next.IsZero() {
+			fmt.Printf("  Next scheduled send: %s\n", formatTime(next))
+		}
+
+		select {
+		case <-stopCtx.Done():
+			fmt.Println("✓ Scheduler stopped")
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// nextScheduled returns the earliest send time of the pending scheduled requests after now
+func nextScheduled(queue *SendQueue, now time.Time) time.Time {
+	var next time.Time
+	for _, entry := range queue.Entries(statusPending) {
+		if entry.SendAt.After(now) && (next.IsZero() || entry.SendAt.Before(next)) {
+			next = entry.SendAt
+		}
+	}
+	return next
+}
+
+// PrintSchedule lists scheduled requests that have not been sent yet
+func (q *SendQueue) PrintSchedule(includeCancelled bool) {
+	statuses := []queueStatus{statusPending}
+	if includeCancelled {
+		statuses = append(statuses, statusCancelled)
+	}
+
+	count := 0
+	for _, entry := range q.Entries(statuses...) {
+		if entry.SendAt.IsZero() {
+			continue
+		}
+		count++
+		fmt.Printf("  %s  %s  %-9s  %d recipient(s)  %s\n",
+			entry.ID, formatTableTime(entry.SendAt), entry.Status,
+			len(entry.Message.To), entry.Message.GetSubject())
+	}
+	if count == 0 {
+		fmt.Println("  No scheduled sends")
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	sendpost "github.com/sendpost/sendpost-go-sdk"
+)
+
+func TestResolveSendAt(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr string
+	}{
+		{"+2h30m", now.Add(150 * time.Minute), ""},
+		{" +45m ", now.Add(45 * time.Minute), ""},
+		{"2026-11-02T09:00:00+01:00", time.Date(2026, 11, 2, 8, 0, 0, 0, time.UTC), ""},
+		{"2026-11-02T09:00:00Z", time.Date(2026, 11, 2, 9, 0, 0, 0, time.UTC), ""},
+		{"2026-11-02 09:00", time.Date(2026, 11, 2, 8, 0, 0, 0, time.UTC), ""},
+		{"2026-11-02 09:00:30", time.Date(2026, 11, 2, 8, 0, 30, 0, time.UTC), ""},
+		{"2026-07-02T09:00", time.Date(2026, 7, 2, 7, 0, 0, 0, time.UTC), ""},
+		{"+0s", time.Time{}, "invalid send delay"},
+		{"+-1h", time.Time{}, "invalid send delay"},
+		{"+soon", time.Time{}, "invalid send delay"},
+		{"tomorrow", time.Time{}, "invalid send time"},
+		{"2026-11-02", time.Time{}, "invalid send time"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := resolveSendAt(tt.value, berlin, now)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("resolveSendAt() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || !got.Equal(tt.want) {
+				t.Errorf("resolveSendAt() = %s, %v; want %s", got.UTC(), err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSendAtFlagRejectsPast(t *testing.T) {
+	if _, err := parseSendAtFlag("2000-01-01T00:00:00Z"); err == nil || !strings.Contains(err.Error(), "in the past") {
+		t.Errorf("parseSendAtFlag(past) error = %v", err)
+	}
+	if _, err := parseSendAtFlag("+1h"); err != nil {
+		t.Errorf("parseSendAtFlag(+1h) error = %v", err)
+	}
+}
+
+func TestSendAtHasZone(t *testing.T) {
+	tests := map[string]bool{
+		"+2h":                       true,
+		"2026-11-02T09:00:00+01:00": true,
+		"2026-11-02 09:00":          false,
+		"2026-11-02T09:00":          false,
+	}
+	for value, want := range tests {
+		if got := sendAtHasZone(value); got != want {
+			t.Errorf("sendAtHasZone(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestRecipientLocation(t *testing.T) {
+	recipient := func(fields map[string]interface{}) sendpost.Recipient {
+		r := sendpost.NewRecipient()
+		r.SetEmail("a@example.com")
+		r.SetCustomFields(fields)
+		return *r
+	}
+	tests := []struct {
+		name      string
+		recipient sendpost.Recipient
+		field     string
+		want      string
+		wantErr   bool
+	}{
+		{"no field configured", recipient(map[string]interface{}{"tz": "Asia/Tokyo"}), "", time.Local.String(), false},
+		{"zone from field", recipient(map[string]interface{}{"tz": "Asia/Tokyo"}), "tz", "Asia/Tokyo", false},
+		{"missing field", recipient(nil), "tz", time.Local.String(), false},
+		{"blank field", recipient(map[string]interface{}{"tz": " "}), "tz", time.Local.String(), false},
+		{"unknown zone", recipient(map[string]interface{}{"tz": "Mars/Olympus"}), "tz", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loc, err := recipientLocation(tt.recipient, tt.field)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("recipientLocation() = %v, want an error", loc)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if loc.String() != tt.want {
+				t.Errorf("recipientLocation() = %s, want %s", loc, tt.want)
+			}
+		})
+	}
+}
+
+func TestDue(t *testing.T) {
+	now := time.Now()
+	isDue := due(now)
+	tests := []struct {
+		name   string
+		sendAt time.Time
+		want   bool
+	}{
+		{"not scheduled", time.Time{}, false},
+		{"past", now.Add(-time.Minute), true},
+		{"exactly now", now, true},
+		{"future", now.Add(time.Minute), false},
+	}
+	for _, tt := range tests {
+		if got := isDue(QueueEntry{SendAt: tt.sendAt}); got != tt.want {
+			t.Errorf("%s: due() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	sendpost "github.com/sendpost/sendpost-go-sdk"
+)
+
+// domainCacheTTL controls how long the verified domain list of a sub-account is reused
+// before GetAllDomains is called again
+const domainCacheTTL = 5 * time.Minute
+
+// domainCacheEntry holds the domains of one sub-account as returned by GetAllDomains
+type domainCacheEntry struct {
+	verified  map[string]bool
+	fetchedAt time.Time
+}
+
+// senderValidator checks from addresses against the verified domains of a sub-account
+type senderValidator struct {
+	client  *sendpost.APIClient
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]domainCacheEntry
+}
+
+// newSenderValidator creates a validator with an empty domain cache
+func newSenderValidator(client *sendpost.APIClient) *senderValidator {
+	return &senderValidator{
+		client:  client,
+		ttl:     domainCacheTTL,
+		entries: make(map[string]domainCacheEntry),
+	}
+}
+
+// Invalidate drops the cached domains for a sub-account so the next validation refetches them
+func (v *senderValidator) Invalidate(subAccountAPIKey string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.entries, subAccountAPIKey)
+}
+
+// Store replaces the cached domains for a sub-account with an already retrieved domain list
+func (v *senderValidator) Store(subAccountAPIKey string, domains []sendpost.Domain) {
+	verified := make(map[string]bool, len(domains))
+	for _, domain := range domains {
+		if domain.Name == nil {
+			continue
+		}
+		verified[strings.ToLower(*domain.Name)] = domain.Verified != nil && *domain.Verified
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.entries[subAccountAPIKey] = domainCacheEntry{verified: verified, fetchedAt: time.Now()}
+}
+
+// domains returns the cached domain list for a sub-account, fetching it when missing or expired
+func (v *senderValidator) domains(ctx context.Context, subAccountAPIKey string) (map[string]bool, error) {
+	v.mu.Lock()
+	entry, ok := v.entries[subAccountAPIKey]
+	v.mu.Unlock()
+	if ok && time.Since(entry.fetchedAt) < v.ttl {
+		return entry.verified, nil
+	}
+
+	domains, resp, err := v.client.DomainAPI.GetAllDomains(ctx).Execute()
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("could not list domains (status %d): %w", resp.StatusCode, err)
+		}
+		return nil, fmt.Errorf("could not list domains: %w", err)
+	}
+
+	v.Store(subAccountAPIKey, domains)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.entries[subAccountAPIKey].verified, nil
+}
+
+// Validate returns an error naming the fix when the domain of fromEmail is not a verified
+// sending domain of the sub-account authenticated by ctx; source names where fromEmail was
+// set (a flag, a campaign or a header), so the error says what to change
+func (v *senderValidator) Validate(ctx context.Context, subAccountAPIKey, fromEmail, source string) error {
+	at := strings.LastIndex(fromEmail, "@")
+	if at <= 0 || at == len(fromEmail)-1 {
+		return fmt.Errorf("sender address %q from %s is not a valid email address", fromEmail, source)
+	}
+	domainName := strings.ToLower(fromEmail[at+1:])
+
+	domains, err := v.domains(ctx, subAccountAPIKey)
+	if err != nil {
+		return fmt.Errorf("could not validate sender %q: %w", fromEmail, err)
+	}
+
+	verified, known := domains[domainName]
+	if !known {
+		return fmt.Errorf("domain %q of sender %s is not added to this sub-account; add it with AddDomain (or the SendPost dashboard), publish its DNS records, or change %s to an address on a verified domain", domainName, fromEmail, source)
+	}
+	if !verified {
+		return fmt.Errorf("domain %q of sender %s is added but not verified yet; publish the DKIM/SPF/return-path DNS records shown by AddDomain and wait for verification, or change %s to an address on a verified domain", domainName, fromEmail, source)
+	}
+	return nil
+}
+
+// validateSender checks the from address against the verified domains of the configured
+// sub-account and prints the reason when it cannot be used; source is passed to Validate
+// Dry runs check it too: listing domains is read-only, and a preview should fail the
+// same way the send would.
+func (e *ESPExample) validateSender(fromEmail, source string) bool {
+	ctx := e.createSubAccountAuthContext()
+
+	if err := e.senders.Validate(ctx, e.subAccountAPIKey, fromEmail, source); err != nil {
+		fmt.Printf("✗ Sender validation failed:\n")
+		fmt.Printf("  From: %s\n", fromEmail)
+		fmt.Printf("  Error: %v\n", err)
+		return false
+	}
+	return true
+}
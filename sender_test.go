@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestValidateSender(t *testing.T) {
+	tests := []struct {
+		name    string
+		domains string
+		from    string
+		want    bool
+	}{
+		{"verified domain", `[{"id":1,"name":"example.com","verified":true}]`, "news@example.com", true},
+		{"case-insensitive domain", `[{"id":1,"name":"example.com","verified":true}]`, "news@Example.COM", true},
+		{"unverified domain", `[{"id":1,"name":"example.com","verified":false}]`, "news@example.com", false},
+		{"unknown domain", `[{"id":1,"name":"example.com","verified":true}]`, "news@other.com", false},
+		{"invalid address", `[]`, "not-an-address", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestExample(t, jsonHandler(200, tt.domains))
+			if got := e.validateSender(tt.from, "--from"); got != tt.want {
+				t.Errorf("validateSender(%s) = %v, want %v", tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSenderErrorNamesSource(t *testing.T) {
+	e := newTestExample(t, jsonHandler(200, `[{"id":1,"name":"example.com","verified":false}]`))
+	for _, from := range []string{"news@example.com", "news@other.com", "not-an-address"} {
+		err := e.senders.Validate(context.Background(), e.subAccountAPIKey, from, "--from")
+		if err == nil || !strings.Contains(err.Error(), from) || !strings.Contains(err.Error(), "--from") {
+			t.Errorf("Validate(%s) error = %v, want it to name the sender and --from", from, err)
+		}
+	}
+}
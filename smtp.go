@@ -0,0 +1,644 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SMTP relay settings
+const (
+	defaultSMTPAddr          = "127.0.0.1:2525"
+	defaultSMTPMaxSize       = 25 * 1024 * 1024
+	defaultSMTPMaxRecipients = 100
+	// maxSMTPRecipients caps --max-recipients; a relayed message goes to all of its
+	// envelope recipients in one SendEmail request
+	maxSMTPRecipients   = 100
+	smtpCommandTimeout  = 5 * time.Minute
+	smtpMaxLineLength   = 4096
+	smtpMaxAuthFailures = 3
+)
+
+// SMTPOptions configures the SMTP relay
+type SMTPOptions struct {
+	Addr     string
+	Hostname string
+	// TLSConfig enables STARTTLS; AUTH is then only accepted on an encrypted connection
+	TLSConfig *tls.Config
+	// Insecure accepts AUTH over a plain connection when STARTTLS is not configured
+	Insecure      bool
+	MaxSize       int64
+	MaxRecipients int
+	// Users maps SMTP usernames to their password and sub-account API key; when empty,
+	// the AUTH password is used as the sub-account API key
+	Users map[string]SMTPUser
+}
+
+// SMTPUser is one entry of the relay's users file
+type SMTPUser struct {
+	Password         string
+	SubAccountAPIKey string
+}
+
+// LoadSMTPUsers reads a users file with one "username:password:sub_account_api_key"
+// entry per line; blank lines and lines starting with # are ignored
+func LoadSMTPUsers(path string) (map[string]SMTPUser, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read SMTP users file: %w", err)
+	}
+	users := map[string]SMTPUser{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("%s line %d must be username:password:sub_account_api_key", path, i+1)
+		}
+		users[parts[0]] = SMTPUser{Password: parts[1], SubAccountAPIKey: parts[2]}
+	}
+	if len(users) == 0 {
+		return nil, fmt.Errorf("%s has no users", path)
+	}
+	return users, nil
+}
+
+// errSMTPLineTooLong reports a command line longer than smtpMaxLineLength
+var errSMTPLineTooLong = errors.New("line too long")
+
+// smtpError is an SMTP reply that ends a command unsuccessfully
+type smtpError struct {
+	code     int
+	enhanced string
+	text     string
+}
+
+func (e *smtpError) Error() string {
+	return fmt.Sprintf("%d %s %s", e.code, e.enhanced, e.text)
+}
+
+// smtpSession is the state of one client connection
+type smtpSession struct {
+	relay  *SMTPRelay
+	conn   net.Conn
+	buf    *bufio.Reader
+	reader *textproto.Reader
+	writer *bufio.Writer
+	tls    bool
+	helo   string
+
+	user         string
+	apiKey       string
+	authFailures int
+
+	from       string
+	recipients []string
+}
+
+// SMTPRelay accepts mail over SMTP and forwards it through EmailAPI.SendEmail
+type SMTPRelay struct {
+	e        *ESPExample
+	opts     SMTPOptions
+	listener net.Listener
+	wg       sync.WaitGroup
+}
+
+// RunSMTPRelay serves SMTP until interrupted; connections in progress are allowed to finish
+func (e *ESPExample) RunSMTPRelay(opts SMTPOptions) error {
+	fmt.Println("\n=== SMTP Relay ===")
+	if opts.TLSConfig == nil && !opts.Insecure {
+		return fmt.Errorf("STARTTLS needs --tls-cert and --tls-key; use --insecure to accept AUTH without TLS")
+	}
+	if opts.Hostname == "" {
+		opts.Hostname, _ = os.Hostname()
+	}
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = defaultSMTPMaxSize
+	}
+	if opts.MaxRecipients <= 0 || opts.MaxRecipients > maxSMTPRecipients {
+		opts.MaxRecipients = defaultSMTPMaxRecipients
+	}
+
+	listener, err := net.Listen("tcp", opts.Addr)
+	if err != nil {
+		return err
+	}
+	relay := &SMTPRelay{e: e, opts: opts, listener: listener}
+
+	stopCtx, stop := interruptContext("finishing open SMTP sessions")
+	defer stop()
+	go func() {
+		<-stopCtx.Done()
+		listener.Close()
+	}()
+
+	fmt.Printf("Listening on %s (STARTTLS: %t, max size %d KB, Ctrl+C to stop)\n", listener.Addr(), opts.TLSConfig != nil, opts.MaxSize/1024)
+	if len(opts.Users) == 0 {
+		fmt.Println("  AUTH password is used as the sub-account API key")
+	} else {
+		fmt.Printf("  %d user(s) mapped to sub-account API keys\n", len(opts.Users))
+	}
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if stopCtx.Err() != nil {
+				break
+			}
+			return err
+		}
+		relay.wg.Add(1)
+		go func() {
+			defer relay.wg.Done()
+			relay.serve(conn)
+		}()
+	}
+	relay.wg.Wait()
+	fmt.Println("✓ SMTP relay stopped")
+	return nil
+}
+
+// serve runs one SMTP session
+func (r *SMTPRelay) serve(conn net.Conn) {
+	defer conn.Close()
+	s := &smtpSession{relay: r}
+	s.setConn(conn)
+
+	s.reply(220, "", r.opts.Hostname+" ESMTP SendPost relay")
+	for {
+		s.conn.SetReadDeadline(time.Now().Add(smtpCommandTimeout))
+		line, err := s.readLine()
+		if err == errSMTPLineTooLong {
+			s.reply(500, "5.5.2", "Line too long")
+			continue
+		}
+		if err != nil {
+			return
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			s.helo = arg
+			s.reset()
+			s.reply(250, "", r.opts.Hostname)
+		case "EHLO":
+			s.helo = arg
+			s.reset()
+			s.ehlo()
+		case "STARTTLS":
+			s.startTLS()
+		case "AUTH":
+			if !s.auth(arg) {
+				return
+			}
+		case "MAIL":
+			s.mail(arg)
+		case "RCPT":
+			s.rcpt(arg)
+		case "DATA":
+			if !s.data() {
+				return
+			}
+		case "RSET":
+			s.reset()
+			s.reply(250, "2.0.0", "OK")
+		case "NOOP":
+			s.reply(250, "2.0.0", "OK")
+		case "VRFY":
+			s.reply(252, "2.5.0", "Cannot verify the user, but will try delivery")
+		case "QUIT":
+			s.reply(221, "2.0.0", "Bye")
+			return
+		default:
+			s.reply(502, "5.5.1", "Command not implemented")
+		}
+	}
+}
+
+// setConn (re)wraps the connection, as after STARTTLS
+func (s *smtpSession) setConn(conn net.Conn) {
+	s.conn = conn
+	s.buf = bufio.NewReader(conn)
+	s.reader = textproto.NewReader(s.buf)
+	s.writer = bufio.NewWriter(conn)
+}
+
+// readLine reads one command line without its line ending
+// At most smtpMaxLineLength bytes are kept; the rest of a longer line is read and
+// discarded so the session stays in sync, and errSMTPLineTooLong is returned.
+func (s *smtpSession) readLine() (string, error) {
+	var line []byte
+	tooLong := false
+	for {
+		chunk, err := s.buf.ReadSlice('\n')
+		if !tooLong && len(line)+len(chunk) > smtpMaxLineLength+len("\r\n") {
+			tooLong, line = true, nil
+		}
+		if !tooLong {
+			line = append(line, chunk...)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		break
+	}
+	if tooLong {
+		return "", errSMTPLineTooLong
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// reply writes a single-line reply; enhanced may be empty
+func (s *smtpSession) reply(code int, enhanced, text string) {
+	text = strings.Join(strings.Fields(text), " ")
+	if enhanced != "" {
+		text = enhanced + " " + text
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(smtpCommandTimeout))
+	fmt.Fprintf(s.writer, "%d %s\r\n", code, text)
+	s.writer.Flush()
+}
+
+// replyError writes an smtpError
+func (s *smtpSession) replyError(err *smtpError) {
+	s.reply(err.code, err.enhanced, err.text)
+}
+
+// reset clears the current transaction, keeping the authentication
+func (s *smtpSession) reset() {
+	s.from = ""
+	s.recipients = nil
+}
+
+// ehlo announces the supported extensions
+func (s *smtpSession) ehlo() {
+	lines := []string{s.relay.opts.Hostname, "PIPELINING", "8BITMIME", "ENHANCEDSTATUSCODES", "SIZE " + strconv.FormatInt(s.relay.opts.MaxSize, 10)}
+	if s.relay.opts.TLSConfig != nil && !s.tls {
+		lines = append(lines, "STARTTLS")
+	}
+	if s.authAllowed() {
+		lines = append(lines, "AUTH PLAIN LOGIN")
+	}
+	for i, line := range lines {
+		separator := "-"
+		if i == len(lines)-1 {
+			separator = " "
+		}
+		fmt.Fprintf(s.writer, "250%s%s\r\n", separator, line)
+	}
+	s.writer.Flush()
+}
+
+// authAllowed reports whether credentials may be sent on this connection
+func (s *smtpSession) authAllowed() bool {
+	return s.tls || s.relay.opts.TLSConfig == nil && s.relay.opts.Insecure
+}
+
+// startTLS upgrades the connection
+func (s *smtpSession) startTLS() {
+	if s.relay.opts.TLSConfig == nil {
+		s.reply(502, "5.5.1", "STARTTLS not available")
+		return
+	}
+	if s.tls {
+		s.reply(503, "5.5.1", "Already using TLS")
+		return
+	}
+	s.reply(220, "2.0.0", "Ready to start TLS")
+
+	tlsConn := tls.Server(s.conn, s.relay.opts.TLSConfig)
+	tlsConn.SetDeadline(time.Now().Add(smtpCommandTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		s.conn.Close()
+		return
+	}
+	tlsConn.SetDeadline(time.Time{})
+
+	// RFC 3207: the session starts over after the handshake
+	s.setConn(tlsConn)
+	s.tls = true
+	s.helo, s.user, s.apiKey = "", "", ""
+	s.reset()
+}
+
+// auth handles AUTH PLAIN and AUTH LOGIN; it returns false when the connection is closed
+// after too many failed attempts
+func (s *smtpSession) auth(arg string) bool {
+	if !s.authAllowed() {
+		s.reply(538, "5.7.11", "Encryption required: use STARTTLS first")
+		return true
+	}
+	if s.apiKey != "" {
+		s.reply(503, "5.5.1", "Already authenticated")
+		return true
+	}
+
+	mechanism, initial, _ := strings.Cut(arg, " ")
+	var username, password string
+	switch strings.ToUpper(mechanism) {
+	case "PLAIN":
+		response, ok := s.authResponse(initial, "")
+		if !ok {
+			return true
+		}
+		// authzid NUL authcid NUL password
+		fields := strings.Split(response, "\x00")
+		if len(fields) != 3 {
+			s.reply(501, "5.5.2", "Malformed AUTH PLAIN response")
+			return true
+		}
+		username, password = fields[1], fields[2]
+	case "LOGIN":
+		var ok bool
+		if username, ok = s.authResponse(initial, "Username:"); !ok {
+			return true
+		}
+		if password, ok = s.authResponse("", "Password:"); !ok {
+			return true
+		}
+	default:
+		s.reply(504, "5.5.4", "Unrecognized authentication mechanism")
+		return true
+	}
+
+	apiKey, err := s.relay.authenticate(username, password)
+	if err != nil {
+		if err.code == 535 {
+			s.authFailures++
+		}
+		if s.authFailures >= smtpMaxAuthFailures {
+			s.reply(421, "4.7.0", "Too many failed authentication attempts, closing connection")
+			return false
+		}
+		s.replyError(err)
+		return true
+	}
+	s.user, s.apiKey = username, apiKey
+	s.reply(235, "2.7.0", "Authentication successful")
+	return true
+}
+
+// authResponse returns a decoded SASL response, prompting for it when it was not given
+// with the AUTH command
+func (s *smtpSession) authResponse(initial, prompt string) (string, bool) {
+	if initial == "" {
+		s.reply(334, "", base64.StdEncoding.EncodeToString([]byte(prompt)))
+		line, err := s.readLine()
+		if err == errSMTPLineTooLong {
+			s.reply(500, "5.5.2", "Line too long")
+			return "", false
+		}
+		if err != nil {
+			return "", false
+		}
+		if line == "*" {
+			s.reply(501, "5.0.0", "Authentication cancelled")
+			return "", false
+		}
+		initial = line
+	}
+	decoded, err := base64.StdEncoding.DecodeString(initial)
+	if err != nil {
+		s.reply(501, "5.5.2", "Invalid base64 in AUTH response")
+		return "", false
+	}
+	return string(decoded), true
+}
+
+// authenticate maps SMTP credentials to a sub-account API key
+// Without a users file the password is the API key, checked by listing the sub-account's
+// domains, which also primes the sender validation cache.
+func (r *SMTPRelay) authenticate(username, password string) (string, *smtpError) {
+	invalid := &smtpError{535, "5.7.8", "Authentication credentials invalid"}
+	if len(r.opts.Users) > 0 {
+		user, ok := r.opts.Users[username]
+		if !ok || subtle.ConstantTimeCompare([]byte(user.Password), []byte(password)) != 1 {
+			return "", invalid
+		}
+		return user.SubAccountAPIKey, nil
+	}
+
+	domains, resp, err := r.e.client.DomainAPI.GetAllDomains(subAccountContext(password)).Execute()
+	if err != nil {
+		if resp != nil && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+			return "", invalid
+		}
+		return "", &smtpError{454, "4.7.0", "Temporary authentication failure"}
+	}
+	r.e.senders.Store(password, domains)
+	return password, nil
+}
+
+// pathAddress extracts the address from a MAIL FROM:<...> or RCPT TO:<...> argument,
+// ignoring ESMTP parameters other than SIZE
+func pathAddress(arg, prefix string) (string, map[string]string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", nil, false
+	}
+	rest := strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(rest, "<") {
+		return "", nil, false
+	}
+	end := strings.Index(rest, ">")
+	if end < 0 {
+		return "", nil, false
+	}
+	params := map[string]string{}
+	for _, param := range strings.Fields(rest[end+1:]) {
+		key, value, _ := strings.Cut(param, "=")
+		params[strings.ToUpper(key)] = value
+	}
+	return rest[1:end], params, true
+}
+
+// mail starts a transaction
+func (s *smtpSession) mail(arg string) {
+	if s.apiKey == "" {
+		s.reply(530, "5.7.0", "Authentication required")
+		return
+	}
+	if s.from != "" {
+		s.reply(503, "5.5.1", "Nested MAIL command")
+		return
+	}
+	from, params, ok := pathAddress(arg, "FROM:")
+	if !ok {
+		s.reply(501, "5.5.4", "Syntax: MAIL FROM:<address>")
+		return
+	}
+	if size, err := strconv.ParseInt(params["SIZE"], 10, 64); err == nil && size > s.relay.opts.MaxSize {
+		s.reply(552, "5.3.4", "Message size exceeds fixed limit")
+		return
+	}
+	if from == "" {
+		s.reply(550, "5.7.1", "Null sender not accepted")
+		return
+	}
+	if _, err := mail.ParseAddress(from); err != nil {
+		s.reply(553, "5.1.7", "Invalid sender address")
+		return
+	}
+	s.from = from
+	s.reply(250, "2.1.0", "Sender OK")
+}
+
+// rcpt adds a recipient to the transaction
+func (s *smtpSession) rcpt(arg string) {
+	if s.from == "" {
+		s.reply(503, "5.5.1", "Need MAIL command first")
+		return
+	}
+	to, _, ok := pathAddress(arg, "TO:")
+	if !ok {
+		s.reply(501, "5.5.4", "Syntax: RCPT TO:<address>")
+		return
+	}
+	if _, err := mail.ParseAddress(to); err != nil {
+		s.reply(553, "5.1.3", "Invalid recipient address")
+		return
+	}
+	if len(s.recipients) >= s.relay.opts.MaxRecipients {
+		s.reply(452, "4.5.3", "Too many recipients")
+		return
+	}
+	s.recipients = append(s.recipients, to)
+	s.reply(250, "2.1.5", "Recipient OK")
+}
+
+// data reads the message and sends it; it returns false when the connection is unusable
+func (s *smtpSession) data() bool {
+	if s.from == "" || len(s.recipients) == 0 {
+		s.reply(503, "5.5.1", "Need MAIL and RCPT commands first")
+		return true
+	}
+	s.reply(354, "", "End data with <CR><LF>.<CR><LF>")
+
+	// Read up to one byte past the limit, then drain the rest so the session stays in sync
+	s.conn.SetReadDeadline(time.Now().Add(smtpCommandTimeout))
+	dot := s.reader.DotReader()
+	body, err := io.ReadAll(io.LimitReader(dot, s.relay.opts.MaxSize+1))
+	if err != nil {
+		return false
+	}
+	if int64(len(body)) > s.relay.opts.MaxSize {
+		if _, err := io.Copy(io.Discard, dot); err != nil {
+			return false
+		}
+		s.reset()
+		s.reply(552, "5.3.4", "Message size exceeds fixed limit")
+		return true
+	}
+
+	reply := s.relay.forward(s.apiKey, s.recipients, body)
+	fmt.Printf("  %s <%s> → %d recipient(s): %s\n", s.user, s.from, len(s.recipients), reply.Error())
+	s.reset()
+	s.replyError(reply)
+	return true
+}
+
+// forward converts a received message and sends it through the SendPost API; the
+// returned reply is the SMTP answer to DATA
+func (r *SMTPRelay) forward(apiKey string, recipients []string, body []byte) *smtpError {
+	parsed, err := ParseMIME(bytes.NewReader(body))
+	if err != nil {
+		return &smtpError{554, "5.6.0", err.Error()}
+	}
+	if parsed.From == nil {
+		return &smtpError{554, "5.6.0", "Message has no From header"}
+	}
+
+	ctx := subAccountContext(apiKey)
+	if err := r.e.senders.Validate(ctx, apiKey, parsed.From.Address, "the From header"); err != nil {
+		return &smtpError{550, "5.7.1", err.Error()}
+	}
+
+	// Retries of the same message by the client are sent at most once
+	builder := parsed.Builder(recipients)
+	if parsed.Headers[idempotencyHeader] == "" {
+		builder.IdempotencyKey(smtpIdempotencyKey(apiKey, recipients, body))
+	}
+	emailMessage, err := builder.Build()
+	if err != nil {
+		return &smtpError{554, "5.6.0", err.Error()}
+	}
+
+	responses, resp, _, err := r.e.sendEmail(ctx, emailMessage)
+	if err != nil {
+		return smtpSendError(resp, err)
+	}
+
+	var messageIDs, failed []string
+	for _, response := range responses {
+		if response.GetErrorCode() != 0 || response.GetMessageId() == "" {
+			failed = append(failed, fmt.Sprintf("%s (%s)", response.GetTo(), response.GetMessage()))
+			continue
+		}
+		messageIDs = append(messageIDs, response.GetMessageId())
+	}
+	if len(messageIDs) == 0 {
+		return &smtpError{554, "5.0.0", "Rejected by SendPost: " + strings.Join(failed, ", ")}
+	}
+	// A single reply to DATA cannot accept some recipients and refuse others, so a partial
+	// rejection fails the transaction; the permanent code keeps the client from resending
+	// to the recipients that were accepted
+	if len(failed) > 0 {
+		return &smtpError{554, "5.0.0", fmt.Sprintf("Not sent to %s; already queued for the other recipients as %s, do not resend",
+			strings.Join(failed, ", "), strings.Join(messageIDs, " "))}
+	}
+	return &smtpError{250, "2.0.0", "OK: queued as " + strings.Join(messageIDs, " ")}
+}
+
+// smtpIdempotencyKey identifies a message received by the relay by its sub-account,
+// envelope recipients and content, which includes the client's Message-ID
+func smtpIdempotencyKey(apiKey string, recipients []string, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n", apiKey, strings.Join(recipients, ","))
+	h.Write(body)
+	return "smtp-" + hex.EncodeToString(h.Sum(nil))
+}
+
+// smtpSendError maps a SendEmail failure to an SMTP reply: rejections by the API are
+// permanent and rate limits are temporary so the client retries. Network problems and
+// server errors leave the outcome unknown; SendPost may have accepted the message, so
+// they are permanent too and the client does not send it twice.
+func smtpSendError(resp *http.Response, err error) *smtpError {
+	var conflict *IdempotencyConflictError
+	switch {
+	case errors.As(err, &conflict):
+		return &smtpError{554, "5.7.0", err.Error()}
+	case resp == nil:
+		return &smtpError{554, "5.4.1", fmt.Sprintf("SendPost API did not answer (%v); the message may have been sent, do not resend", err)}
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return &smtpError{554, "5.7.8", "Sub-account API key rejected by SendPost"}
+	case resp.StatusCode == http.StatusRequestEntityTooLarge:
+		return &smtpError{552, "5.3.4", "Message too large for SendPost"}
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return &smtpError{451, "4.7.0", "SendPost rate limit reached, try again later"}
+	case resp.StatusCode >= 500:
+		return &smtpError{554, "5.3.0", fmt.Sprintf("SendPost error (status %d); the message may have been sent, do not resend", resp.StatusCode)}
+	default:
+		return &smtpError{554, "5.6.0", fmt.Sprintf("SendPost rejected the message (status %d): %v", resp.StatusCode, err)}
+	}
+}
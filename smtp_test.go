@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// smtpTestMessage is sent to a@example.com and b@example.com
+const smtpTestMessage = "From: sender@example.com\r\nTo: a@example.com, b@example.com\r\nMessage-ID: <1@client.test>\r\nSubject: Hello\r\n\r\nHello\r\n"
+
+// smtpTestSession starts a relay session over an in-memory connection, authenticated as
+// a users-file user; the relay's API calls go to a fake API whose SendEmail answers with
+// sendBody
+func smtpTestSession(t *testing.T, sendBody string) *textproto.Conn {
+	t.Helper()
+	conn := smtpTestConn(t, sendBody, nil)
+	expectSMTP(t, conn, "AUTH PLAIN "+base64.StdEncoding.EncodeToString([]byte("\x00app\x00secret")), 235)
+	return conn
+}
+
+// smtpTestConn starts a relay session that has been greeted but not authenticated;
+// sends counts the SendEmail calls when not nil
+func smtpTestConn(t *testing.T, sendBody string, sends *int32) *textproto.Conn {
+	t.Helper()
+	e := newTestExample(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			jsonHandler(200, `[{"id":1,"name":"example.com","verified":true}]`).ServeHTTP(w, r)
+			return
+		}
+		if sends != nil {
+			atomic.AddInt32(sends, 1)
+		}
+		jsonHandler(200, sendBody).ServeHTTP(w, r)
+	}))
+	relay := &SMTPRelay{e: e, opts: SMTPOptions{
+		Hostname:      "relay.test",
+		Insecure:      true,
+		MaxSize:       1 << 20,
+		MaxRecipients: 10,
+		Users:         map[string]SMTPUser{"app": {Password: "secret", SubAccountAPIKey: "sub-account-key"}},
+	}}
+
+	client, server := net.Pipe()
+	go relay.serve(server)
+	conn := textproto.NewConn(client)
+	t.Cleanup(func() { conn.Close() })
+
+	expectSMTP(t, conn, "", 220)
+	expectSMTP(t, conn, "EHLO client.test", 250)
+	return conn
+}
+
+// sendSMTPMessage runs one mail transaction and returns the reply text to DATA
+func sendSMTPMessage(t *testing.T, conn *textproto.Conn, message string, code int) string {
+	t.Helper()
+	expectSMTP(t, conn, "MAIL FROM:<sender@example.com>", 250)
+	expectSMTP(t, conn, "RCPT TO:<a@example.com>", 250)
+	expectSMTP(t, conn, "RCPT TO:<b@example.com>", 250)
+	expectSMTP(t, conn, "DATA", 354)
+	w := conn.DotWriter()
+	fmt.Fprint(w, message)
+	w.Close()
+	return expectSMTP(t, conn, "", code)
+}
+
+// expectSMTP sends command (unless empty) and checks the reply code; it returns the reply text
+func expectSMTP(t *testing.T, conn *textproto.Conn, command string, code int) string {
+	t.Helper()
+	if command != "" {
+		if err := conn.PrintfLine("%s", command); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, message, err := conn.ReadResponse(0)
+	if err != nil {
+		t.Fatalf("%q: %v", command, err)
+	}
+	if got != code {
+		t.Fatalf("%q: got %d %s, want %d", command, got, message, code)
+	}
+	return message
+}
+
+func TestSMTPRelayDataReplies(t *testing.T) {
+	tests := []struct {
+		name     string
+		sendBody string
+		wantCode int
+		want     string
+	}{
+		{"all sent", `[{"to":"a@example.com","messageId":"m1"},{"to":"b@example.com","messageId":"m2"}]`, 250, "queued as m1 m2"},
+		{"some refused", `[{"to":"a@example.com","messageId":"m1"},{"to":"b@example.com","errorCode":406,"message":"suppressed"}]`, 554, "Not sent to b@example.com (suppressed); already queued for the other recipients as m1"},
+		{"all refused", `[{"to":"a@example.com","errorCode":406,"message":"suppressed"},{"to":"b@example.com","errorCode":406,"message":"suppressed"}]`, 554, "Rejected by SendPost"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := smtpTestSession(t, tt.sendBody)
+			if text := sendSMTPMessage(t, conn, smtpTestMessage, tt.wantCode); !strings.Contains(text, tt.want) {
+				t.Errorf("reply = %q, want %q", text, tt.want)
+			}
+		})
+	}
+}
+
+func TestSMTPRetrySentOnce(t *testing.T) {
+	var sends int32
+	conn := smtpTestConn(t, `[{"to":"a@example.com","messageId":"m1"},{"to":"b@example.com","messageId":"m2"}]`, &sends)
+	expectSMTP(t, conn, "AUTH PLAIN "+base64.StdEncoding.EncodeToString([]byte("\x00app\x00secret")), 235)
+
+	first := sendSMTPMessage(t, conn, smtpTestMessage, 250)
+	retry := sendSMTPMessage(t, conn, smtpTestMessage, 250)
+	if n := atomic.LoadInt32(&sends); n != 1 || retry != first {
+		t.Errorf("%d SendEmail calls, replies %q and %q; want one call and the same reply", n, first, retry)
+	}
+	sendSMTPMessage(t, conn, strings.Replace(smtpTestMessage, "Hello\r\n", "Hello again\r\n", 1), 250)
+	if n := atomic.LoadInt32(&sends); n != 2 {
+		t.Errorf("a different message made %d SendEmail calls in total, want 2", n)
+	}
+}
+
+func TestSMTPAuthFailures(t *testing.T) {
+	conn := smtpTestConn(t, `[]`, nil)
+	wrong := "AUTH PLAIN " + base64.StdEncoding.EncodeToString([]byte("\x00app\x00wrong"))
+	expectSMTP(t, conn, wrong, 535)
+	expectSMTP(t, conn, "AUTH PLAIN "+base64.StdEncoding.EncodeToString([]byte("\x00nobody\x00secret")), 535)
+	expectSMTP(t, conn, wrong, 421)
+	if _, err := conn.ReadLine(); err == nil {
+		t.Error("connection still open after too many failed attempts")
+	}
+}
+
+func TestSMTPLongLine(t *testing.T) {
+	conn := smtpTestSession(t, `[]`)
+	expectSMTP(t, conn, "NOOP "+strings.Repeat("x", smtpMaxLineLength), 500)
+	expectSMTP(t, conn, "NOOP "+strings.Repeat("x", 3*smtpMaxLineLength), 500)
+	expectSMTP(t, conn, "NOOP", 250)
+}
+
+func TestSMTPSendError(t *testing.T) {
+	tests := []struct {
+		status       int
+		wantCode     int
+		wantEnhanced string
+	}{
+		{0, 554, "5.4.1"},
+		{http.StatusUnauthorized, 554, "5.7.8"},
+		{http.StatusForbidden, 554, "5.7.8"},
+		{http.StatusRequestEntityTooLarge, 552, "5.3.4"},
+		{http.StatusTooManyRequests, 451, "4.7.0"},
+		{http.StatusServiceUnavailable, 554, "5.3.0"},
+		{http.StatusUnprocessableEntity, 554, "5.6.0"},
+	}
+	for _, tt := range tests {
+		var resp *http.Response
+		if tt.status != 0 {
+			resp = &http.Response{StatusCode: tt.status}
+		}
+		reply := smtpSendError(resp, errors.New("failed"))
+		if reply.code != tt.wantCode || reply.enhanced != tt.wantEnhanced {
+			t.Errorf("status %d: reply %d %s, want %d %s", tt.status, reply.code, reply.enhanced, tt.wantCode, tt.wantEnhanced)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	sendpost "github.com/sendpost/sendpost-go-sdk"
+)
+
+// statsRequestTimeout bounds each stats API call of an export
+const statsRequestTimeout = 30 * time.Second
+
+// DailyStats are one sub-account's counters for one day (UTC)
+type DailyStats struct {
+	Date           time.Time
+	SubAccountID   int64
+	SubAccountName string
+	Processed      int64
+	Sent           int64
+	Delivered      int64
+	Dropped        int64
+	SMTPDropped    int64
+	HardBounced    int64
+	SoftBounced    int64
+	Opened         int64
+	Clicked        int64
+	Unsubscribed   int64
+	Spam           int64
+}
+
+// statsColumn is one column of the export schema
+// Parquet stores value for integer and date columns and text for string columns; CSV
+// prefers text when a column has it.
+type statsColumn struct {
+	name  string
+	kind  parquetKind
+	value func(row DailyStats) int64
+	text  func(row DailyStats) string
+}
+
+// statsExportColumns is the stable schema of stats exports, in column order
+// Columns are only ever appended, so warehouse tables loading older files keep working.
+var statsExportColumns = []statsColumn{
+	{"date", parquetDate, func(r DailyStats) int64 { return r.Date.Unix() / 86400 }, func(r DailyStats) string { return r.Date.Format(statDateLayout) }},
+	{"sub_account_id", parquetInt64, func(r DailyStats) int64 { return r.SubAccountID }, nil},
+	{"sub_account_name", parquetString, nil, func(r DailyStats) string { return r.SubAccountName }},
+	{"processed", parquetInt64, func(r DailyStats) int64 { return r.Processed }, nil},
+	{"sent", parquetInt64, func(r DailyStats) int64 { return r.Sent }, nil},
+	{"delivered", parquetInt64, func(r DailyStats) int64 { return r.Delivered }, nil},
+	{"dropped", parquetInt64, func(r DailyStats) int64 { return r.Dropped }, nil},
+	{"smtp_dropped", parquetInt64, func(r DailyStats) int64 { return r.SMTPDropped }, nil},
+	{"hard_bounced", parquetInt64, func(r DailyStats) int64 { return r.HardBounced }, nil},
+	{"soft_bounced", parquetInt64, func(r DailyStats) int64 { return r.SoftBounced }, nil},
+	{"opened", parquetInt64, func(r DailyStats) int64 { return r.Opened }, nil},
+	{"clicked", parquetInt64, func(r DailyStats) int64 { return r.Clicked }, nil},
+	{"unsubscribed", parquetInt64, func(r DailyStats) int64 { return r.Unsubscribed }, nil},
+	{"spam", parquetInt64, func(r DailyStats) int64 { return r.Spam }, nil},
+}
+
+// CollectDailyStats fetches daily stats in a range of the given sub-accounts, or of every
+// sub-account when ids is empty
+func (e *ESPExample) CollectDailyStats(r StatsRange, ids []int64) ([]DailyStats, error) {
+	ctx, cancel := context.WithTimeout(e.createAccountAuthContext(), statsRequestTimeout)
+	subAccounts, resp, err := e.client.SubAccountAPI.GetAllSubAccounts(ctx).Execute()
+	cancel()
+	if err != nil {
+		return nil, apiError("could not list sub-accounts", resp, err)
+	}
+
+	names := map[int64]string{}
+	for _, subAccount := range subAccounts {
+		if subAccount.Id != nil {
+			names[int64(*subAccount.Id)] = subAccount.GetName()
+		}
+	}
+	if len(ids) == 0 {
+		for id := range names {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	}
+
+	var rows []DailyStats
+	for _, id := range ids {
+		name, ok := names[id]
+		if !ok {
+			return nil, fmt.Errorf("sub-account %d not found", id)
+		}
+
+		stats, err := e.fetchSubAccountStats(id, r)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, stat := range stats {
+			row, err := dailyStatsRow(stat)
+			if err != nil {
+				return nil, fmt.Errorf("sub-account %d: %w", id, err)
+			}
+			row.SubAccountID, row.SubAccountName = id, name
+			rows = append(rows, row)
+		}
+		fmt.Printf("  Sub-account %d (%s): %d day(s)\n", id, name, len(stats))
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		if !rows[i].Date.Equal(rows[j].Date) {
+			return rows[i].Date.Before(rows[j].Date)
+		}
+		return rows[i].SubAccountID < rows[j].SubAccountID
+	})
+	return rows, nil
+}
+
+// dailyStatsRow converts one record of the sub-account stats API
+func dailyStatsRow(stat sendpost.Stat) (DailyStats, error) {
+	date := stat.GetDate()
+	if len(date) > len(statDateLayout) {
+		date = date[:len(statDateLayout)]
+	}
+	day, err := time.Parse(statDateLayout, date)
+	if err != nil {
+		return DailyStats{}, fmt.Errorf("invalid stats date %q", stat.GetDate())
+	}
+
+	row := statCounters(stat.Stat)
+	row.Date = day
+	return row, nil
+}
+
+// apiError describes a failed API call, with the status code when there was a response
+func apiError(action string, resp *http.Response, err error) error {
+	if resp != nil {
+		return fmt.Errorf("%s: status %d: %w", action, resp.StatusCode, err)
+	}
+	return fmt.Errorf("%s: %w", action, err)
+}
+
+// WriteStatsCSV writes daily stats to a CSV file with the export schema
+func WriteStatsCSV(path string, rows []DailyStats) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	header := make([]string, len(statsExportColumns))
+	for i, column := range statsExportColumns {
+		header[i] = column.name
+	}
+	writer.Write(header)
+	for _, row := range rows {
+		record := make([]string, len(statsExportColumns))
+		for i, column := range statsExportColumns {
+			if column.text != nil {
+				record[i] = column.text(row)
+			} else {
+				record[i] = strconv.FormatInt(column.value(row), 10)
+			}
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// WriteStatsParquet writes daily stats to a Parquet file with the export schema
+// Dates are DATE columns, names UTF-8 strings and counters INT64, all required.
+func WriteStatsParquet(path string, rows []DailyStats) error {
+	columns := make([]parquetColumn, len(statsExportColumns))
+	for i, column := range statsExportColumns {
+		columns[i] = parquetColumn{name: column.name, kind: column.kind}
+		for _, row := range rows {
+			if column.kind == parquetString {
+				columns[i].strings = append(columns[i].strings, column.text(row))
+			} else {
+				columns[i].ints = append(columns[i].ints, column.value(row))
+			}
+		}
+	}
+	return writeParquet(path, columns)
+}
+
+// ExportStats writes daily stats of sub-accounts to CSV and/or Parquet files
+func (e *ESPExample) ExportStats(r StatsRange, ids []int64, csvFile, parquetFile string) error {
+	fmt.Println("\n=== Stats Export ===")
+	fmt.Printf("  Range: %s\n", r)
+
+	rows, err := e.CollectDailyStats(r, ids)
+	if err != nil {
+		return err
+	}
+	if csvFile != "" {
+		if err := WriteStatsCSV(csvFile, rows); err != nil {
+			return fmt.Errorf("could not write CSV: %w", err)
+		}
+		fmt.Printf("✓ %d row(s) written to %s\n", len(rows), csvFile)
+	}
+	if parquetFile != "" {
+		if err := WriteStatsParquet(parquetFile, rows); err != nil {
+			return fmt.Errorf("could not write Parquet: %w", err)
+		}
+		fmt.Printf("✓ %d row(s) written to %s\n", len(rows), parquetFile)
+	}
+	return nil
+}
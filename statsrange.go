@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	sendpost "github.com/sendpost/sendpost-go-sdk"
+)
+
+// Stats range settings
+const (
+	defaultStatsDays = 7
+	// maxStatsWindowDays is the longest range requested from the stats API at once;
+	// longer ranges are split into windows of this size and the results merged
+	maxStatsWindowDays = 31
+)
+
+// StatsRange is an inclusive range of days for the stats APIs
+// Days are calendar dates at UTC midnight, so adding days never crosses a DST change.
+type StatsRange struct {
+	From time.Time
+	To   time.Time
+}
+
+// lastDays returns the range of n days ending today
+func lastDays(n int, now time.Time) StatsRange {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	return StatsRange{From: today.AddDate(0, 0, -(n - 1)), To: today}
+}
+
+// defaultStatsRange is the last 7 days, including today
+func defaultStatsRange() StatsRange {
+	return lastDays(defaultStatsDays, time.Now())
+}
+
+// Days returns the number of days in the range
+func (r StatsRange) Days() int {
+	return int(r.To.Sub(r.From).Hours()/24) + 1
+}
+
+// String describes the range, such as "2026-10-12 to 2026-10-18, 7 days"
+func (r StatsRange) String() string {
+	return fmt.Sprintf("%s to %s, %d days", r.From.Format(statDateLayout), r.To.Format(statDateLayout), r.Days())
+}
+
+// Windows splits the range into consecutive ranges of at most days days
+func (r StatsRange) Windows(days int) []StatsRange {
+	var windows []StatsRange
+	for start := r.From; !start.After(r.To); start = start.AddDate(0, 0, days) {
+		end := start.AddDate(0, 0, days-1)
+		if end.After(r.To) {
+			end = r.To
+		}
+		windows = append(windows, StatsRange{From: start, To: end})
+	}
+	return windows
+}
+
+// statsRangeFlags registers --from, --to, --last and --month on a flag set and returns
+// a function that resolves them after parsing
+func statsRangeFlags(fs *flag.FlagSet) func() (StatsRange, error) {
+	from := fs.String("from", "", "first day (YYYY-MM-DD)")
+	to := fs.String("to", "", "last day (YYYY-MM-DD, default today)")
+	last := fs.String("last", "", "days ending today, such as 30d or 4w (default 7d)")
+	month := fs.String("month", "", "calendar month (YYYY-MM)")
+	return func() (StatsRange, error) {
+		return parseStatsRange(*from, *to, *last, *month, time.Now())
+	}
+}
+
+// parseStatsRange resolves range flags; --last and --month cannot be combined with
+// each other or with --from/--to
+func parseStatsRange(from, to, last, month string, now time.Time) (StatsRange, error) {
+	set := 0
+	for _, value := range []string{from + to, last, month} {
+		if value != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return StatsRange{}, fmt.Errorf("use only one of --from/--to, --last and --month")
+	}
+
+	switch {
+	case last != "":
+		unit := 1
+		number := last
+		switch {
+		case strings.HasSuffix(last, "d"):
+			number = strings.TrimSuffix(last, "d")
+		case strings.HasSuffix(last, "w"):
+			number, unit = strings.TrimSuffix(last, "w"), 7
+		}
+		n, err := strconv.Atoi(number)
+		if err != nil || n <= 0 {
+			return StatsRange{}, fmt.Errorf("invalid --last %q: use a number of days or weeks such as 30d or 4w", last)
+		}
+		return lastDays(n*unit, now), nil
+
+	case month != "":
+		start, err := time.Parse("2006-01", month)
+		if err != nil {
+			return StatsRange{}, fmt.Errorf("invalid --month %q: use YYYY-MM", month)
+		}
+		r := StatsRange{From: start, To: start.AddDate(0, 1, -1)}
+		// The current month ends today
+		if today := lastDays(1, now).To; r.To.After(today) {
+			r.To = today
+		}
+		if r.From.After(r.To) {
+			return StatsRange{}, fmt.Errorf("--month %s is in the future", month)
+		}
+		return r, nil
+
+	case from != "" || to != "":
+		r := lastDays(1, now)
+		if from == "" {
+			return StatsRange{}, fmt.Errorf("--to needs --from")
+		}
+		var err error
+		if r.From, err = time.Parse(statDateLayout, from); err != nil {
+			return StatsRange{}, fmt.Errorf("invalid --from %q: use YYYY-MM-DD", from)
+		}
+		if to != "" {
+			if r.To, err = time.Parse(statDateLayout, to); err != nil {
+				return StatsRange{}, fmt.Errorf("invalid --to %q: use YYYY-MM-DD", to)
+			}
+		}
+		if r.To.Before(r.From) {
+			return StatsRange{}, fmt.Errorf("--to %s is before --from %s", r.To.Format(statDateLayout), from)
+		}
+		return r, nil
+	}
+	return lastDays(defaultStatsDays, now), nil
+}
+
+// fetchSubAccountStats gets the daily stats of a sub-account, one request per window
+// The days are merged in date order; a day that more than one window returns is kept once.
+func (e *ESPExample) fetchSubAccountStats(id int64, r StatsRange) ([]sendpost.Stat, error) {
+	var stats []sendpost.Stat
+	seen := map[string]bool{}
+	for _, window := range r.Windows(maxStatsWindowDays) {
+		ctx, cancel := context.WithTimeout(e.createAccountAuthContext(), statsRequestTimeout)
+		page, resp, err := e.client.StatsAPI.AccountSubaccountStatSubaccountIdGet(ctx, id).
+			From(window.From.Format(statDateLayout)).
+			To(window.To.Format(statDateLayout)).
+			Execute()
+		cancel()
+		if err != nil {
+			return nil, apiError(fmt.Sprintf("could not get stats of sub-account %d for %s", id, window), resp, err)
+		}
+		for _, stat := range page {
+			if date := stat.GetDate(); !seen[date] {
+				seen[date] = true
+				stats = append(stats, stat)
+			}
+		}
+	}
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].GetDate() < stats[j].GetDate() })
+	return stats, nil
+}
+
+// fetchAccountStats gets the daily stats of the account, one request per window, merged
+// like fetchSubAccountStats
+func (e *ESPExample) fetchAccountStats(r StatsRange) ([]sendpost.AccountStats, error) {
+	var stats []sendpost.AccountStats
+	seen := map[string]bool{}
+	for _, window := range r.Windows(maxStatsWindowDays) {
+		ctx, cancel := context.WithTimeout(e.createAccountAuthContext(), statsRequestTimeout)
+		page, resp, err := e.client.StatsAAPI.GetAllAccountStats(ctx).
+			From(window.From.Format(statDateLayout)).
+			To(window.To.Format(statDateLayout)).
+			Execute()
+		cancel()
+		if err != nil {
+			return nil, apiError(fmt.Sprintf("could not get account stats for %s", window), resp, err)
+		}
+		for _, stat := range page {
+			if date := stat.GetDate(); !seen[date] {
+				seen[date] = true
+				stats = append(stats, stat)
+			}
+		}
+	}
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].GetDate() < stats[j].GetDate() })
+	return stats, nil
+}
+
+// fetchAggregate sums an aggregate stats request over the windows of a range
+// request fetches one window; counters missing from every window stay unset.
+func fetchAggregate(r StatsRange, request func(from, to string) (*sendpost.AggregateStat, error)) (*sendpost.AggregateStat, error) {
+	total := sendpost.NewAggregateStat()
+	for _, window := range r.Windows(maxStatsWindowDays) {
+		stat, err := request(window.From.Format(statDateLayout), window.To.Format(statDateLayout))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", window, err)
+		}
+		if stat == nil {
+			continue
+		}
+		addCounter(&total.Processed, stat.Processed)
+		addCounter(&total.Sent, stat.Sent)
+		addCounter(&total.Delivered, stat.Delivered)
+		addCounter(&total.Dropped, stat.Dropped)
+		addCounter(&total.SmtpDropped, stat.SmtpDropped)
+		addCounter(&total.HardBounced, stat.HardBounced)
+		addCounter(&total.SoftBounced, stat.SoftBounced)
+		addCounter(&total.Opened, stat.Opened)
+		addCounter(&total.Clicked, stat.Clicked)
+		addCounter(&total.Unsubscribed, stat.Unsubscribed)
+		addCounter(&total.Spam, stat.Spam)
+	}
+	return total, nil
+}
+
+// addCounter adds an optional counter to a total
+func addCounter(total **int32, value *int32) {
+	if value == nil {
+		return
+	}
+	if *total == nil {
+		*total = new(int32)
+	}
+	**total += *value
+}
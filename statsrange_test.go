@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	sendpost "github.com/sendpost/sendpost-go-sdk"
+)
+
+// day parses a YYYY-MM-DD date at UTC midnight
+func day(t *testing.T, value string) time.Time {
+	t.Helper()
+	d, err := time.Parse(statDateLayout, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// formatWindows renders ranges as "from..to" pairs for comparison
+func formatWindows(windows []StatsRange) string {
+	parts := make([]string, len(windows))
+	for i, w := range windows {
+		parts[i] = w.From.Format(statDateLayout) + ".." + w.To.Format(statDateLayout)
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestStatsRangeWindows(t *testing.T) {
+	tests := []struct {
+		from, to string
+		days     int
+		want     string
+	}{
+		{"2026-10-12", "2026-10-18", 31, "2026-10-12..2026-10-18"},
+		{"2026-10-18", "2026-10-18", 31, "2026-10-18..2026-10-18"},
+		{"2026-01-01", "2026-01-31", 31, "2026-01-01..2026-01-31"},
+		{"2026-01-01", "2026-02-01", 31, "2026-01-01..2026-01-31 2026-02-01..2026-02-01"},
+		{"2025-12-01", "2026-03-15", 31, "2025-12-01..2025-12-31 2026-01-01..2026-01-31 2026-02-01..2026-03-03 2026-03-04..2026-03-15"},
+		{"2026-10-20", "2026-10-30", 5, "2026-10-20..2026-10-24 2026-10-25..2026-10-29 2026-10-30..2026-10-30"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from+".."+tt.to, func(t *testing.T) {
+			r := StatsRange{From: day(t, tt.from), To: day(t, tt.to)}
+			windows := r.Windows(tt.days)
+			if got := formatWindows(windows); got != tt.want {
+				t.Errorf("Windows(%d) = %s, want %s", tt.days, got, tt.want)
+			}
+			total := 0
+			for _, w := range windows {
+				if w.Days() > tt.days {
+					t.Errorf("window %s is longer than %d days", w, tt.days)
+				}
+				total += w.Days()
+			}
+			if total != r.Days() {
+				t.Errorf("windows cover %d days, range has %d", total, r.Days())
+			}
+		})
+	}
+}
+
+func TestParseStatsRange(t *testing.T) {
+	now := time.Date(2026, 10, 18, 15, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name                  string
+		from, to, last, month string
+		want                  string
+		wantErr               string
+	}{
+		{"default", "", "", "", "", "2026-10-12..2026-10-18", ""},
+		{"last days", "", "", "30d", "", "2026-09-19..2026-10-18", ""},
+		{"last bare number", "", "", "1", "", "2026-10-18..2026-10-18", ""},
+		{"last weeks", "", "", "4w", "", "2026-09-21..2026-10-18", ""},
+		{"past month", "", "", "", "2026-02", "2026-02-01..2026-02-28", ""},
+		{"current month ends today", "", "", "", "2026-10", "2026-10-01..2026-10-18", ""},
+		{"from only", "2026-08-01", "", "", "", "2026-08-01..2026-10-18", ""},
+		{"from and to", "2025-11-01", "2026-02-15", "", "", "2025-11-01..2026-02-15", ""},
+		{"to without from", "", "2026-10-01", "", "", "", "--to needs --from"},
+		{"to before from", "2026-10-10", "2026-10-01", "", "", "", "is before --from"},
+		{"bad from", "10/01/2026", "", "", "", "", "invalid --from"},
+		{"bad last", "", "", "0d", "", "", "invalid --last"},
+		{"bad unit", "", "", "3m", "", "", "invalid --last"},
+		{"future month", "", "", "", "2026-11", "", "in the future"},
+		{"bad month", "", "", "", "2026-13", "", "invalid --month"},
+		{"combined", "", "", "7d", "2026-10", "", "use only one of"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := parseStatsRange(tt.from, tt.to, tt.last, tt.month, now)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("parseStatsRange() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := formatWindows([]StatsRange{r}); got != tt.want {
+				t.Errorf("parseStatsRange() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchAggregate(t *testing.T) {
+	r := StatsRange{From: day(t, "2026-01-01"), To: day(t, "2026-03-15")}
+	counter := func(v int32) *int32 { return &v }
+
+	var requested []string
+	total, err := fetchAggregate(r, func(from, to string) (*sendpost.AggregateStat, error) {
+		requested = append(requested, from+".."+to)
+		stat := sendpost.NewAggregateStat()
+		stat.Sent = counter(10)
+		if from == "2026-01-01" {
+			stat.Opened = counter(3)
+			return stat, nil
+		}
+		if from == "2026-02-01" {
+			return nil, nil
+		}
+		return stat, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(requested, " "); got != formatWindows(r.Windows(maxStatsWindowDays)) {
+		t.Errorf("requested windows %s", got)
+	}
+	if total.GetSent() != 20 || total.GetOpened() != 3 || total.Clicked != nil {
+		t.Errorf("total sent %d, opened %d, clicked set %v; want 20, 3, unset", total.GetSent(), total.GetOpened(), total.Clicked != nil)
+	}
+
+	_, err = fetchAggregate(r, func(from, to string) (*sendpost.AggregateStat, error) {
+		return nil, errors.New("rate limited")
+	})
+	if err == nil || !strings.Contains(err.Error(), "2026-01-01 to 2026-01-31, 31 days: rate limited") {
+		t.Errorf("fetchAggregate() error = %v, want the failing window", err)
+	}
+}
+
+func TestFetchAccountStatsMergesWindows(t *testing.T) {
+	var mu sync.Mutex
+	var windows []string
+	e := newTestExample(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		from, to := r.URL.Query().Get("from"), r.URL.Query().Get("to")
+		mu.Lock()
+		windows = append(windows, from+".."+to)
+		mu.Unlock()
+		// Every window repeats a day the earlier one returned, out of order
+		jsonHandler(200, fmt.Sprintf(`[{"date":%q},{"date":"2026-01-31"},{"date":%q}]`, to, from)).ServeHTTP(w, r)
+	}))
+
+	stats, err := e.fetchAccountStats(StatsRange{From: day(t, "2026-01-01"), To: day(t, "2026-02-10")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(windows, " "); got != "2026-01-01..2026-01-31 2026-02-01..2026-02-10" {
+		t.Errorf("requested windows %s", got)
+	}
+	var dates []string
+	for _, stat := range stats {
+		dates = append(dates, stat.GetDate())
+	}
+	if got := strings.Join(dates, " "); got != "2026-01-01 2026-01-31 2026-02-01 2026-02-10" {
+		t.Errorf("merged dates = %s", got)
+	}
+}
+
+func TestStatsStepsReturnErrors(t *testing.T) {
+	e := newTestExample(t, jsonHandler(400, `{"error":"bad request"}`))
+	id := int32(7)
+	e.createdSubAccountID = &id
+	e.statsRange = StatsRange{From: day(t, "2026-01-01"), To: day(t, "2026-01-07")}
+
+	steps := []struct {
+		name string
+		run  func() error
+	}{
+		{"sub-account", e.GetSubAccountStats},
+		{"aggregate", e.GetAggregateStats},
+		{"account", e.GetAccountStats},
+	}
+	for _, step := range steps {
+		var err error
+		captureStdout(t, func() { err = step.run() })
+		if !errors.Is(err, errNoStats) {
+			t.Errorf("%s stats error = %v, want %v", step.name, err, errNoStats)
+		}
+	}
+
+	// Without a sub-account there is nothing to ask for
+	e = newTestExample(t, jsonHandler(200, `[]`))
+	var err error
+	captureStdout(t, func() { err = e.GetSubAccountStats() })
+	if !errors.Is(err, errNoStats) {
+		t.Errorf("stats without a sub-account error = %v, want %v", err, errNoStats)
+	}
+}
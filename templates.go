@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+
+	sendpost "github.com/sendpost/sendpost-go-sdk"
+)
+
+// Template file layout inside the templates directory:
+//
+//	layouts/*.html, layouts/*.txt    shared layouts, e.g. {{define "base"}}...{{block "content" .}}{{end}}...{{end}}
+//	partials/*.html, partials/*.txt  shared snippets, e.g. {{define "footer"}}...{{end}}
+//	<name>/<version>/subject.txt     subject line (text/template)
+//	<name>/<version>/body.html       HTML body (html/template)
+//	<name>/<version>/body.txt        text body (text/template, optional)
+const (
+	defaultTemplatesDir = "templates"
+	layoutsDir          = "layouts"
+	partialsDir         = "partials"
+	subjectFile         = "subject.txt"
+	htmlBodyFile        = "body.html"
+	textBodyFile        = "body.txt"
+)
+
+// templateKey identifies one version of a named template
+type templateKey struct {
+	name    string
+	version string
+}
+
+// EmailTemplate is a parsed template version ready to be rendered per recipient
+type EmailTemplate struct {
+	Name    string
+	Version string
+	subject *texttemplate.Template
+	html    *htmltemplate.Template
+	text    *texttemplate.Template
+}
+
+// RenderedEmail is the output of rendering a template for one recipient
+type RenderedEmail struct {
+	Subject  string
+	HtmlBody string
+	TextBody string
+}
+
+// TemplateData is the value templates are executed with
+// Templates access it as {{.Email}}, {{.Name}} and {{.Fields.customer_id}}
+type TemplateData struct {
+	Email  string
+	Name   string
+	Fields map[string]interface{}
+}
+
+// TemplateRegistry holds every template version found in a templates directory
+type TemplateRegistry struct {
+	dir       string
+	templates map[templateKey]*EmailTemplate
+	versions  map[string][]string
+}
+
+// LoadTemplateRegistry parses all templates, layouts and partials below dir
+func LoadTemplateRegistry(dir string) (*TemplateRegistry, error) {
+	registry := &TemplateRegistry{
+		dir:       dir,
+		templates: make(map[templateKey]*EmailTemplate),
+		versions:  make(map[string][]string),
+	}
+
+	htmlShared, err := filepath.Glob(filepath.Join(dir, layoutsDir, "*.html"))
+	if err != nil {
+		return nil, err
+	}
+	partials, err := filepath.Glob(filepath.Join(dir, partialsDir, "*.html"))
+	if err != nil {
+		return nil, err
+	}
+	htmlShared = append(htmlShared, partials...)
+
+	textShared, err := filepath.Glob(filepath.Join(dir, layoutsDir, "*.txt"))
+	if err != nil {
+		return nil, err
+	}
+	partials, err = filepath.Glob(filepath.Join(dir, partialsDir, "*.txt"))
+	if err != nil {
+		return nil, err
+	}
+	textShared = append(textShared, partials...)
+
+	names, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read templates directory: %w", err)
+	}
+
+	for _, nameEntry := range names {
+		if !nameEntry.IsDir() || nameEntry.Name() == layoutsDir || nameEntry.Name() == partialsDir {
+			continue
+		}
+		name := nameEntry.Name()
+
+		versions, err := os.ReadDir(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		for _, versionEntry := range versions {
+			if !versionEntry.IsDir() {
+				continue
+			}
+			version := versionEntry.Name()
+
+			tmpl, err := parseEmailTemplate(filepath.Join(dir, name, version), name, version, htmlShared, textShared)
+			if err != nil {
+				return nil, fmt.Errorf("template %s@%s: %w", name, version, err)
+			}
+			registry.templates[templateKey{name: name, version: version}] = tmpl
+			registry.versions[name] = append(registry.versions[name], version)
+		}
+		sort.Slice(registry.versions[name], func(i, j int) bool {
+			return versionLess(registry.versions[name][i], registry.versions[name][j])
+		})
+	}
+
+	return registry, nil
+}
+
+// parseEmailTemplate parses the subject and body files of one template version
+func parseEmailTemplate(path, name, version string, htmlShared, textShared []string) (*EmailTemplate, error) {
+	tmpl := &EmailTemplate{Name: name, Version: version}
+
+	subject, err := os.ReadFile(filepath.Join(path, subjectFile))
+	if err != nil {
+		return nil, fmt.Errorf("missing %s: %w", subjectFile, err)
+	}
+	tmpl.subject, err = texttemplate.New(subjectFile).Option("missingkey=error").Parse(strings.TrimSpace(string(subject)))
+	if err != nil {
+		return nil, err
+	}
+
+	htmlFiles := append(append([]string{}, htmlShared...), filepath.Join(path, htmlBodyFile))
+	tmpl.html, err = htmltemplate.New(htmlBodyFile).Option("missingkey=error").ParseFiles(htmlFiles...)
+	if err != nil {
+		return nil, err
+	}
+
+	textPath := filepath.Join(path, textBodyFile)
+	if _, err := os.Stat(textPath); err == nil {
+		textFiles := append(append([]string{}, textShared...), textPath)
+		tmpl.text, err = texttemplate.New(textBodyFile).Option("missingkey=error").ParseFiles(textFiles...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tmpl, nil
+}
+
+// versionLess orders versions like v1 < v2 < v10, falling back to string order
+func versionLess(a, b string) bool {
+	na, errA := strconv.Atoi(strings.TrimPrefix(a, "v"))
+	nb, errB := strconv.Atoi(strings.TrimPrefix(b, "v"))
+	if errA == nil && errB == nil {
+		return na < nb
+	}
+	return a < b
+}
+
+// Get returns a template version; an empty version selects the latest one
+func (r *TemplateRegistry) Get(name, version string) (*EmailTemplate, error) {
+	versions := r.versions[name]
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("template %q not found in %s", name, r.dir)
+	}
+	if version == "" {
+		version = versions[len(versions)-1]
+	}
+
+	tmpl, ok := r.templates[templateKey{name: name, version: version}]
+	if !ok {
+		return nil, fmt.Errorf("template %q has no version %q (available: %s)", name, version, strings.Join(versions, ", "))
+	}
+	return tmpl, nil
+}
+
+// Names returns the names of all registered templates in sorted order
+func (r *TemplateRegistry) Names() []string {
+	names := make([]string, 0, len(r.versions))
+	for name := range r.versions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Versions returns the versions of a template from oldest to latest
+func (r *TemplateRegistry) Versions(name string) []string {
+	return r.versions[name]
+}
+
+// Render executes the template with the email, name and custom fields of a recipient
+func (t *EmailTemplate) Render(recipient sendpost.Recipient) (*RenderedEmail, error) {
+	data := TemplateData{
+		Email:  recipient.GetEmail(),
+		Name:   recipient.GetName(),
+		Fields: recipient.GetCustomFields(),
+	}
+	if data.Fields == nil {
+		data.Fields = map[string]interface{}{}
+	}
+
+	var subject, html, text bytes.Buffer
+	if err := t.subject.Execute(&subject, data); err != nil {
+		return nil, fmt.Errorf("rendering subject: %w", err)
+	}
+	if err := t.html.ExecuteTemplate(&html, htmlBodyFile, data); err != nil {
+		return nil, fmt.Errorf("rendering HTML body: %w", err)
+	}
+	if t.text != nil {
+		if err := t.text.ExecuteTemplate(&text, textBodyFile, data); err != nil {
+			return nil, fmt.Errorf("rendering text body: %w", err)
+		}
+	}
+
+	return &RenderedEmail{
+		Subject:  strings.TrimSpace(subject.String()),
+		HtmlBody: strings.TrimSpace(html.String()),
+		TextBody: strings.TrimSpace(text.String()),
+	}, nil
+}
+
+// templateRegistry loads the template registry on first use
+func (e *ESPExample) templateRegistry() (*TemplateRegistry, error) {
+	if e.templates == nil {
+		registry, err := LoadTemplateRegistry(e.templatesDir)
+		if err != nil {
+			return nil, err
+		}
+		e.templates = registry
+	}
+	return e.templates, nil
+}
+
+// renderEmail renders a version of a named template for a recipient; "" is the latest
+func (e *ESPExample) renderEmail(name, version string, recipient sendpost.Recipient) (*RenderedEmail, error) {
+	registry, err := e.templateRegistry()
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := registry.Get(name, version)
+	if err != nil {
+		return nil, err
+	}
+	return tmpl.Render(recipient)
+}
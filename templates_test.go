@@ -0,0 +1,126 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	sendpost "github.com/sendpost/sendpost-go-sdk"
+)
+
+// shippedTemplateFields are the custom fields each template in templates/ needs
+var shippedTemplateFields = map[string]map[string]interface{}{
+	"order-confirmation": {"order_value": "99.99"},
+	"special-offer":      {"discount_code": "SAVE20"},
+}
+
+func templateRecipient(fields map[string]interface{}) sendpost.Recipient {
+	recipient := sendpost.NewRecipient()
+	recipient.SetEmail("anna@example.com")
+	recipient.SetName("Anna")
+	copied := map[string]interface{}{}
+	for key, value := range fields {
+		copied[key] = value
+	}
+	recipient.SetCustomFields(copied)
+	return *recipient
+}
+
+func TestShippedTemplatesRender(t *testing.T) {
+	registry, err := LoadTemplateRegistry(defaultTemplatesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range registry.Names() {
+		fields, ok := shippedTemplateFields[name]
+		if !ok {
+			t.Errorf("template %s has no entry in shippedTemplateFields", name)
+			continue
+		}
+		for _, version := range registry.Versions(name) {
+			tmpl, err := registry.Get(name, version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tests := []struct {
+				name           string
+				preferencesURL string
+			}{
+				{"without preference link", ""},
+				{"with preference link", "https://example.com/unsubscribe?email=anna%40example.com&sig=abc"},
+			}
+			for _, tt := range tests {
+				t.Run(name+"/"+version+"/"+tt.name, func(t *testing.T) {
+					recipient := templateRecipient(fields)
+					if tt.preferencesURL != "" {
+						recipient.CustomFields["preferences_url"] = tt.preferencesURL
+					}
+					rendered, err := tmpl.Render(recipient)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if rendered.Subject == "" || !strings.Contains(rendered.HtmlBody, "anna@example.com") {
+						t.Errorf("rendered = %+v, want a subject and the footer", rendered)
+					}
+					hasLink := strings.Contains(rendered.HtmlBody, "Manage email preferences")
+					if hasLink != (tt.preferencesURL != "") {
+						t.Errorf("footer preference link shown = %v:\n%s", hasLink, rendered.HtmlBody)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestTemplateMissingField(t *testing.T) {
+	registry, err := LoadTemplateRegistry(defaultTemplatesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := registry.Get("special-offer", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tmpl.Render(templateRecipient(nil)); err == nil || !strings.Contains(err.Error(), "discount_code") {
+		t.Errorf("Render() without discount_code error = %v", err)
+	}
+}
+
+func TestTemplateVersions(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplateFiles(t, dir, map[string]string{
+		"promo/v2/subject.txt":  "Version 2",
+		"promo/v2/body.html":    "<p>2</p>",
+		"promo/v10/subject.txt": "Version 10",
+		"promo/v10/body.html":   "<p>10</p>",
+	})
+	registry, err := LoadTemplateRegistry(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		version     string
+		wantSubject string
+		wantErr     bool
+	}{
+		{"", "Version 10", false},
+		{"v2", "Version 2", false},
+		{"v3", "", true},
+	}
+	for _, tt := range tests {
+		tmpl, err := registry.Get("promo", tt.version)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Get(%q) succeeded", tt.version)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		rendered, err := tmpl.Render(templateRecipient(nil))
+		if err != nil || rendered.Subject != tt.wantSubject {
+			t.Errorf("Get(%q) renders %q, %v; want %q", tt.version, rendered.Subject, err, tt.wantSubject)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mailbox providers that pacing rules apply to
+const (
+	providerGmail     = "gmail"
+	providerMicrosoft = "microsoft"
+	providerYahoo     = "yahoo"
+	providerApple     = "apple"
+	providerOther     = "other"
+)
+
+// MX lookup settings for provider classification
+const (
+	mxLookupTimeout = 3 * time.Second
+	mxLookupWorkers = 8
+)
+
+// PacingRule limits how fast messages go to one mailbox provider: a steady PerMinute rate
+// with up to Burst recipients sent at once
+type PacingRule struct {
+	PerMinute int
+	Burst     int
+}
+
+// defaultPacingRules are conservative starting points for a warm sending reputation
+var defaultPacingRules = map[string]PacingRule{
+	providerGmail:     {PerMinute: 300, Burst: 50},
+	providerMicrosoft: {PerMinute: 200, Burst: 40},
+	providerYahoo:     {PerMinute: 200, Burst: 40},
+	providerApple:     {PerMinute: 200, Burst: 40},
+	providerOther:     {PerMinute: 600, Burst: 100},
+}
+
+// providerDomains maps well-known mailbox domains to their provider without an MX lookup
+var providerDomains = map[string]string{
+	"gmail.com": providerGmail, "googlemail.com": providerGmail,
+	"outlook.com": providerMicrosoft, "hotmail.com": providerMicrosoft, "live.com": providerMicrosoft, "msn.com": providerMicrosoft,
+	"yahoo.com": providerYahoo, "ymail.com": providerYahoo, "aol.com": providerYahoo,
+	"icloud.com": providerApple, "me.com": providerApple, "mac.com": providerApple,
+}
+
+// providerMXSuffixes classifies custom domains by the host names of their MX records
+var providerMXSuffixes = []struct {
+	suffix   string
+	provider string
+}{
+	{".google.com.", providerGmail},
+	{".googlemail.com.", providerGmail},
+	{".outlook.com.", providerMicrosoft},
+	{".hotmail.com.", providerMicrosoft},
+	{".yahoodns.net.", providerYahoo},
+	{".icloud.com.", providerApple},
+	{".apple.com.", providerApple},
+}
+
+// providerClassifier maps recipient domains to mailbox providers, caching MX lookups
+type providerClassifier struct {
+	mu        sync.Mutex
+	providers map[string]string
+	lookupMX  func(ctx context.Context, domain string) ([]*net.MX, error)
+}
+
+// newProviderClassifier creates a classifier; useMX false classifies unknown domains as other
+func newProviderClassifier(useMX bool) *providerClassifier {
+	c := &providerClassifier{providers: map[string]string{}}
+	if useMX {
+		c.lookupMX = net.DefaultResolver.LookupMX
+	}
+	return c
+}
+
+// emailDomain returns the lowercased domain of an address
+func emailDomain(email string) string {
+	if at := strings.LastIndex(email, "@"); at >= 0 {
+		return strings.ToLower(strings.TrimSpace(email[at+1:]))
+	}
+	return ""
+}
+
+// Provider returns the provider of an address; Prepare must have seen its domain for MX
+// classification to apply
+func (c *providerClassifier) Provider(email string) string {
+	domain := emailDomain(email)
+	if provider, ok := providerDomains[domain]; ok {
+		return provider
+	}
+	if strings.HasPrefix(domain, "yahoo.") {
+		return providerYahoo
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if provider, ok := c.providers[domain]; ok {
+		return provider
+	}
+	return providerOther
+}
+
+// Prepare looks up the MX records of every unknown domain among the addresses in parallel
+func (c *providerClassifier) Prepare(emails []string) {
+	if c.lookupMX == nil {
+		return
+	}
+
+	c.mu.Lock()
+	var domains []string
+	for _, email := range emails {
+		domain := emailDomain(email)
+		if _, known := providerDomains[domain]; known || domain == "" {
+			continue
+		}
+		if _, cached := c.providers[domain]; !cached {
+			c.providers[domain] = providerOther
+			domains = append(domains, domain)
+		}
+	}
+	c.mu.Unlock()
+
+	jobs := make(chan string)
+	var workers sync.WaitGroup
+	for i := 0; i < mxLookupWorkers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for domain := range jobs {
+				provider := c.classifyMX(domain)
+				c.mu.Lock()
+				c.providers[domain] = provider
+				c.mu.Unlock()
+			}
+		}()
+	}
+	for _, domain := range domains {
+		jobs <- domain
+	}
+	close(jobs)
+	workers.Wait()
+}
+
+// classifyMX returns the provider hosting a domain's mail, or other when unknown
+func (c *providerClassifier) classifyMX(domain string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), mxLookupTimeout)
+	defer cancel()
+
+	records, err := c.lookupMX(ctx, domain)
+	if err != nil {
+		return providerOther
+	}
+	for _, record := range records {
+		host := strings.ToLower(record.Host)
+		if !strings.HasSuffix(host, ".") {
+			host += "."
+		}
+		for _, match := range providerMXSuffixes {
+			if strings.HasSuffix(host, match.suffix) {
+				return match.provider
+			}
+		}
+	}
+	return providerOther
+}
+
+// Pacer spaces out bulk requests so no mailbox provider receives mail faster than its rule
+type Pacer struct {
+	rules      map[string]PacingRule
+	classifier *providerClassifier
+}
+
+// NewPacer creates a pacer with the default rules overridden by rules
+func NewPacer(rules map[string]PacingRule, useMX bool) *Pacer {
+	merged := make(map[string]PacingRule, len(defaultPacingRules))
+	for provider, rule := range defaultPacingRules {
+		merged[provider] = rule
+	}
+	for provider, rule := range rules {
+		merged[provider] = rule
+	}
+	return &Pacer{rules: merged, classifier: newProviderClassifier(useMX)}
+}
+
+// parsePacingRule parses a --pace value: provider=PER_MINUTE[:BURST]
+// The burst defaults to a tenth of the per-minute rate.
+func parsePacingRule(value string) (string, PacingRule, error) {
+	provider, limits, ok := strings.Cut(value, "=")
+	if _, known := defaultPacingRules[provider]; !ok || !known {
+		return "", PacingRule{}, fmt.Errorf("pace %q must be provider=PER_MINUTE[:BURST] with provider one of gmail, microsoft, yahoo, apple, other", value)
+	}
+	perMinuteText, burstText, hasBurst := strings.Cut(limits, ":")
+	perMinute, err := strconv.Atoi(perMinuteText)
+	if err != nil || perMinute <= 0 {
+		return "", PacingRule{}, fmt.Errorf("pace %q needs a positive per-minute rate", value)
+	}
+	rule := PacingRule{PerMinute: perMinute, Burst: (perMinute + 9) / 10}
+	if hasBurst {
+		if rule.Burst, err = strconv.Atoi(burstText); err != nil || rule.Burst <= 0 {
+			return "", PacingRule{}, fmt.Errorf("pace %q needs a positive burst", value)
+		}
+	}
+	return provider, rule, nil
+}
+
+// batchProviders counts the recipients of a batch per provider
+func (p *Pacer) batchProviders(batch bulkBatch) map[string]int {
+	counts := map[string]int{}
+	for _, recipient := range batch.recipients {
+		counts[p.classifier.Provider(recipient.GetEmail())]++
+	}
+	return counts
+}
+
+// tokenBucket tracks one provider's sending allowance while a schedule is planned
+type tokenBucket struct {
+	rule   PacingRule
+	tokens float64
+	at     time.Duration
+}
+
+// take returns the earliest offset at which n recipients may be sent, and consumes them
+// A request larger than the burst waits for a full bucket and leaves the bucket in debt,
+// which delays the next request accordingly.
+func (b *tokenBucket) take(n int) time.Duration {
+	rate := float64(b.rule.PerMinute) / float64(time.Minute)
+	burst := float64(b.rule.Burst)
+	refill := func(to time.Duration) {
+		b.tokens += rate * float64(to-b.at)
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+		b.at = to
+	}
+
+	need := float64(n)
+	if need > burst {
+		need = burst
+	}
+	if b.tokens < need {
+		refill(b.at + time.Duration((need-b.tokens)/rate))
+	}
+	b.tokens -= float64(n)
+	return b.at
+}
+
+// Schedule returns the offset from the start of the send at which each batch may go out
+// Batches are planned in order; a batch with recipients at several providers waits for
+// the slowest of them.
+func (p *Pacer) Schedule(batches []bulkBatch) []time.Duration {
+	var emails []string
+	for _, batch := range batches {
+		for _, recipient := range batch.recipients {
+			emails = append(emails, recipient.GetEmail())
+		}
+	}
+	p.classifier.Prepare(emails)
+
+	buckets := map[string]*tokenBucket{}
+	offsets := make([]time.Duration, len(batches))
+	for i, batch := range batches {
+		counts := p.batchProviders(batch)
+		var at time.Duration
+		for provider, n := range counts {
+			bucket, ok := buckets[provider]
+			if !ok {
+				bucket = &tokenBucket{rule: p.rules[provider], tokens: float64(p.rules[provider].Burst)}
+				buckets[provider] = bucket
+			}
+			if start := bucket.take(n); start > at {
+				at = start
+			}
+		}
+		offsets[i] = at
+	}
+	return offsets
+}
+
+// PrintSchedule prints when each batch would be sent and a summary per provider
+func (p *Pacer) PrintSchedule(batches []bulkBatch, offsets []time.Duration) {
+	order := make([]int, len(batches))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return offsets[order[a]] < offsets[order[b]] })
+
+	type summary struct {
+		recipients  int
+		first, last time.Duration
+	}
+	summaries := map[string]*summary{}
+
+	fmt.Println("\n  Send schedule:")
+	fmt.Printf("    %-10s %-12s %10s\n", "Offset", "Provider", "Recipients")
+	for _, i := range order {
+		counts := p.batchProviders(batches[i])
+		providers := make([]string, 0, len(counts))
+		for provider, n := range counts {
+			providers = append(providers, provider)
+			s, ok := summaries[provider]
+			if !ok {
+				s = &summary{first: offsets[i]}
+				summaries[provider] = s
+			}
+			s.recipients += n
+			s.last = offsets[i]
+		}
+		sort.Strings(providers)
+		fmt.Printf("    +%-9s %-12s %10d\n", offsets[i].Round(time.Second), strings.Join(providers, ","), len(batches[i].recipients))
+	}
+
+	providers := make([]string, 0, len(summaries))
+	for provider := range summaries {
+		providers = append(providers, provider)
+	}
+	sort.Strings(providers)
+
+	fmt.Println("\n  Per provider:")
+	for _, provider := range providers {
+		s := summaries[provider]
+		rule := p.rules[provider]
+		fmt.Printf("    %-10s %6d recipient(s) at %d/min (burst %d), from +%s to +%s\n",
+			provider, s.recipients, rule.PerMinute, rule.Burst, s.first.Round(time.Second), s.last.Round(time.Second))
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	sendpost "github.com/sendpost/sendpost-go-sdk"
+)
+
+// approxDuration reports whether got is within a millisecond of want; bucket offsets
+// come from float arithmetic and may be off by a few nanoseconds
+func approxDuration(got, want time.Duration) bool {
+	diff := got - want
+	return diff > -time.Millisecond && diff < time.Millisecond
+}
+
+func TestTokenBucketTake(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  PacingRule
+		takes []int
+		want  []time.Duration
+	}{
+		{"burst goes out at once", PacingRule{PerMinute: 60, Burst: 3}, []int{1, 1, 1}, []time.Duration{0, 0, 0}},
+		{"steady rate after the burst", PacingRule{PerMinute: 60, Burst: 2}, []int{1, 1, 1, 1}, []time.Duration{0, 0, time.Second, 2 * time.Second}},
+		{"batch waits for enough tokens", PacingRule{PerMinute: 120, Burst: 4}, []int{3, 3}, []time.Duration{0, time.Second}},
+		{"oversized batch leaves debt", PacingRule{PerMinute: 60, Burst: 2}, []int{5, 1}, []time.Duration{0, 4 * time.Second}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bucket := &tokenBucket{rule: tt.rule, tokens: float64(tt.rule.Burst)}
+			for i, n := range tt.takes {
+				if got := bucket.take(n); !approxDuration(got, tt.want[i]) {
+					t.Errorf("take #%d (%d) = %s, want %s", i+1, n, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParsePacingRule(t *testing.T) {
+	tests := []struct {
+		value        string
+		wantProvider string
+		wantRule     PacingRule
+		wantErr      bool
+	}{
+		{"gmail=120", providerGmail, PacingRule{PerMinute: 120, Burst: 12}, false},
+		{"yahoo=5", providerYahoo, PacingRule{PerMinute: 5, Burst: 1}, false},
+		{"other=100:7", providerOther, PacingRule{PerMinute: 100, Burst: 7}, false},
+		{"hotmail=100", "", PacingRule{}, true},
+		{"gmail", "", PacingRule{}, true},
+		{"gmail=0", "", PacingRule{}, true},
+		{"gmail=10:0", "", PacingRule{}, true},
+		{"gmail=10:x", "", PacingRule{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			provider, rule, err := parsePacingRule(tt.value)
+			if (err != nil) != tt.wantErr || provider != tt.wantProvider || rule != tt.wantRule {
+				t.Errorf("parsePacingRule() = %s, %+v, %v", provider, rule, err)
+			}
+		})
+	}
+}
+
+func TestProviderClassifier(t *testing.T) {
+	c := newProviderClassifier(false)
+	c.lookupMX = func(ctx context.Context, domain string) ([]*net.MX, error) {
+		switch domain {
+		case "acme.com":
+			return []*net.MX{{Host: "aspmx.l.google.com."}}, nil
+		case "contoso.com":
+			return []*net.MX{{Host: "contoso-com.mail.protection.outlook.com"}}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	c.Prepare([]string{"a@acme.com", "b@contoso.com", "c@unknown.test", "d@gmail.com"})
+
+	tests := map[string]string{
+		"x@Gmail.com":       providerGmail,
+		"x@yahoo.co.uk":     providerYahoo,
+		"x@icloud.com":      providerApple,
+		"x@acme.com":        providerGmail,
+		"x@contoso.com":     providerMicrosoft,
+		"x@unknown.test":    providerOther,
+		"x@not-prepared.io": providerOther,
+	}
+	for email, want := range tests {
+		if got := c.Provider(email); got != want {
+			t.Errorf("Provider(%s) = %s, want %s", email, got, want)
+		}
+	}
+}
+
+func TestPacerSchedule(t *testing.T) {
+	batch := func(emails ...string) bulkBatch {
+		var recipients []sendpost.Recipient
+		for _, email := range emails {
+			r := sendpost.NewRecipient()
+			r.SetEmail(email)
+			recipients = append(recipients, *r)
+		}
+		return bulkBatch{recipients: recipients}
+	}
+	pacer := NewPacer(map[string]PacingRule{
+		providerGmail: {PerMinute: 60, Burst: 1},
+		providerOther: {PerMinute: 6000, Burst: 100},
+	}, false)
+
+	batches := []bulkBatch{
+		batch("a@gmail.com"),
+		batch("b@gmail.com"),
+		batch("c@example.com"),
+		batch("d@example.com", "e@gmail.com"),
+	}
+	want := []time.Duration{0, time.Second, 0, 2 * time.Second}
+	offsets := pacer.Schedule(batches)
+	for i := range want {
+		if !approxDuration(offsets[i], want[i]) {
+			t.Errorf("batch %d offset = %s, want %s", i, offsets[i], want[i])
+		}
+	}
+	if rule := pacer.rules[providerMicrosoft]; rule != defaultPacingRules[providerMicrosoft] {
+		t.Errorf("microsoft rule = %+v, want the default", rule)
+	}
+}
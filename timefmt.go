@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// Time layouts of printed output
+const (
+	displayTimeLayout = "2006-01-02 15:04:05 MST"
+	displayDateLayout = "Mon, 02 Jan 2006"
+	statDateLayout    = "2006-01-02"
+)
+
+// setDisplayZone makes name the zone of every printed time and of times given without
+// an offset (--send-at, --since, --until); "" keeps SENDPOST_TZ or the system zone
+//
+// It replaces time.Local, so code that formats t.Local() or parses with time.Local
+// follows the --tz flag without threading a location through.
+func setDisplayZone(name string) error {
+	if name == "" {
+		name = os.Getenv("SENDPOST_TZ")
+	}
+	if name == "" {
+		return nil
+	}
+	if strings.EqualFold(name, "local") {
+		return nil
+	}
+	if strings.EqualFold(name, "utc") {
+		name = "UTC"
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return fmt.Errorf("unknown time zone %q: use an IANA name such as Europe/Berlin, UTC or local", name)
+	}
+	time.Local = loc
+	return nil
+}
+
+// extractTimezoneFlag removes a global --tz ZONE (or --tz=ZONE) from the arguments and
+// returns the remaining arguments and the zone
+func extractTimezoneFlag(args []string) ([]string, string, error) {
+	rest := make([]string, 0, len(args))
+	zone := ""
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--tz" || arg == "-tz":
+			if i+1 >= len(args) {
+				return nil, "", fmt.Errorf("--tz needs a time zone")
+			}
+			zone = args[i+1]
+			i++
+		case strings.HasPrefix(arg, "--tz=") || strings.HasPrefix(arg, "-tz="):
+			zone = arg[strings.Index(arg, "=")+1:]
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	return rest, zone, nil
+}
+
+// epochTime converts an API timestamp to a time
+// The API reports some timestamps in seconds and others in milliseconds or nanoseconds,
+// so the unit is taken from the magnitude.
+func epochTime(value int64) time.Time {
+	switch {
+	case value <= 0:
+		return time.Time{}
+	case value < 1e11:
+		return time.Unix(value, 0)
+	case value < 1e14:
+		return time.UnixMilli(value)
+	case value < 1e17:
+		return time.UnixMicro(value)
+	default:
+		return time.Unix(0, value)
+	}
+}
+
+// formatTime formats a time for detail views, with how long ago it was
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(displayTimeLayout) + " (" + relativeTime(t, time.Now()) + ")"
+}
+
+// formatTableTime formats a time for a table column, without the relative time
+func formatTableTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(displayTimeLayout)
+}
+
+// formatRFC3339 formats a time for CSV, JSON and other structured output; zero is ""
+func formatRFC3339(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Local().Format(time.RFC3339)
+}
+
+// formatEpoch formats an API timestamp for detail views, keeping the raw value
+func formatEpoch(value int64) string {
+	if value <= 0 {
+		return fmt.Sprintf("- (%d)", value)
+	}
+	return fmt.Sprintf("%s [%d]", formatTime(epochTime(value)), value)
+}
+
+// formatStatDate formats the date of a stats record, which the API reports as
+// YYYY-MM-DD; other values are printed unchanged
+func formatStatDate(value string) string {
+	day, err := time.ParseInLocation(statDateLayout, value, time.Local)
+	if err != nil {
+		if at, err := time.Parse(time.RFC3339, value); err == nil {
+			return formatTime(at)
+		}
+		return value
+	}
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	days := int(math.Round(today.Sub(day).Hours() / 24))
+	switch {
+	case days == 0:
+		return day.Format(displayDateLayout) + " (today)"
+	case days == 1:
+		return day.Format(displayDateLayout) + " (yesterday)"
+	case days > 1:
+		return fmt.Sprintf("%s (%dd ago)", day.Format(displayDateLayout), days)
+	default:
+		return day.Format(displayDateLayout)
+	}
+}
+
+// relativeTime describes t relative to now, such as "3h ago" or "in 20m"
+func relativeTime(t, now time.Time) string {
+	d := now.Sub(t)
+	suffix := " ago"
+	if d < 0 {
+		d, suffix = -d, ""
+	}
+
+	var amount string
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		amount = fmt.Sprintf("%dm", int(d/time.Minute))
+	case d < 24*time.Hour:
+		amount = fmt.Sprintf("%dh", int(d/time.Hour))
+	case d < 30*24*time.Hour:
+		amount = fmt.Sprintf("%dd", int(d/(24*time.Hour)))
+	case d < 365*24*time.Hour:
+		amount = fmt.Sprintf("%dmo", int(d/(30*24*time.Hour)))
+	default:
+		amount = fmt.Sprintf("%dy", int(d/(365*24*time.Hour)))
+	}
+	if suffix == "" {
+		return "in " + amount
+	}
+	return amount + suffix
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// keepDisplayZone restores time.Local after a test that changes the display zone
+func keepDisplayZone(t *testing.T) {
+	t.Helper()
+	local := time.Local
+	t.Cleanup(func() { time.Local = local })
+}
+
+func TestSetDisplayZone(t *testing.T) {
+	tests := []struct {
+		name     string
+		flag     string
+		env      string
+		wantZone string
+		wantErr  bool
+	}{
+		{"iana name", "Europe/Berlin", "", "Europe/Berlin", false},
+		{"utc in any case", "utc", "", "UTC", false},
+		{"environment", "", "Asia/Tokyo", "Asia/Tokyo", false},
+		{"flag wins over environment", "America/New_York", "Asia/Tokyo", "America/New_York", false},
+		{"local keeps the zone", "Local", "Asia/Tokyo", "", false},
+		{"unknown zone", "Mars/Olympus", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keepDisplayZone(t)
+			t.Setenv("SENDPOST_TZ", tt.env)
+			before := time.Local
+			err := setDisplayZone(tt.flag)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("setDisplayZone(%q) error = %v", tt.flag, err)
+			}
+			if tt.wantZone == "" {
+				if time.Local != before {
+					t.Errorf("zone changed to %s, want it kept", time.Local)
+				}
+				return
+			}
+			if time.Local.String() != tt.wantZone {
+				t.Errorf("zone = %s, want %s", time.Local, tt.wantZone)
+			}
+		})
+	}
+}
+
+func TestExtractTimezoneFlag(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		wantRest string
+		wantZone string
+		wantErr  bool
+	}{
+		{"no flag", []string{"stats", "account"}, "stats account", "", false},
+		{"equals form first", []string{"--tz=UTC", "stats", "account"}, "stats account", "UTC", false},
+		{"separate value in the middle", []string{"messages", "--tz", "Europe/Berlin", "search", "--to", "a"}, "messages search --to a", "Europe/Berlin", false},
+		{"separate value at the end", []string{"stats", "account", "--tz", "Asia/Tokyo"}, "stats account", "Asia/Tokyo", false},
+		{"single dash", []string{"-tz=UTC", "stats"}, "stats", "UTC", false},
+		{"last one wins", []string{"--tz", "UTC", "stats", "--tz=Asia/Tokyo"}, "stats", "Asia/Tokyo", false},
+		{"empty equals form", []string{"stats", "--tz="}, "stats", "", false},
+		{"similar flag is kept", []string{"send", "bulk", "--tz-field", "zone"}, "send bulk --tz-field zone", "", false},
+		{"missing value at the end", []string{"stats", "account", "--tz"}, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rest, zone, err := extractTimezoneFlag(tt.args)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("extractTimezoneFlag() = %v, %q; want an error", rest, zone)
+				}
+				return
+			}
+			if err != nil || strings.Join(rest, " ") != tt.wantRest || zone != tt.wantZone {
+				t.Errorf("extractTimezoneFlag() = %q, %q, %v; want %q, %q", rest, zone, err, tt.wantRest, tt.wantZone)
+			}
+		})
+	}
+}
+
+func TestEpochTime(t *testing.T) {
+	tests := []struct {
+		name  string
+		value int64
+		want  time.Time
+	}{
+		{"zero", 0, time.Time{}},
+		{"negative", -5, time.Time{}},
+		{"seconds", 1792310400, time.Unix(1792310400, 0)},
+		{"largest seconds", 1e11 - 1, time.Unix(1e11-1, 0)},
+		{"smallest milliseconds", 1e11, time.UnixMilli(1e11)},
+		{"milliseconds", 1792310400123, time.UnixMilli(1792310400123)},
+		{"largest milliseconds", 1e14 - 1, time.UnixMilli(1e14 - 1)},
+		{"smallest microseconds", 1e14, time.UnixMicro(1e14)},
+		{"microseconds", 1792310400123456, time.UnixMicro(1792310400123456)},
+		{"largest microseconds", 1e17 - 1, time.UnixMicro(1e17 - 1)},
+		{"smallest nanoseconds", 1e17, time.Unix(0, 1e17)},
+		{"nanoseconds", 1792310400123456789, time.Unix(0, 1792310400123456789)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := epochTime(tt.value); !got.Equal(tt.want) {
+				t.Errorf("epochTime(%d) = %s, want %s", tt.value, got, tt.want)
+			}
+		})
+	}
+
+	// The same instant in every unit gives the same time
+	at := time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC)
+	for _, value := range []int64{at.Unix(), at.UnixMilli(), at.UnixMicro(), at.UnixNano()} {
+		if got := epochTime(value); !got.Equal(at) {
+			t.Errorf("epochTime(%d) = %s, want %s", value, got.UTC(), at)
+		}
+	}
+}
+
+func TestFormatStatDate(t *testing.T) {
+	now := time.Now()
+	day := func(offset int) string {
+		return time.Date(now.Year(), now.Month(), now.Day()+offset, 0, 0, 0, 0, time.Local).Format(statDateLayout)
+	}
+	heading := func(offset int) string {
+		return time.Date(now.Year(), now.Month(), now.Day()+offset, 0, 0, 0, 0, time.Local).Format(displayDateLayout)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"today", day(0), heading(0) + " (today)"},
+		{"yesterday", day(-1), heading(-1) + " (yesterday)"},
+		{"days ago", day(-3), heading(-3) + " (3d ago)"},
+		{"across a month", day(-40), heading(-40) + " (40d ago)"},
+		{"future", day(2), heading(2)},
+		{"not a date", "week 42", "week 42"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatStatDate(tt.value); got != tt.want {
+				t.Errorf("formatStatDate(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+
+	// Timestamps are shown as times
+	if got := formatStatDate("2026-10-18T09:30:00Z"); !strings.HasPrefix(got, time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC).Local().Format(displayTimeLayout)) {
+		t.Errorf("formatStatDate(RFC 3339) = %q", got)
+	}
+}
+
+func TestRelativeTime(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		offset time.Duration
+		want   string
+	}{
+		{0, "just now"},
+		{-59 * time.Second, "just now"},
+		{59 * time.Second, "just now"},
+		{-time.Minute, "1m ago"},
+		{-59 * time.Minute, "59m ago"},
+		{-time.Hour, "1h ago"},
+		{-23 * time.Hour, "23h ago"},
+		{-24 * time.Hour, "1d ago"},
+		{-29 * 24 * time.Hour, "29d ago"},
+		{-30 * 24 * time.Hour, "1mo ago"},
+		{-364 * 24 * time.Hour, "12mo ago"},
+		{-365 * 24 * time.Hour, "1y ago"},
+		{20 * time.Minute, "in 20m"},
+		{3 * 24 * time.Hour, "in 3d"},
+	}
+	for _, tt := range tests {
+		if got := relativeTime(now.Add(tt.offset), now); got != tt.want {
+			t.Errorf("relativeTime(%s) = %q, want %q", tt.offset, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	sendpost "github.com/sendpost/sendpost-go-sdk"
+)
+
+// Unsubscribe settings
+const (
+	defaultUnsubscribeAddr    = "127.0.0.1:8026"
+	minUnsubscribeSecret      = 16
+	listUnsubscribeHeader     = "List-Unsubscribe"
+	listUnsubscribePostHeader = "List-Unsubscribe-Post"
+	listUnsubscribeOneClick   = "List-Unsubscribe=One-Click"
+	unsubscribeRequestTimeout = 15 * time.Second
+)
+
+// UnsubscribeLinks signs the per-recipient URLs of List-Unsubscribe headers (RFC 2369,
+// one-click per RFC 8058)
+//
+// A link carries the recipient address, the list it came from (the message's subscription
+// categories, comma-separated) and an HMAC-SHA256 signature over both, so the handler can
+// trust it without storing tokens. The same link opens the preference page.
+type UnsubscribeLinks struct {
+	BaseURL string
+	Mailto  string
+	Secret  []byte
+}
+
+// unsubscribeFromEnv configures unsubscribe links from SENDPOST_UNSUBSCRIBE_URL,
+// SENDPOST_UNSUBSCRIBE_SECRET and SENDPOST_UNSUBSCRIBE_MAILTO; it returns nil when no URL is set
+func unsubscribeFromEnv() (*UnsubscribeLinks, error) {
+	baseURL := strings.TrimSpace(os.Getenv("SENDPOST_UNSUBSCRIBE_URL"))
+	if baseURL == "" {
+		return nil, nil
+	}
+
+	parsed, err := url.Parse(baseURL)
+	if err != nil || parsed.Host == "" {
+		return nil, fmt.Errorf("SENDPOST_UNSUBSCRIBE_URL %q is not an absolute URL", baseURL)
+	}
+	// Mailbox providers only honour one-click unsubscribe over HTTPS
+	if parsed.Scheme != "https" && !isLoopbackHost(parsed.Hostname()) {
+		return nil, fmt.Errorf("SENDPOST_UNSUBSCRIBE_URL must use https")
+	}
+
+	links := &UnsubscribeLinks{
+		BaseURL: baseURL,
+		Mailto:  strings.TrimSpace(os.Getenv("SENDPOST_UNSUBSCRIBE_MAILTO")),
+		Secret:  []byte(os.Getenv("SENDPOST_UNSUBSCRIBE_SECRET")),
+	}
+	if len(links.Secret) < minUnsubscribeSecret {
+		return nil, fmt.Errorf("SENDPOST_UNSUBSCRIBE_SECRET must be at least %d characters", minUnsubscribeSecret)
+	}
+	if links.Mailto != "" {
+		if _, err := mail.ParseAddress(links.Mailto); err != nil {
+			return nil, fmt.Errorf("SENDPOST_UNSUBSCRIBE_MAILTO %q is not a valid address", links.Mailto)
+		}
+	}
+	return links, nil
+}
+
+// isLoopbackHost reports whether a host name refers to the local machine
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// sign returns the signature of an unsubscribe link
+func (u *UnsubscribeLinks) sign(email, list string) string {
+	mac := hmac.New(sha256.New, u.Secret)
+	mac.Write([]byte(strings.ToLower(email) + "\x00" + list))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether a link's signature matches its address and list
+func (u *UnsubscribeLinks) Verify(email, list, signature string) bool {
+	return email != "" && hmac.Equal([]byte(u.sign(email, list)), []byte(signature))
+}
+
+// URL returns the signed unsubscribe URL of one recipient
+func (u *UnsubscribeLinks) URL(email, list string) string {
+	link, _ := url.Parse(u.BaseURL)
+	query := link.Query()
+	query.Set("email", email)
+	if list != "" {
+		query.Set("list", list)
+	}
+	query.Set("sig", u.sign(email, list))
+	link.RawQuery = query.Encode()
+	return link.String()
+}
+
+// Headers returns the List-Unsubscribe and List-Unsubscribe-Post headers of one recipient
+func (u *UnsubscribeLinks) Headers(email, list string) map[string]string {
+	targets := []string{"<" + u.URL(email, list) + ">"}
+	if u.Mailto != "" {
+		targets = append(targets, "<mailto:"+u.Mailto+"?subject=unsubscribe>")
+	}
+	return map[string]string{
+		listUnsubscribeHeader:     strings.Join(targets, ", "),
+		listUnsubscribePostHeader: listUnsubscribeOneClick,
+	}
+}
+
+// Tag adds the unsubscribe headers of a message's only recipient to a builder
+// The headers apply to the whole request, so the message must have a single recipient.
+func (u *UnsubscribeLinks) Tag(builder *EmailBuilder, email, list string) *EmailBuilder {
+	for name, value := range u.Headers(email, list) {
+		builder.Header(name, value)
+	}
+	return builder
+}
+
+// preferencePage lets a recipient choose the categories they receive
+var preferencePage = template.Must(template.New("preferences").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Email preferences</title>
+<style>
+body { font-family: sans-serif; max-width: 32em; margin: 4em auto; padding: 0 1em; color: #222; }
+label { display: block; margin: .75em 0; }
+small { display: block; margin-left: 1.6em; color: #666; }
+button { font-size: 1em; padding: .5em 1.5em; margin: 1em .5em 0 0; }
+.notice { background: #e6f4ea; padding: .75em 1em; }
+</style>
+</head>
+<body>
+<h1>Email preferences</h1>
+{{if .Notice}}<p class="notice">{{.Notice}}</p>{{end}}
+<p>Choose the email {{.Email}} receives from us. Order confirmations and account messages are always sent.</p>
+<form method="post">
+{{range .Categories}}<label><input type="checkbox" name="category" value="{{.Name}}"{{if .Subscribed}} checked{{end}}> {{.Name}}<small>{{.Description}}</small></label>
+{{end}}<button type="submit" name="action" value="save">Save preferences</button>
+<button type="submit" name="action" value="all">Unsubscribe from all</button>
+</form>
+</body>
+</html>
+`))
+
+// preferenceChoice is one category on the preference page
+type preferenceChoice struct {
+	SubscriptionCategory
+	Subscribed bool
+}
+
+// UnsubscribeHandler serves unsubscribe links as a preference center
+//
+// A link names the categories of the message it came from. A one-click POST
+// (List-Unsubscribe=One-Click, RFC 8058) opts the recipient out of those categories only,
+// so other mail keeps arriving; links without a known category add the address to the
+// sub-account's unsubscribe suppression list instead. GET shows the preference page
+// rather than unsubscribing, because link scanners and previews fetch URLs in mail.
+func (e *ESPExample) UnsubscribeHandler(links *UnsubscribeLinks) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		email, list := query.Get("email"), query.Get("list")
+		if !links.Verify(email, list, query.Get("sig")) {
+			http.Error(w, "This unsubscribe link is invalid.", http.StatusBadRequest)
+			return
+		}
+
+		categories, err := e.preferences.Categories()
+		if err != nil {
+			fmt.Printf("✗ Failed to load preferences:\n")
+			fmt.Printf("  Error: %v\n", err)
+			http.Error(w, "Preferences are not available, please try again later.", http.StatusServiceUnavailable)
+			return
+		}
+		var all, linked []string
+		for _, category := range categories {
+			all = append(all, category.Name)
+			if containsFold(strings.Split(list, ","), category.Name) {
+				linked = append(linked, category.Name)
+			}
+		}
+
+		notice := ""
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodPost:
+			var optOut, optIn []string
+			switch {
+			case r.PostFormValue("List-Unsubscribe") == "One-Click":
+				optOut, notice = linked, "You are unsubscribed."
+			case r.PostFormValue("action") == "all":
+				optOut, notice = all, "You are unsubscribed from all marketing email."
+			case r.PostFormValue("action") == "save":
+				chosen := r.PostForm["category"]
+				for _, name := range all {
+					if containsFold(chosen, name) {
+						optIn = append(optIn, name)
+					} else {
+						optOut = append(optOut, name)
+					}
+				}
+				notice = "Your preferences are saved."
+			default:
+				http.Error(w, "Missing List-Unsubscribe=One-Click.", http.StatusBadRequest)
+				return
+			}
+
+			if len(optOut) == 0 && len(optIn) == 0 {
+				err = e.suppressUnsubscribe(email)
+			} else {
+				_, err = e.preferences.Update(email, optOut, optIn)
+			}
+			if err != nil {
+				fmt.Printf("✗ Failed to update preferences of %s (list %q):\n", email, list)
+				fmt.Printf("  Error: %v\n", err)
+				// A temporary failure makes mailbox providers retry the request
+				http.Error(w, "Updating your preferences failed, please try again later.", http.StatusServiceUnavailable)
+				return
+			}
+			switch {
+			case len(optOut) == 0 && len(optIn) == 0:
+				fmt.Printf("✓ Unsubscribed %s (suppression list)\n", email)
+			case len(optOut) > 0:
+				fmt.Printf("✓ %s opted out of %s\n", email, strings.Join(optOut, ", "))
+			default:
+				fmt.Printf("✓ %s subscribed to every category\n", email)
+			}
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+			return
+		}
+
+		preferences, err := e.preferences.Get(email)
+		if err != nil {
+			http.Error(w, "Preferences are not available, please try again later.", http.StatusServiceUnavailable)
+			return
+		}
+		data := struct {
+			Email      string
+			Notice     string
+			Categories []preferenceChoice
+		}{Email: email, Notice: notice}
+		for _, category := range categories {
+			subscribed := firstOptOut(preferences, []string{category.Name}) == ""
+			data.Categories = append(data.Categories, preferenceChoice{category, subscribed})
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		preferencePage.Execute(w, data)
+	})
+}
+
+// suppressUnsubscribe adds an address to the unsubscribe suppression list
+func (e *ESPExample) suppressUnsubscribe(email string) error {
+	entry := sendpost.NewCreateSuppressionRequestUnsubscribeInner()
+	entry.SetEmail(email)
+	request := sendpost.NewCreateSuppressionRequest()
+	request.SetUnsubscribe([]sendpost.CreateSuppressionRequestUnsubscribeInner{*entry})
+
+	ctx, cancel := context.WithTimeout(e.createSubAccountAuthContext(), unsubscribeRequestTimeout)
+	defer cancel()
+	_, resp, err := e.client.SuppressionAPI.CreateSuppression(ctx).CreateSuppressionRequest(*request).Execute()
+	if err != nil && resp != nil {
+		return fmt.Errorf("status %d: %w", resp.StatusCode, err)
+	}
+	return err
+}
+
+// ServeUnsubscribe runs the unsubscribe handler and preference page until interrupted
+func (e *ESPExample) ServeUnsubscribe(links *UnsubscribeLinks, addr string) error {
+	fmt.Println("\n=== Unsubscribe Handler ===")
+	server := &http.Server{Addr: addr, Handler: e.UnsubscribeHandler(links), ReadHeaderTimeout: 10 * time.Second}
+	stopCtx, stop := interruptContext("stopping the unsubscribe handler")
+	defer stop()
+	go func() {
+		<-stopCtx.Done()
+		server.Close()
+	}()
+
+	fmt.Printf("Listening on http://%s for links to %s (Ctrl+C to stop)\n", addr, links.BaseURL)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	fmt.Println("✓ Unsubscribe handler stopped")
+	return nil
+}
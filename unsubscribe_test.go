@@ -0,0 +1,106 @@
+package main
+
+import (
+	"html"
+	"net/url"
+	"strings"
+	"testing"
+
+	sendpost "github.com/sendpost/sendpost-go-sdk"
+)
+
+func testUnsubscribeLinks() *UnsubscribeLinks {
+	return &UnsubscribeLinks{BaseURL: "https://example.com/unsubscribe?source=email", Secret: []byte("0123456789abcdef")}
+}
+
+func TestUnsubscribeVerify(t *testing.T) {
+	links := testUnsubscribeLinks()
+	link, err := url.Parse(links.URL("Anna@Example.com", "promotional"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := link.Query()
+	if query.Get("source") != "email" || query.Get("email") != "Anna@Example.com" || query.Get("list") != "promotional" {
+		t.Fatalf("URL query = %v", query)
+	}
+	sig := query.Get("sig")
+
+	other := &UnsubscribeLinks{BaseURL: links.BaseURL, Secret: []byte("another-secret-value")}
+	tests := []struct {
+		name  string
+		links *UnsubscribeLinks
+		email string
+		list  string
+		sig   string
+		want  bool
+	}{
+		{"valid", links, "Anna@Example.com", "promotional", sig, true},
+		{"address case ignored", links, "anna@example.com", "promotional", sig, true},
+		{"other address", links, "bob@example.com", "promotional", sig, false},
+		{"other list", links, "Anna@Example.com", "promotional,newsletter", sig, false},
+		{"no list", links, "Anna@Example.com", "", sig, false},
+		{"tampered signature", links, "Anna@Example.com", "promotional", sig[:len(sig)-1] + "A", false},
+		{"empty signature", links, "Anna@Example.com", "promotional", "", false},
+		{"empty address", links, "", "promotional", links.sign("", "promotional"), false},
+		{"other secret", other, "Anna@Example.com", "promotional", sig, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.links.Verify(tt.email, tt.list, tt.sig); got != tt.want {
+				t.Errorf("Verify() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnsubscribeHeaders(t *testing.T) {
+	links := testUnsubscribeLinks()
+	links.Mailto = "unsubscribe@example.com"
+	headers := links.Headers("anna@example.com", "newsletter")
+	want := "<" + links.URL("anna@example.com", "newsletter") + ">, <mailto:unsubscribe@example.com?subject=unsubscribe>"
+	if headers[listUnsubscribeHeader] != want {
+		t.Errorf("%s = %q, want %q", listUnsubscribeHeader, headers[listUnsubscribeHeader], want)
+	}
+	if headers[listUnsubscribePostHeader] != listUnsubscribeOneClick {
+		t.Errorf("%s = %q", listUnsubscribePostHeader, headers[listUnsubscribePostHeader])
+	}
+}
+
+func TestPlanBulkAddsUnsubscribeLinks(t *testing.T) {
+	e := newTestExample(t, jsonHandler(200, `[]`))
+	e.unsubscribe = testUnsubscribeLinks()
+
+	var recipients []sendpost.Recipient
+	for _, email := range []string{"anna@example.com", "bob@example.com"} {
+		r := sendpost.NewRecipient()
+		r.SetEmail(email)
+		r.SetName("Customer")
+		r.SetCustomFields(map[string]interface{}{"discount_code": "SAVE20"})
+		recipients = append(recipients, *r)
+	}
+	opts := BulkOptions{Template: "special-offer", FromEmail: "sender@example.com", Groups: []string{"promotional"}}
+
+	batches, failed, err := e.planBulk(opts, recipients)
+	if err != nil || len(failed) != 0 {
+		t.Fatalf("planBulk() failed = %v, err = %v", failed, err)
+	}
+	if len(batches) != len(recipients) {
+		t.Fatalf("got %d batches, want one per recipient", len(batches))
+	}
+	for _, batch := range batches {
+		email := batch.recipients[0].GetEmail()
+		link := e.unsubscribe.URL(email, "promotional")
+		if got := headerValue(batch.message, listUnsubscribeHeader); got != "<"+link+">" {
+			t.Errorf("%s: %s = %q, want the recipient's link", email, listUnsubscribeHeader, got)
+		}
+		if got := headerValue(batch.message, listUnsubscribePostHeader); got != listUnsubscribeOneClick {
+			t.Errorf("%s: %s = %q", email, listUnsubscribePostHeader, got)
+		}
+		if body := batch.message.GetHtmlBody(); !strings.Contains(body, html.EscapeString(link)) {
+			t.Errorf("%s: footer does not link to the preference page:\n%s", email, body)
+		}
+	}
+	if _, ok := recipients[0].GetCustomFields()["preferences_url"]; ok {
+		t.Error("planBulk changed the caller's recipients")
+	}
+}